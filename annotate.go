@@ -0,0 +1,80 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// annotationMetadataPrefix prefixes the metadata attributes of an index
+// entry that hold its annotations.
+const annotationMetadataPrefix = "annotation."
+
+// maxAnnotateRetries is the number of times AnnotateJobResult retries when
+// the entry is changed concurrently.
+const maxAnnotateRetries = 5
+
+// AnnotateJobResult merges annotations into the job-state index entry at
+// indexPath, replacing annotations with the same names. Each annotation is
+// also stored as a metadata attribute prefixed with "annotation.". The entry
+// is only replaced if it has not changed since it was read; concurrent
+// changes are retried so that they are preserved.
+func AnnotateJobResult(ctx context.Context, client StorageClient, bucket, indexPath string, annotations map[string]string) error {
+	for retries := 0; ; retries++ {
+		attrs, err := client.Attrs(ctx, bucket, indexPath)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", indexPath, err)
+		}
+		data, err := readIndexEntry(ctx, client, bucket, indexPath)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", indexPath, err)
+		}
+		var result JobResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("unable to decode %s: %v", indexPath, err)
+		}
+		if result.Annotations == nil {
+			result.Annotations = make(map[string]string, len(annotations))
+		}
+		metadata := make(map[string]string, len(attrs.Metadata)+len(annotations))
+		for k, v := range attrs.Metadata {
+			metadata[k] = v
+		}
+		// keep the version of the indexer that wrote the entry, which is
+		// empty for entries that predate indexer-version
+		metadata["indexer-version"] = attrs.Metadata["indexer-version"]
+		for k, v := range annotations {
+			result.Annotations[k] = v
+			metadata[annotationMetadataPrefix+k] = v
+		}
+		if data, err = json.Marshal(result); err != nil {
+			return fmt.Errorf("could not serialize job result: %v", err)
+		}
+		err = IndexWriter{Client: client, Bucket: bucket}.Write(ctx, indexPath, data, metadata, &storage.Conditions{GenerationMatch: attrs.Generation})
+		if err = wrapPreconditionFailure(err); IsPreconditionFailure(err) && retries < maxAnnotateRetries {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to annotate %s: %w", indexPath, err)
+		}
+		return nil
+	}
+}
+
+// annotationsFromMetadata returns the annotations stored in the metadata of
+// an index entry, or nil if there are none.
+func annotationsFromMetadata(metadata map[string]string) map[string]string {
+	var annotations map[string]string
+	for k, v := range metadata {
+		if name := strings.TrimPrefix(k, annotationMetadataPrefix); name != k {
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[name] = v
+		}
+	}
+	return annotations
+}
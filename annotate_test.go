@@ -0,0 +1,83 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+// annotatingClient annotates the entry once when its attributes are first
+// read, simulating a concurrent operator.
+type annotatingClient struct {
+	*FakeGCSClient
+	annotated bool
+}
+
+func (c *annotatingClient) Attrs(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error) {
+	attrs, err := c.FakeGCSClient.Attrs(ctx, bucket, name)
+	if err == nil && !c.annotated {
+		c.annotated = true
+		if err := AnnotateJobResult(ctx, c.FakeGCSClient, bucket, name, map[string]string{"owner": "sippy"}); err != nil {
+			return nil, err
+		}
+	}
+	return attrs, err
+}
+
+func TestAnnotateJobResult(t *testing.T) {
+	newClient := func() *FakeGCSClient {
+		client := NewFakeGCSClient()
+		client.put("bucket", testStatePath, []byte(`{"state":"failed","completed_at":1705320000,"link":"gs://bucket/logs/job/1000"}`), map[string]string{
+			"link":  "gs://bucket/logs/job/1000",
+			"state": "failed",
+		})
+		return client
+	}
+	check := func(t *testing.T, client *FakeGCSClient, expect map[string]string) {
+		t.Helper()
+		obj := client.get("bucket", testStatePath)
+		var result JobResult
+		if err := json.Unmarshal(obj.data, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.State != "failed" || !reflect.DeepEqual(result.Annotations, expect) {
+			t.Errorf("unexpected result %#v", result)
+		}
+		if fromAttrs := jobResultFromAttrs(&obj.attrs); fromAttrs.State != "failed" || !reflect.DeepEqual(fromAttrs.Annotations, expect) {
+			t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+		}
+		// annotating does not make an unversioned entry current for reindexing
+		if version := obj.attrs.Metadata["indexer-version"]; version != "" {
+			t.Errorf("unexpected indexer-version %s", version)
+		}
+	}
+
+	t.Run("add and update", func(t *testing.T) {
+		client := newClient()
+		if err := AnnotateJobResult(context.TODO(), client, "bucket", testStatePath, map[string]string{"known-failure": "true"}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, client, map[string]string{"known-failure": "true"})
+		if err := AnnotateJobResult(context.TODO(), client, "bucket", testStatePath, map[string]string{"known-failure": "false", "investigation": "OCPBUGS-1234"}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, client, map[string]string{"known-failure": "false", "investigation": "OCPBUGS-1234"})
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		client := newClient()
+		if err := AnnotateJobResult(context.TODO(), &annotatingClient{FakeGCSClient: client}, "bucket", testStatePath, map[string]string{"investigation": "OCPBUGS-1234"}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, client, map[string]string{"owner": "sippy", "investigation": "OCPBUGS-1234"})
+	})
+
+	t.Run("missing entry", func(t *testing.T) {
+		if err := AnnotateJobResult(context.TODO(), NewFakeGCSClient(), "bucket", testStatePath, map[string]string{"a": "b"}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
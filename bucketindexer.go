@@ -0,0 +1,177 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// GCSBucketIndexer groups the indexing operations on a single bucket.
+type GCSBucketIndexer struct {
+	Bucket string
+	Client StorageClient
+	Opts   Options
+}
+
+// NewGCSBucketIndexer returns an indexer for bucket using DefaultOptions
+// modified by opts. If no client is configured a GCS client is created.
+func NewGCSBucketIndexer(ctx context.Context, bucket string, opts ...func(*Options)) (*GCSBucketIndexer, error) {
+	o := DefaultOptions()
+	for _, fn := range opts {
+		fn(&o)
+	}
+	client, err := o.storageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.Client = client
+	return &GCSBucketIndexer{Bucket: bucket, Client: client, Opts: o}, nil
+}
+
+// options returns the indexing options with the indexer's client.
+func (i *GCSBucketIndexer) options() Options {
+	opts := i.Opts
+	opts.Client = i.Client
+	return opts
+}
+
+// IndexEvent indexes e as IndexJobs does. Events without a bucket are
+// assumed to be for the indexer's bucket.
+func (i *GCSBucketIndexer) IndexEvent(ctx context.Context, e GCSEvent) error {
+	if e.Bucket == "" {
+		e.Bucket = i.Bucket
+	}
+	if err := IndexJobsWithOptions(ctx, e, i.options()); !IsPreconditionFailure(err) {
+		return err
+	}
+	return nil
+}
+
+// Backfill indexes every object under prefix that was updated at or after
+// since, as if an event had been received for it. Objects that are already
+// indexed are skipped.
+func (i *GCSBucketIndexer) Backfill(ctx context.Context, prefix string, since time.Time) error {
+	objects, err := i.Client.List(ctx, i.Bucket, &storage.Query{Prefix: prefix})
+	if err != nil {
+		return fmt.Errorf("unable to list %s: %v", prefix, err)
+	}
+	router := newIndexRouter(i.options())
+	var indexed int
+	for _, attrs := range objects {
+		if attrs.Name == "" || attrs.Updated.Before(since) {
+			continue
+		}
+		e := GCSEvent{
+			Bucket:          i.Bucket,
+			Name:            attrs.Name,
+			ContentType:     attrs.ContentType,
+			ContentEncoding: attrs.ContentEncoding,
+			Size:            strconv.FormatInt(attrs.Size, 10),
+			TimeCreated:     attrs.Created,
+			Updated:         attrs.Updated,
+		}
+		if err := router.Dispatch(ctx, e); err != nil && !IsPreconditionFailure(err) {
+			return fmt.Errorf("unable to index %s: %v", attrs.Name, err)
+		}
+		indexed++
+	}
+	log.Printf("Backfilled %d objects under gs://%s/%s", indexed, i.Bucket, prefix)
+	return nil
+}
+
+// prunedIndex is an index deleted by PruneOlderThan. The keys of a nested
+// index follow the name of a variant or metric.
+type prunedIndex struct {
+	index  string
+	nested bool
+}
+
+// prunedIndices are the indices of builds that PruneOlderThan deletes, which
+// must include every index keyed by time that is written by default.
+var prunedIndices = []prunedIndex{
+	{index: "job-state"},
+	{index: "job-metrics"},
+	{index: "job-running"},
+	{index: "k8s-events"},
+	{index: "cluster-data"},
+	{index: "variant", nested: true},
+	{index: "metrics", nested: true},
+}
+
+// PruneOlderThan deletes the entries of the prunedIndices, and of the
+// job-state index of each job type, with keys more than age before now.
+func (i *GCSBucketIndexer) PruneOlderThan(ctx context.Context, age time.Duration) error {
+	cutoff := time.Now().Add(-age)
+	indices := append([]prunedIndex(nil), prunedIndices...)
+	for _, jobType := range jobTypes {
+		indices = append(indices, prunedIndex{index: path.Join(jobType, "job-state")})
+	}
+	for _, index := range indices {
+		prefix := indexPrefix(i.Opts.IndexVersion, index.index) + "/"
+		objects, err := i.Client.List(ctx, i.Bucket, &storage.Query{Prefix: prefix})
+		if err != nil {
+			return fmt.Errorf("unable to list %s: %v", prefix, err)
+		}
+		for _, attrs := range objects {
+			parts := strings.SplitN(strings.TrimPrefix(attrs.Name, prefix), "/", 3)
+			if index.nested {
+				parts = parts[1:]
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, parts[0])
+			if err != nil || !t.Before(cutoff) {
+				continue
+			}
+			if err := i.Client.Delete(ctx, i.Bucket, attrs.Name); err != nil && err != storage.ErrObjectNotExist {
+				return fmt.Errorf("unable to delete %s: %v", attrs.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// JobStateSummary counts the builds that completed on a single day by state.
+type JobStateSummary struct {
+	Date   string         `json:"date"`
+	Total  int            `json:"total"`
+	States map[string]int `json:"states"`
+}
+
+// DailySummary counts the job-state index entries for date (YYYY-MM-DD) by
+// state and writes the JobStateSummary to index/daily-summary/<date>,
+// replacing any earlier summary. Both follow the IndexVersion and
+// LegacyIndexPath of the indexer's options.
+func (i *GCSBucketIndexer) DailySummary(ctx context.Context, date string) error {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return fmt.Errorf("invalid date %q: %v", date, err)
+	}
+	entries, err := listJobStates(ctx, i.Client, i.Bucket, i.Opts.layout(), date)
+	if err != nil {
+		return err
+	}
+	summary := JobStateSummary{Date: date, States: make(map[string]int)}
+	for _, entry := range entries {
+		summary.Total++
+		summary.States[entry.Attrs.Metadata["state"]]++
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("could not serialize daily summary: %v", err)
+	}
+	indexPath := path.Join(indexPrefix(i.Opts.IndexVersion, "daily-summary"), date)
+	if err := (IndexWriter{Client: i.Client, Bucket: i.Bucket}).Write(ctx, indexPath, data, map[string]string{
+		"total": strconv.Itoa(summary.Total),
+	}, nil); err != nil {
+		return fmt.Errorf("failed to write daily summary %s: %v", indexPath, err)
+	}
+	return nil
+}
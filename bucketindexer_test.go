@@ -0,0 +1,136 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestBucketIndexer(t *testing.T, client *FakeGCSClient) *GCSBucketIndexer {
+	indexer, err := NewGCSBucketIndexer(context.TODO(), "bucket", func(o *Options) {
+		o.Client = client
+		o.SkipArtifactSizeCalculation = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexer
+}
+
+func TestNewGCSBucketIndexer(t *testing.T) {
+	client := NewFakeGCSClient()
+	indexer := newTestBucketIndexer(t, client)
+	if indexer.Bucket != "bucket" || indexer.Client != client || !indexer.Opts.SkipArtifactSizeCalculation || indexer.Opts.IndexVersion != "v1" {
+		t.Errorf("unexpected indexer %#v", indexer)
+	}
+}
+
+func TestGCSBucketIndexer_IndexEvent(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	indexer := newTestBucketIndexer(t, client)
+	for i := 0; i < 2; i++ {
+		if err := indexer.IndexEvent(context.TODO(), GCSEvent{Name: testFinishedName}); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	if client.get("bucket", testStatePath) == nil {
+		t.Error("expected index entry")
+	}
+}
+
+func TestGCSBucketIndexer_Backfill(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", "logs/job/1001/finished.json", []byte(`{"timestamp":1705320060,"passed":false}`), nil)
+	client.put("bucket", "logs/job/1001/build-log.txt", []byte(`log`), nil)
+	client.put("bucket", "logs/job/999/finished.json", []byte(`{"timestamp":1705310000,"passed":true}`), nil)
+	client.get("bucket", "logs/job/999/finished.json").attrs.Updated = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// already indexed
+	client.put("bucket", testStatePath, []byte(`{}`), nil)
+
+	indexer := newTestBucketIndexer(t, client)
+	if err := indexer.Backfill(context.TODO(), "logs/job/", time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", "index/job-state/2024-01-15T12:01:00Z/job/1001") == nil {
+		t.Error("expected job 1001 to be indexed")
+	}
+	// job 1001 is also written to the index of its job type
+	if objects, _ := client.List(context.TODO(), "bucket", nil); len(objects) != 7 {
+		t.Errorf("expected only job 1001 to be indexed, found %d objects", len(objects))
+	}
+}
+
+func TestGCSBucketIndexer_PruneOlderThan(t *testing.T) {
+	client := NewFakeGCSClient()
+	now := time.Now().UTC()
+	old := now.Add(-48 * time.Hour).Format(time.RFC3339)
+	recent := now.Add(-time.Hour).Format(time.RFC3339)
+	for _, name := range []string{
+		"index/job-state/" + old + "/job/1",
+		"index/job-metrics/" + old + "/job/1",
+		"index/periodic/job-state/" + old + "/job/1",
+		"index/job-state/" + recent + "/job/2",
+		"index/job-metrics/" + recent + "/job/2",
+		"index/variant/aws/" + old + "/job/1",
+		"index/variant/aws/" + recent + "/job/2",
+		"index/metrics/cluster:nodes/" + old + "/job/1",
+		"index/k8s-events/" + old + "/job/1",
+		"index/cluster-data/" + old + "/job/1",
+		"index/flakes/" + old + "/job/1",
+	} {
+		client.put("bucket", name, nil, nil)
+	}
+	indexer := newTestBucketIndexer(t, client)
+	if err := indexer.PruneOlderThan(context.TODO(), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	objects, _ := client.List(context.TODO(), "bucket", nil)
+	var names []string
+	for _, attrs := range objects {
+		names = append(names, attrs.Name)
+	}
+	expect := []string{
+		"index/flakes/" + old + "/job/1",
+		"index/job-metrics/" + recent + "/job/2",
+		"index/job-state/" + recent + "/job/2",
+		"index/variant/aws/" + recent + "/job/2",
+	}
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("unexpected remaining objects %v", names)
+	}
+}
+
+func TestGCSBucketIndexer_DailySummary(t *testing.T) {
+	client := NewFakeGCSClient()
+	for i, state := range []string{"success", "failed", "success", "error"} {
+		client.put("bucket", fmt.Sprintf("index/job-state/2024-01-15T12:00:0%dZ/job/1", i), nil, map[string]string{"state": state})
+	}
+	client.put("bucket", "index/job-state/2024-01-16T12:00:00Z/job/2", nil, map[string]string{"state": "failed"})
+	indexer := newTestBucketIndexer(t, client)
+	if err := indexer.DailySummary(context.TODO(), "2024-01-15"); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", "index/daily-summary/2024-01-15")
+	if obj == nil {
+		t.Fatal("expected daily summary")
+	}
+	var summary JobStateSummary
+	if err := json.Unmarshal(obj.data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if version := obj.attrs.Metadata["indexer-version"]; version != IndexerVersion {
+		t.Errorf("unexpected indexer-version %q", version)
+	}
+	expect := JobStateSummary{Date: "2024-01-15", Total: 4, States: map[string]int{"success": 2, "failed": 1, "error": 1}}
+	if !reflect.DeepEqual(summary, expect) {
+		t.Errorf("unexpected summary %#v", summary)
+	}
+	if err := indexer.DailySummary(context.TODO(), "yesterday"); err == nil {
+		t.Error("expected an invalid date to be rejected")
+	}
+}
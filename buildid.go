@@ -0,0 +1,88 @@
+package cisearch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildID identifies a single build of a Prow job. Prow generates Snowflake
+// IDs, which embed the time the build was created.
+type BuildID string
+
+// snowflakeEpochMillis is the epoch of the Snowflake IDs generated by Prow
+// (the original Twitter epoch) in milliseconds since the Unix epoch.
+const snowflakeEpochMillis = 1288834974657
+
+// ParseBuildID validates that s is a build ID of 1 to 20 digits.
+func ParseBuildID(s string) (BuildID, error) {
+	if len(s) == 0 || len(s) > 20 {
+		return "", fmt.Errorf("build ID %q must be between 1 and 20 digits", s)
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("build ID %q must only contain digits", s)
+		}
+	}
+	return BuildID(s), nil
+}
+
+// Timestamp returns the creation time embedded in a Snowflake build ID, or
+// the zero time if the ID is not a valid 64-bit integer. The result is not
+// meaningful for the small sequential IDs of builds that predate Snowflake.
+func (b BuildID) Timestamp() time.Time {
+	id, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	millis := int64(id>>22) + snowflakeEpochMillis
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
+
+// After returns true if b was created after other. Build IDs increase over
+// time, so they are compared numerically.
+func (b BuildID) After(other BuildID) bool {
+	x, y := strings.TrimLeft(string(b), "0"), strings.TrimLeft(string(other), "0")
+	if len(x) != len(y) {
+		return len(x) > len(y)
+	}
+	return x > y
+}
+
+// snowflakeTimestampShift is the number of low bits of a Snowflake ID that
+// hold the worker and sequence numbers rather than the timestamp.
+const snowflakeTimestampShift = 22
+
+// BuildNumberToSnowflakeTime returns the creation time embedded in the
+// Snowflake build number s. Unlike Timestamp, it returns an error for
+// build numbers that are not Snowflake IDs.
+func BuildNumberToSnowflakeTime(s string) (time.Time, error) {
+	if _, err := ParseBuildID(s); err != nil {
+		return time.Time{}, err
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("build ID %q is not a 64-bit integer", s)
+	}
+	if id>>snowflakeTimestampShift == 0 {
+		return time.Time{}, fmt.Errorf("build ID %q is not a Snowflake ID", s)
+	}
+	return BuildID(s).Timestamp(), nil
+}
+
+// SnowflakeTimeToApproxBuildRange returns the smallest and largest build
+// numbers that could have been created within tolerance of t. Times before
+// the Snowflake epoch are clamped to it.
+func SnowflakeTimeToApproxBuildRange(t time.Time, tolerance time.Duration) (minBuild, maxBuild string) {
+	toMillis := func(t time.Time) uint64 {
+		millis := t.UnixMilli() - snowflakeEpochMillis
+		if millis < 0 {
+			return 0
+		}
+		return uint64(millis)
+	}
+	low := toMillis(t.Add(-tolerance)) << snowflakeTimestampShift
+	high := toMillis(t.Add(tolerance))<<snowflakeTimestampShift | (1<<snowflakeTimestampShift - 1)
+	return strconv.FormatUint(low, 10), strconv.FormatUint(high, 10)
+}
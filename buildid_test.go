@@ -0,0 +1,119 @@
+package cisearch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBuildID(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{id: "1366716541889941504"},
+		{id: "1"},
+		{id: "12345678901234567890"},
+		{id: "", wantErr: true},
+		{id: "123456789012345678901", wantErr: true},
+		{id: "1366716541889941504a", wantErr: true},
+		{id: "latest", wantErr: true},
+		{id: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			id, err := ParseBuildID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBuildID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(id) != tt.id {
+				t.Errorf("ParseBuildID() = %q", id)
+			}
+		})
+	}
+}
+
+func TestBuildID_Timestamp(t *testing.T) {
+	tests := []struct {
+		id     BuildID
+		expect time.Time
+	}{
+		{id: "1366716541889941504", expect: time.Date(2021, 3, 2, 11, 46, 30, 609000000, time.UTC)},
+		{id: "0", expect: time.Unix(0, snowflakeEpochMillis*int64(time.Millisecond)).UTC()},
+		{id: "99999999999999999999"},
+		{id: "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.id), func(t *testing.T) {
+			if got := tt.id.Timestamp(); !got.Equal(tt.expect) {
+				t.Errorf("Timestamp() = %v, want %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestBuildID_After(t *testing.T) {
+	tests := []struct {
+		a, b   BuildID
+		expect bool
+	}{
+		{a: "1366716541889941505", b: "1366716541889941504", expect: true},
+		{a: "1366716541889941504", b: "1366716541889941505"},
+		{a: "1366716541889941504", b: "1366716541889941504"},
+		{a: "1000", b: "999", expect: true},
+		{a: "999", b: "1000"},
+		{a: "0100", b: "99", expect: true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.After(tt.b); got != tt.expect {
+			t.Errorf("%s.After(%s) = %t, want %t", tt.a, tt.b, got, tt.expect)
+		}
+	}
+}
+
+func TestBuildNumberToSnowflakeTime(t *testing.T) {
+	tests := []struct {
+		build   string
+		expect  time.Time
+		wantErr bool
+	}{
+		{build: "1366716541889941504", expect: time.Date(2021, 3, 2, 11, 46, 30, 609000000, time.UTC)},
+		{build: "4194304", expect: time.UnixMilli(snowflakeEpochMillis + 1).UTC()},
+		{build: "1234", wantErr: true},
+		{build: "99999999999999999999", wantErr: true},
+		{build: "latest", wantErr: true},
+		{build: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.build, func(t *testing.T) {
+			got, err := BuildNumberToSnowflakeTime(tt.build)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildNumberToSnowflakeTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.expect) {
+				t.Errorf("BuildNumberToSnowflakeTime() = %v, want %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestSnowflakeTimeToApproxBuildRange(t *testing.T) {
+	created := time.Date(2021, 3, 2, 11, 46, 30, 609000000, time.UTC)
+	minBuild, maxBuild := SnowflakeTimeToApproxBuildRange(created, time.Minute)
+	for _, build := range []string{minBuild, maxBuild} {
+		got, err := BuildNumberToSnowflakeTime(build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := got.Sub(created); d < -time.Minute || d > time.Minute {
+			t.Errorf("build %s created at %s is outside the tolerance", build, got)
+		}
+	}
+	if !BuildID("1366716541889941504").After(BuildID(minBuild)) || !BuildID(maxBuild).After("1366716541889941504") {
+		t.Errorf("range %s-%s does not contain the build", minBuild, maxBuild)
+	}
+
+	minBuild, maxBuild = SnowflakeTimeToApproxBuildRange(time.Unix(0, 0), time.Hour)
+	if minBuild != "0" || maxBuild != "4194303" {
+		t.Errorf("unexpected range before the epoch: %s-%s", minBuild, maxBuild)
+	}
+}
@@ -0,0 +1,131 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ClusterData describes the cluster a build ran against, as recorded in the
+// cluster-data.json artifact.
+type ClusterData struct {
+	NodeCount        int    `json:"nodeCount"`
+	PlatformType     string `json:"platformType"`
+	NetworkType      string `json:"networkType"`
+	InfrastructureID string `json:"infrastructureID"`
+	ClusterVersion   string `json:"clusterVersion"`
+}
+
+// metadata returns the attributes that record d on an index entry.
+func (d ClusterData) metadata() map[string]string {
+	return map[string]string{
+		"node-count":        strconv.Itoa(d.NodeCount),
+		"platform-type":     d.PlatformType,
+		"network-type":      d.NetworkType,
+		"infrastructure-id": d.InfrastructureID,
+		"cluster-version":   d.ClusterVersion,
+	}
+}
+
+// clusterDataFromAttrs recovers ClusterData from the metadata of a
+// cluster-data index entry.
+func clusterDataFromAttrs(attrs *storage.ObjectAttrs) ClusterData {
+	nodeCount, _ := strconv.Atoi(attrs.Metadata["node-count"])
+	return ClusterData{
+		NodeCount:        nodeCount,
+		PlatformType:     attrs.Metadata["platform-type"],
+		NetworkType:      attrs.Metadata["network-type"],
+		InfrastructureID: attrs.Metadata["infrastructure-id"],
+		ClusterVersion:   attrs.Metadata["cluster-version"],
+	}
+}
+
+// ClusterDataHandler indexes the cluster-data.json collected by a build into
+// index/cluster-data/RFC3339_DATE_OF_UPLOAD/JOB_NAME/BUILD_NUMBER. Every
+// field of the ClusterData is also recorded in the metadata of the entry.
+// Events without an upload time are skipped.
+func ClusterDataHandler(opts Options) HandlerFunc {
+	return func(ctx context.Context, e GCSEvent) error {
+		parts := strings.Split(e.Name, "/")
+		if len(parts) < 4 || parts[0] != "logs" {
+			skipEvent(ctx, "not a build artifact")
+			return nil
+		}
+		job := parts[1]
+		if !opts.includesJob(job) {
+			skipEvent(ctx, "job excluded by the job kind filter")
+			return nil
+		}
+		build, err := ParseBuildID(parts[2])
+		if err != nil {
+			log.Printf("warn: Skipped %s: %v", e.Name, err)
+			skipEvent(ctx, err.Error())
+			return nil
+		}
+
+		// the key must be the same when the event is redelivered
+		if e.TimeCreated.IsZero() {
+			skipEvent(ctx, "no upload time")
+			return nil
+		}
+
+		client, err := opts.storageClient(ctx)
+		if err != nil {
+			return err
+		}
+		data, err := readObject(ctx, client, e.Bucket, e.Name)
+		if err == nil {
+			data, err = decodeContent(data)
+		}
+		if err != nil {
+			return err
+		}
+		var clusterData ClusterData
+		if err := json.Unmarshal(data, &clusterData); err != nil {
+			log.Printf("warn: Skipped invalid cluster data %s: %v", e.Name, err)
+			skipEvent(ctx, "invalid cluster data: "+err.Error())
+			return nil
+		}
+		if data, err = json.Marshal(clusterData); err != nil {
+			return fmt.Errorf("could not serialize cluster data: %v", err)
+		}
+
+		key := e.TimeCreated.UTC().Format(time.RFC3339)
+		u := fmt.Sprintf("gs://%s/%s", e.Bucket, path.Join(parts[:3]...))
+		indexPath := path.Join(indexPrefix(opts.IndexVersion, "cluster-data"), key, job, string(build))
+		metadata := clusterData.metadata()
+		metadata["link"] = u
+		writer := opts.indexWriter(client, e.Bucket)
+		err = retryGCS(ctx, gcsRetryAttempts, func() error {
+			return writer.Write(ctx, indexPath, data, metadata, opts.writeConditions())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write cluster data %s to %s: %w", indexPath, u, wrapPreconditionFailure(err))
+		}
+		log.Printf("Indexed cluster data %s to gs://%s/%s", e.Name, e.Bucket, indexPath)
+		return nil
+	}
+}
+
+// ListClusterDataByPlatform returns the cluster data of every build in the
+// cluster-data index under the date prefix that ran on platform.
+func ListClusterDataByPlatform(ctx context.Context, client StorageClient, bucket, date, platform string) ([]ClusterData, error) {
+	entries, err := listIndexEntries(ctx, client, bucket, defaultLayout, "cluster-data", date)
+	if err != nil {
+		return nil, err
+	}
+	var results []ClusterData
+	for _, entry := range entries {
+		if entry.Attrs.Metadata["platform-type"] == platform {
+			results = append(results, clusterDataFromAttrs(entry.Attrs))
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndexJobsWithOptions_ClusterData(t *testing.T) {
+	const job = "periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn"
+	data, err := ioutil.ReadFile("testdata/cluster-data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewFakeGCSClient()
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.KMSKeyName = "projects/p/locations/global/keyRings/ci/cryptoKeys/index"
+	expect := ClusterData{
+		NodeCount:        6,
+		PlatformType:     "AWS",
+		NetworkType:      "OVNKubernetes",
+		InfrastructureID: "ci-op-abc123-xyz45",
+		ClusterVersion:   "4.15.0-0.nightly-2024-01-14-100410",
+	}
+
+	name := "logs/" + job + "/1000/artifacts/e2e-aws-ovn/gather-extra/artifacts/cluster-data.json"
+	client.put("bucket", name, data, nil)
+	e := GCSEvent{Bucket: "bucket", Name: name, TimeCreated: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)}
+	if err := IndexJobsWithOptions(context.TODO(), e, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", "index/cluster-data/2024-01-15T12:00:00Z/"+job+"/1000")
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	var indexed ClusterData
+	if err := json.Unmarshal(obj.data, &indexed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexed, expect) {
+		t.Errorf("unexpected cluster data %#v", indexed)
+	}
+	if obj.attrs.Metadata["link"] != "gs://bucket/logs/"+job+"/1000" || obj.attrs.Metadata["node-count"] != "6" || obj.attrs.Metadata["network-type"] != "OVNKubernetes" || obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+		t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+	}
+	if obj.attrs.KMSKeyName != opts.KMSKeyName {
+		t.Errorf("unexpected KMS key %q", obj.attrs.KMSKeyName)
+	}
+	if err := IndexJobsWithOptions(context.TODO(), e, opts); !IsPreconditionFailure(err) {
+		t.Errorf("expected reindexing to fail the precondition, got %v", err)
+	}
+
+	// a build on another platform and an invalid file
+	client.put("bucket", "logs/"+job+"/1001/artifacts/cluster-data.json", []byte(`{"nodeCount":3,"platformType":"GCP"}`), nil)
+	client.put("bucket", "logs/"+job+"/1002/artifacts/cluster-data.json", []byte(`not json`), nil)
+	for _, build := range []string{"1001", "1002"} {
+		e := GCSEvent{Bucket: "bucket", Name: "logs/" + job + "/" + build + "/artifacts/cluster-data.json", TimeCreated: time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC)}
+		if err := IndexJobsWithOptions(context.TODO(), e, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.get("bucket", "index/cluster-data/2024-01-15T13:00:00Z/"+job+"/1002") != nil {
+		t.Error("expected invalid cluster data to be skipped")
+	}
+	// the index key would change on redelivery
+	result := IndexJobsWithResult(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/" + job + "/1001/artifacts/cluster-data.json"}, opts)
+	if result.Err != nil || !result.IsSkipped {
+		t.Errorf("expected an event without an upload time to be skipped, got %#v", result)
+	}
+
+	results, err := ListClusterDataByPlatform(context.TODO(), client, "bucket", "2024-01-15", "AWS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, []ClusterData{expect}) {
+		t.Errorf("unexpected results %#v", results)
+	}
+	if results, err := ListClusterDataByPlatform(context.TODO(), client, "bucket", "2024-01-15", "GCP"); err != nil || len(results) != 1 || results[0].NodeCount != 3 {
+		t.Errorf("unexpected results %#v %v", results, err)
+	}
+}
@@ -0,0 +1,10 @@
+// Command indexer runs the job indexer as a Cloud Run service.
+package main
+
+import (
+	cisearch "github.com/openshift/ci-search-functions"
+)
+
+func main() {
+	cisearch.CloudRunMain(cisearch.DefaultOptions())
+}
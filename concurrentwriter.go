@@ -0,0 +1,111 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// DefaultMaxConcurrentWrites is the default number of writes a
+// ConcurrentWriter issues at once.
+const DefaultMaxConcurrentWrites = 8
+
+// ConcurrentWriter collects index writes and issues them in parallel, so the
+// entries written for a single build do not wait on each other.
+type ConcurrentWriter struct {
+	Writer IndexWriter
+	// Conditions, if set, apply to every write.
+	Conditions *storage.Conditions
+	// MaxConcurrency bounds the number of writes in progress. Defaults to
+	// DefaultMaxConcurrentWrites.
+	MaxConcurrency int
+
+	pending []pendingWrite
+}
+
+type pendingWrite struct {
+	path     string
+	data     []byte
+	metadata map[string]string
+}
+
+// Add queues data to be written to path with the given metadata by the next
+// call to Flush.
+func (w *ConcurrentWriter) Add(path string, data []byte, metadata map[string]string) {
+	w.pending = append(w.pending, pendingWrite{path: path, data: data, metadata: metadata})
+}
+
+// Flush issues all queued writes and waits for them to complete. Transient
+// failures are retried as by retryGCS. If any write fails, an
+// *AggregateError recording the failure of each path is returned.
+// The queue is empty afterwards whether or not the writes succeeded.
+//
+// Unlike an errgroup.Group, a failed write does not cancel the others and
+// every failure is kept, since callers ignore the precondition failures of
+// entries that already exist and only fail on the rest.
+func (w *ConcurrentWriter) Flush(ctx context.Context) error {
+	pending := w.pending
+	w.pending = nil
+	limit := w.MaxConcurrency
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentWrites
+	}
+	sem := make(chan struct{}, limit)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	for _, write := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(write pendingWrite) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := retryGCS(ctx, gcsRetryAttempts, func() error {
+				return w.Writer.Write(ctx, write.path, write.data, write.metadata, w.Conditions)
+			})
+			if err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				errs[write.path] = wrapPreconditionFailure(err)
+			}
+		}(write)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return &AggregateError{Errors: errs}
+	}
+	return nil
+}
+
+// AggregateError records the paths that could not be written by a
+// ConcurrentWriter.
+type AggregateError struct {
+	Errors map[string]error
+}
+
+func (e *AggregateError) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for path := range e.Errors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	messages := make([]string, 0, len(paths))
+	for _, path := range paths {
+		messages = append(messages, fmt.Sprintf("%s: %v", path, e.Errors[path]))
+	}
+	return fmt.Sprintf("%d writes failed: %s", len(paths), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the individual errors so they may be matched with
+// errors.Is and errors.As.
+func (e *AggregateError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
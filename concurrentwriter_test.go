@@ -0,0 +1,157 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// slowClient tracks the number of writes in progress at once.
+type slowClient struct {
+	*FakeGCSClient
+	lock     sync.Mutex
+	inflight int
+	max      int
+}
+
+type slowWriter struct {
+	io.WriteCloser
+	client *slowClient
+}
+
+func (c *slowClient) NewWriter(ctx context.Context, bucket, name string, attrs storage.ObjectAttrs, conds *storage.Conditions) io.WriteCloser {
+	return &slowWriter{WriteCloser: c.FakeGCSClient.NewWriter(ctx, bucket, name, attrs, conds), client: c}
+}
+
+func (w *slowWriter) Close() error {
+	c := w.client
+	c.lock.Lock()
+	c.inflight++
+	if c.inflight > c.max {
+		c.max = c.inflight
+	}
+	c.lock.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	c.lock.Lock()
+	c.inflight--
+	c.lock.Unlock()
+	return w.WriteCloser.Close()
+}
+
+func TestConcurrentWriter(t *testing.T) {
+	client := &slowClient{FakeGCSClient: NewFakeGCSClient()}
+	client.put("bucket", "index/exists", []byte(`old`), nil)
+	w := &ConcurrentWriter{
+		Writer:         IndexWriter{Client: client, Bucket: "bucket"},
+		Conditions:     &storage.Conditions{DoesNotExist: true},
+		MaxConcurrency: 3,
+	}
+	for i := 0; i < 10; i++ {
+		w.Add(fmt.Sprintf("index/%d", i), []byte(`{}`), map[string]string{"n": fmt.Sprint(i)})
+	}
+	if err := w.Flush(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		obj := client.get("bucket", fmt.Sprintf("index/%d", i))
+		if obj == nil || obj.attrs.Metadata["n"] != fmt.Sprint(i) {
+			t.Errorf("expected index/%d to be written", i)
+		}
+	}
+	if client.max > 3 || client.max < 2 {
+		t.Errorf("expected up to 3 concurrent writes, got %d", client.max)
+	}
+
+	// the queue is emptied by a flush
+	if err := w.Flush(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Add("index/exists", []byte(`new`), nil)
+	w.Add("index/new", []byte(`new`), nil)
+	err := w.Flush(context.TODO())
+	var aggErr *AggregateError
+	if !errors.As(err, &aggErr) {
+		t.Fatalf("expected an aggregate error, got %v", err)
+	}
+	if len(aggErr.Errors) != 1 || !IsPreconditionFailure(aggErr.Errors["index/exists"]) || !IsPreconditionFailure(err) {
+		t.Errorf("unexpected errors %v", aggErr.Errors)
+	}
+	if obj := client.get("bucket", "index/new"); obj == nil {
+		t.Error("expected the other write to succeed")
+	}
+	if obj := client.get("bucket", "index/exists"); string(obj.data) != "old" {
+		t.Errorf("expected existing entry to be unchanged, got %s", obj.data)
+	}
+}
+
+// flakyClient fails the first write of each object as unavailable.
+type flakyClient struct {
+	*FakeGCSClient
+	lock   sync.Mutex
+	failed map[string]bool
+}
+
+func (c *flakyClient) NewWriter(ctx context.Context, bucket, name string, attrs storage.ObjectAttrs, conds *storage.Conditions) io.WriteCloser {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.failed[name] {
+		c.failed[name] = true
+		return unavailableWriter{}
+	}
+	return c.FakeGCSClient.NewWriter(ctx, bucket, name, attrs, conds)
+}
+
+type unavailableWriter struct{}
+
+func (unavailableWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (unavailableWriter) Close() error                { return &googleapi.Error{Code: 503} }
+
+func TestConcurrentWriter_Retry(t *testing.T) {
+	shortRetryDelay(t)
+	client := &flakyClient{FakeGCSClient: NewFakeGCSClient(), failed: make(map[string]bool)}
+	w := &ConcurrentWriter{Writer: IndexWriter{Client: client, Bucket: "bucket"}}
+	for i := 0; i < 3; i++ {
+		w.Add(fmt.Sprintf("index/%d", i), []byte(`{}`), nil)
+	}
+	if err := w.Flush(context.TODO()); err != nil {
+		t.Fatalf("expected transient failures to be retried: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if client.get("bucket", fmt.Sprintf("index/%d", i)) == nil {
+			t.Errorf("expected index/%d to be written", i)
+		}
+	}
+}
+
+func TestIndexJobsWithOptions_BatchWrites(t *testing.T) {
+	const job = "pull-ci-openshift-origin-master-e2e-aws-ovn"
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/"+job+"/1000/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.BatchWrites = true
+	e := GCSEvent{Bucket: "bucket", Name: "logs/" + job + "/1000/finished.json"}
+	if err := IndexJobsWithOptions(context.TODO(), e, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"index/job-state/2024-01-15T12:00:00Z/" + job + "/1000",
+		"index/presubmit/job-state/2024-01-15T12:00:00Z/" + job + "/1000",
+		"index/variant/ovn/2024-01-15T12:00:00Z/" + job + "/1000",
+	} {
+		if client.get("bucket", name) == nil {
+			t.Errorf("expected %s to be written", name)
+		}
+	}
+	if err := IndexJobsWithOptions(context.TODO(), e, opts); !IsPreconditionFailure(err) {
+		t.Errorf("expected a precondition failure when already indexed, got %v", err)
+	}
+}
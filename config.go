@@ -0,0 +1,152 @@
+package cisearch
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IndexerConfig is the complete configuration of the indexer service: the
+// Options of the indexer and the settings read from the environment by
+// IndexerConfigFromEnv.
+type IndexerConfig struct {
+	Options
+	// Port is the port the server listens on, from $PORT.
+	Port string
+}
+
+// IndexerConfigFromEnv returns the configuration made of opts and the
+// environment of the process, with defaults for unset variables. The
+// INDEXER_* and CI_SEARCH_* variables override the corresponding Options:
+//
+//	INDEXER_STORAGE_CLASS          StorageClass
+//	INDEXER_MAX_OUTPUT_METRICS     MaxOutputMetrics
+//	INDEXER_GCS_ENDPOINT           GCSEndpoint
+//	INDEXER_KMS_KEY_NAME           KMSKeyName
+//	CI_SEARCH_REQUIRED_METRIC      RequiredMetric
+//	CI_SEARCH_FALLBACK_EVENT_TIME  FallbackToEventTime
+//	CI_SEARCH_JOB_FILTERS          MetricsJobPrefixes, comma separated
+//	CI_SEARCH_MAX_FILE_SIZE_BYTES  MaxMetricsFileSizeBytes
+//	CI_SEARCH_COMPRESS_INDEX       CompressIndex
+//	CI_SEARCH_WRITE_PER_METRIC     WritePerMetric
+//	CI_SEARCH_WRITE_WORKERS        WriteWorkers
+//	CI_SEARCH_DRY_RUN              DryRun
+//	CI_SEARCH_VERBOSE              Verbose
+func IndexerConfigFromEnv(opts Options) (IndexerConfig, error) {
+	c := IndexerConfig{
+		Options: opts,
+		Port:    os.Getenv("PORT"),
+	}
+	if c.Port == "" {
+		c.Port = "8080"
+	}
+	for name, value := range map[string]*string{"INDEXER_STORAGE_CLASS": &c.StorageClass, "INDEXER_GCS_ENDPOINT": &c.GCSEndpoint, "INDEXER_KMS_KEY_NAME": &c.KMSKeyName} {
+		if v := os.Getenv(name); v != "" {
+			*value = v
+		}
+	}
+	if v := os.Getenv("INDEXER_MAX_OUTPUT_METRICS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("INDEXER_MAX_OUTPUT_METRICS must be an integer: %v", err)
+		}
+		c.MaxOutputMetrics = n
+	}
+	if v := os.Getenv("CI_SEARCH_REQUIRED_METRIC"); v != "" {
+		c.RequiredMetric = v
+	}
+	if v := os.Getenv("CI_SEARCH_JOB_FILTERS"); v != "" {
+		c.MetricsJobPrefixes = nil
+		for _, prefix := range strings.Split(v, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				c.MetricsJobPrefixes = append(c.MetricsJobPrefixes, prefix)
+			}
+		}
+	}
+	if v := os.Getenv("CI_SEARCH_MAX_FILE_SIZE_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("CI_SEARCH_MAX_FILE_SIZE_BYTES must be an integer: %v", err)
+		}
+		c.MaxMetricsFileSizeBytes = n
+	}
+	if v := os.Getenv("CI_SEARCH_WRITE_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("CI_SEARCH_WRITE_WORKERS must be an integer: %v", err)
+		}
+		c.WriteWorkers = n
+	}
+	for name, value := range map[string]*bool{"CI_SEARCH_FALLBACK_EVENT_TIME": &c.FallbackToEventTime, "CI_SEARCH_COMPRESS_INDEX": &c.CompressIndex, "CI_SEARCH_WRITE_PER_METRIC": &c.WritePerMetric, "CI_SEARCH_DRY_RUN": &c.DryRun, "CI_SEARCH_VERBOSE": &c.Verbose} {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return c, fmt.Errorf("%s must be a boolean: %v", name, err)
+			}
+			*value = b
+		}
+	}
+	return c, nil
+}
+
+// ConfigError lists the problems found by IndexerConfig.Validate.
+type ConfigError struct {
+	Violations []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Violations, "; "))
+}
+
+// storageClasses are the GCS storage classes that may be configured.
+var storageClasses = map[string]bool{
+	"STANDARD": true, "NEARLINE": true, "COLDLINE": true, "ARCHIVE": true,
+}
+
+var (
+	indexVersionPattern = regexp.MustCompile(`^v[1-9][0-9]*$`)
+	kmsKeyNamePattern   = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+)
+
+// Validate checks the configuration before any event is handled. All
+// violations are reported in a *ConfigError.
+func (c IndexerConfig) Validate() error {
+	var violations []string
+	if c.Port == "" {
+		violations = append(violations, "port is required")
+	} else if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		violations = append(violations, fmt.Sprintf("port %q is not a valid port number", c.Port))
+	}
+	if c.IndexVersion == "" {
+		violations = append(violations, "index version is required")
+	} else if !indexVersionPattern.MatchString(c.IndexVersion) {
+		violations = append(violations, fmt.Sprintf("index version %q must be of the form v1, v2, ...", c.IndexVersion))
+	}
+	if c.StorageClass != "" && !storageClasses[c.StorageClass] {
+		violations = append(violations, fmt.Sprintf("storage class %q is not one of STANDARD, NEARLINE, COLDLINE or ARCHIVE", c.StorageClass))
+	}
+	if c.MaxOutputMetrics <= 0 {
+		violations = append(violations, "max output metrics must be positive")
+	}
+	if c.MaxMetricsFileSizeBytes < 0 {
+		violations = append(violations, "max metrics file size must not be negative")
+	}
+	if c.WriteWorkers < 0 {
+		violations = append(violations, "write workers must not be negative")
+	}
+	if c.GCSEndpoint != "" {
+		if u, err := url.Parse(c.GCSEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			violations = append(violations, fmt.Sprintf("GCS endpoint %q is not a valid http(s) URL", c.GCSEndpoint))
+		}
+	}
+	if c.KMSKeyName != "" && !kmsKeyNamePattern.MatchString(c.KMSKeyName) {
+		violations = append(violations, fmt.Sprintf("KMS key name %q must be of the form projects/*/locations/*/keyRings/*/cryptoKeys/*", c.KMSKeyName))
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ConfigError{Violations: violations}
+}
@@ -0,0 +1,126 @@
+package cisearch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIndexerConfigFromEnv(t *testing.T) {
+	t.Setenv("PORT", "")
+	t.Setenv("INDEXER_STORAGE_CLASS", "NEARLINE")
+	t.Setenv("INDEXER_MAX_OUTPUT_METRICS", "")
+	t.Setenv("INDEXER_GCS_ENDPOINT", "")
+	t.Setenv("INDEXER_KMS_KEY_NAME", "")
+	c, err := IndexerConfigFromEnv(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "8080" || c.StorageClass != "NEARLINE" || c.MaxOutputMetrics != DefaultMaxOutputMetrics || c.IndexVersion != "v1" {
+		t.Errorf("unexpected config %#v", c)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid: %v", err)
+	}
+
+	t.Setenv("INDEXER_MAX_OUTPUT_METRICS", "many")
+	if _, err := IndexerConfigFromEnv(DefaultOptions()); err == nil {
+		t.Error("expected an error for an invalid integer")
+	}
+}
+
+func TestIndexerConfigFromEnv_Options(t *testing.T) {
+	for _, name := range []string{"PORT", "INDEXER_STORAGE_CLASS", "INDEXER_MAX_OUTPUT_METRICS", "INDEXER_GCS_ENDPOINT", "INDEXER_KMS_KEY_NAME"} {
+		t.Setenv(name, "")
+	}
+	tests := []struct {
+		name    string
+		value   string
+		check   func(c IndexerConfig) bool
+		wantErr bool
+	}{
+		{name: "CI_SEARCH_REQUIRED_METRIC", value: "job:duration:e2e:seconds", check: func(c IndexerConfig) bool { return c.RequiredMetric == "job:duration:e2e:seconds" }},
+		{name: "CI_SEARCH_JOB_FILTERS", value: "periodic-ci-openshift-, ,pull-ci-openshift-", check: func(c IndexerConfig) bool {
+			return reflect.DeepEqual(c.MetricsJobPrefixes, []string{"periodic-ci-openshift-", "pull-ci-openshift-"})
+		}},
+		{name: "CI_SEARCH_MAX_FILE_SIZE_BYTES", value: "1024", check: func(c IndexerConfig) bool { return c.MaxMetricsFileSizeBytes == 1024 }},
+		{name: "CI_SEARCH_MAX_FILE_SIZE_BYTES", value: "1KB", wantErr: true},
+		{name: "CI_SEARCH_FALLBACK_EVENT_TIME", value: "true", check: func(c IndexerConfig) bool { return c.FallbackToEventTime }},
+		{name: "CI_SEARCH_FALLBACK_EVENT_TIME", value: "sometimes", wantErr: true},
+		{name: "CI_SEARCH_COMPRESS_INDEX", value: "true", check: func(c IndexerConfig) bool { return c.CompressIndex }},
+		{name: "CI_SEARCH_WRITE_PER_METRIC", value: "true", check: func(c IndexerConfig) bool { return c.WritePerMetric }},
+		{name: "CI_SEARCH_WRITE_WORKERS", value: "16", check: func(c IndexerConfig) bool { return c.WriteWorkers == 16 }},
+		{name: "CI_SEARCH_WRITE_WORKERS", value: "many", wantErr: true},
+		{name: "CI_SEARCH_DRY_RUN", value: "true", check: func(c IndexerConfig) bool { return c.DryRun }},
+		{name: "CI_SEARCH_DRY_RUN", value: "maybe", wantErr: true},
+		{name: "CI_SEARCH_VERBOSE", value: "1", check: func(c IndexerConfig) bool { return c.Verbose }},
+		{name: "CI_SEARCH_VERBOSE", value: "loud", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.name, tt.value)
+			c, err := IndexerConfigFromEnv(DefaultOptions())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.check(c) {
+				t.Errorf("%s was not applied to %#v", tt.name, c.Options)
+			}
+		})
+	}
+
+	// unset variables keep the defaults
+	c, err := IndexerConfigFromEnv(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.requiredMetric() != DefaultRequiredMetric || !c.includesMetricsJob("release-openshift-origin-installer-e2e-aws") || c.includesMetricsJob("pull-ci-openshift-origin-master-e2e-aws") || c.MaxMetricsFileSizeBytes != DefaultMaxMetricsFileSizeBytes || c.DryRun || c.Verbose {
+		t.Errorf("unexpected defaults %#v", c.Options)
+	}
+}
+
+func TestIndexerConfig_Validate(t *testing.T) {
+	valid := IndexerConfig{Options: DefaultOptions(), Port: "8080"}
+	valid.StorageClass = "STANDARD"
+	valid.MaxOutputMetrics = 100
+	valid.GCSEndpoint = "http://localhost:4443/storage/v1/"
+	valid.KMSKeyName = "projects/p/locations/global/keyRings/ci/cryptoKeys/index"
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected a valid config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *IndexerConfig)
+		expect []string
+	}{
+		{name: "missing port", modify: func(c *IndexerConfig) { c.Port = "" }, expect: []string{"port is required"}},
+		{name: "invalid port", modify: func(c *IndexerConfig) { c.Port = "http" }, expect: []string{`port "http" is not a valid port number`}},
+		{name: "missing index version", modify: func(c *IndexerConfig) { c.IndexVersion = "" }, expect: []string{"index version is required"}},
+		{name: "unknown index version", modify: func(c *IndexerConfig) { c.IndexVersion = "latest" }, expect: []string{`index version "latest" must be of the form v1, v2, ...`}},
+		{name: "unknown storage class", modify: func(c *IndexerConfig) { c.StorageClass = "REGIONAL" }, expect: []string{`storage class "REGIONAL" is not one of STANDARD, NEARLINE, COLDLINE or ARCHIVE`}},
+		{name: "no output metrics", modify: func(c *IndexerConfig) { c.MaxOutputMetrics = 0 }, expect: []string{"max output metrics must be positive"}},
+		{name: "invalid endpoint", modify: func(c *IndexerConfig) { c.GCSEndpoint = "localhost:4443" }, expect: []string{`GCS endpoint "localhost:4443" is not a valid http(s) URL`}},
+		{name: "invalid KMS key", modify: func(c *IndexerConfig) { c.KMSKeyName = "keyRings/ci/cryptoKeys/index" }, expect: []string{`KMS key name "keyRings/ci/cryptoKeys/index" must be of the form projects/*/locations/*/keyRings/*/cryptoKeys/*`}},
+		{
+			name: "all violations are reported",
+			modify: func(c *IndexerConfig) {
+				c.Port, c.MaxOutputMetrics, c.MaxMetricsFileSizeBytes, c.WriteWorkers = "", -1, -1, -1
+			},
+			expect: []string{"port is required", "max output metrics must be positive", "max metrics file size must not be negative", "write workers must not be negative"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			var configErr *ConfigError
+			if err := c.Validate(); !errors.As(err, &configErr) {
+				t.Fatalf("expected a ConfigError, got %v", err)
+			}
+			if !reflect.DeepEqual(configErr.Violations, tt.expect) {
+				t.Errorf("unexpected violations %q", configErr.Violations)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package cisearch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// decompressReader returns a reader of the content of r, decompressing it if
+// it starts with the gzip header. Objects are not always uploaded with the
+// Content-Encoding that describes them, so the content is checked instead.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return ioutil.NopCloser(br), nil
+	}
+	return gzip.NewReader(br)
+}
+
+// compressJSON returns data gzip compressed, for index entries written with
+// Options.CompressIndex.
+func compressJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// errContentTooLarge is returned by a limitedReader that is read past its
+// limit.
+var errContentTooLarge = errors.New("content exceeds the size limit")
+
+// limitedReader reads at most n bytes of r and then fails with
+// errContentTooLarge, unlike io.LimitReader which reports EOF. It bounds
+// content whose size is not known in advance, such as decompressed objects.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		l.exceeded = true
+		return n, errContentTooLarge
+	}
+	return n, err
+}
+
+// decodeContent returns data, decompressed if it is gzip compressed.
+func decodeContent(data []byte) ([]byte, error) {
+	r, err := decompressReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,112 @@
+package cisearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func gzipData(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressReader(t *testing.T) {
+	const content = `{"timestamp":1705320000,"passed":true}`
+	for name, data := range map[string][]byte{"plain": []byte(content), "gzip": gzipData(t, content)} {
+		r, err := decompressReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(out) != content {
+			t.Errorf("%s: unexpected content %q", name, out)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := decompressReader(bytes.NewReader([]byte{0x1f, 0x8b, 0})); err == nil {
+		t.Error("expected an error for a truncated gzip header")
+	}
+	if data, err := decodeContent(nil); err != nil || len(data) != 0 {
+		t.Errorf("unexpected content %q of empty data: %v", data, err)
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	for _, tt := range []struct {
+		content  string
+		limit    int64
+		exceeded bool
+	}{
+		{content: "", limit: 0},
+		{content: "abcd", limit: 4},
+		{content: "abcd", limit: 10},
+		{content: "abcde", limit: 4, exceeded: true},
+		{content: "a", limit: 0, exceeded: true},
+	} {
+		r := &limitedReader{r: strings.NewReader(tt.content), n: tt.limit}
+		out, err := ioutil.ReadAll(r)
+		if tt.exceeded {
+			if !errors.Is(err, errContentTooLarge) || !r.exceeded {
+				t.Errorf("%q/%d: expected the limit to be exceeded, got %v", tt.content, tt.limit, err)
+			}
+			if int64(len(out)) != tt.limit+1 {
+				t.Errorf("%q/%d: read %d bytes past the limit", tt.content, tt.limit, int64(len(out))-tt.limit)
+			}
+			continue
+		}
+		if err != nil || r.exceeded || string(out) != tt.content {
+			t.Errorf("%q/%d: unexpected content %q: %v", tt.content, tt.limit, out, err)
+		}
+	}
+}
+
+func TestIndexJobsWithOptions_GzipEncoded(t *testing.T) {
+	const metrics = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	// objects are not always uploaded with a Content-Encoding
+	for _, encoding := range []string{"gzip", ""} {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, gzipData(t, `{"timestamp":1705320000,"passed":true}`), nil)
+			client.put("bucket", metrics, gzipData(t, testJobMetrics), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			for _, name := range []string{testFinishedName, metrics} {
+				if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, ContentEncoding: encoding}, opts); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+			}
+			if obj := client.get("bucket", testStatePath); obj == nil || obj.attrs.Metadata["state"] != "success" {
+				t.Errorf("expected job-state entry, got %#v", obj)
+			}
+			obj := client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000")
+			if obj == nil {
+				t.Fatal("expected job-metrics entry")
+			}
+			var index JobMetricsIndex
+			if err := json.Unmarshal(obj.data, &index); err != nil {
+				t.Fatal(err)
+			}
+			if m, ok := index.Get("job:duration:total:seconds"); !ok || m.Value != "3600" {
+				t.Errorf("unexpected job-metrics entry %s", obj.data)
+			}
+		})
+	}
+}
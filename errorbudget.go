@@ -0,0 +1,94 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+)
+
+// ErrorBudget compares the success rate of a job over a window to its
+// target. BudgetRemaining is the fraction of the failures allowed by the
+// target that have not been used, and is negative once the budget is
+// overspent.
+type ErrorBudget struct {
+	Job             string  `json:"job"`
+	TotalRuns       int     `json:"total_runs"`
+	SuccessRuns     int     `json:"success_runs"`
+	FailureRuns     int     `json:"failure_runs"`
+	ActualRate      float64 `json:"actual_rate"`
+	TargetRate      float64 `json:"target_rate"`
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// BudgetStatus is ok, at-risk when no more than 10% of the budget
+	// remains, or exhausted.
+	BudgetStatus string `json:"budget_status"`
+}
+
+// errorBudgetAtRisk is the fraction of the budget below which a job is at
+// risk of exhausting it.
+const errorBudgetAtRisk = 0.1
+
+// ComputeErrorBudget computes the error budget of job from its job-state
+// index entries over the window ending now and writes it to
+// index/error-budget/<job>, replacing the previous result. Both indices are
+// in the layout of Options.IndexVersion.
+func ComputeErrorBudget(ctx context.Context, client StorageClient, bucket, job string, window time.Duration, targetSuccessRate float64, opts ...func(*Options)) (*ErrorBudget, error) {
+	if targetSuccessRate <= 0 || targetSuccessRate > 1 {
+		return nil, fmt.Errorf("target success rate must be greater than 0 and at most 1, not %v", targetSuccessRate)
+	}
+	o := DefaultOptions()
+	for _, fn := range opts {
+		fn(&o)
+	}
+	now := time.Now()
+	entries, err := listJobStatesBetween(ctx, client, bucket, o.layout(), now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+	budget := &ErrorBudget{Job: job, TargetRate: targetSuccessRate}
+	for _, entry := range entries {
+		if entry.Job != job {
+			continue
+		}
+		budget.TotalRuns++
+		if entry.Attrs.Metadata["state"] == "success" {
+			budget.SuccessRuns++
+		} else {
+			budget.FailureRuns++
+		}
+	}
+	budget.BudgetRemaining = 1
+	if budget.TotalRuns > 0 {
+		budget.ActualRate = float64(budget.SuccessRuns) / float64(budget.TotalRuns)
+		allowed := (1 - targetSuccessRate) * float64(budget.TotalRuns)
+		switch {
+		case allowed > 0:
+			budget.BudgetRemaining = 1 - float64(budget.FailureRuns)/allowed
+		case budget.FailureRuns > 0:
+			budget.BudgetRemaining = -1
+		}
+	}
+	switch {
+	case budget.BudgetRemaining <= 0:
+		budget.BudgetStatus = "exhausted"
+	case budget.BudgetRemaining <= errorBudgetAtRisk:
+		budget.BudgetStatus = "at-risk"
+	default:
+		budget.BudgetStatus = "ok"
+	}
+
+	data, err := json.Marshal(budget)
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize error budget: %v", err)
+	}
+	indexPath := path.Join(indexPrefix(o.IndexVersion, "error-budget"), job)
+	if err := o.indexWriter(client, bucket).Write(ctx, indexPath, data, map[string]string{
+		"status":      budget.BudgetStatus,
+		"actual-rate": strconv.FormatFloat(budget.ActualRate, 'f', -1, 64),
+	}, nil); err != nil {
+		return nil, fmt.Errorf("failed to write error budget %s: %v", indexPath, err)
+	}
+	return budget, nil
+}
@@ -0,0 +1,109 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestComputeErrorBudget(t *testing.T) {
+	now := time.Now().UTC()
+	put := func(client *FakeGCSClient, job string, age time.Duration, build int, state string) {
+		key := now.Add(-age).Format(time.RFC3339)
+		client.put("bucket", path.Join("index/job-state", key, job, fmt.Sprint(build)), nil, map[string]string{"state": state})
+	}
+	tests := []struct {
+		name      string
+		successes int
+		failures  int
+		target    float64
+		remaining float64
+		status    string
+	}{
+		{name: "no runs", target: 0.9, remaining: 1, status: "ok"},
+		{name: "healthy", successes: 98, failures: 2, target: 0.9, remaining: 0.8, status: "ok"},
+		{name: "at risk", successes: 91, failures: 9, target: 0.9, remaining: 0.1, status: "at-risk"},
+		{name: "exhausted", successes: 80, failures: 20, target: 0.9, remaining: -1, status: "exhausted"},
+		{name: "perfect target met", successes: 10, target: 1, remaining: 1, status: "ok"},
+		{name: "perfect target missed", successes: 9, failures: 1, target: 1, remaining: -1, status: "exhausted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			build := 0
+			for i := 0; i < tt.successes; i++ {
+				build++
+				put(client, "job", time.Duration(build)*time.Minute, build, "success")
+			}
+			for i := 0; i < tt.failures; i++ {
+				build++
+				put(client, "job", time.Duration(build)*time.Minute, build, "failed")
+			}
+			// outside the window or for other jobs
+			put(client, "job", 8*24*time.Hour, 0, "failed")
+			put(client, "other", time.Minute, 0, "failed")
+
+			budget, err := ComputeErrorBudget(context.TODO(), client, "bucket", "job", 7*24*time.Hour, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total := tt.successes + tt.failures
+			if budget.TotalRuns != total || budget.SuccessRuns != tt.successes || budget.FailureRuns != tt.failures {
+				t.Errorf("unexpected counts %#v", budget)
+			}
+			if total > 0 && budget.ActualRate != float64(tt.successes)/float64(total) {
+				t.Errorf("unexpected rate %v", budget.ActualRate)
+			}
+			if math.Abs(budget.BudgetRemaining-tt.remaining) > 1e-9 || budget.BudgetStatus != tt.status {
+				t.Errorf("unexpected budget %v %s", budget.BudgetRemaining, budget.BudgetStatus)
+			}
+
+			obj := client.get("bucket", "index/error-budget/job")
+			if obj == nil {
+				t.Fatal("expected the error budget to be written")
+			}
+			var written ErrorBudget
+			if err := json.Unmarshal(obj.data, &written); err != nil {
+				t.Fatal(err)
+			}
+			if written != *budget || obj.attrs.Metadata["status"] != tt.status || obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+				t.Errorf("unexpected written budget %#v %v", written, obj.attrs.Metadata)
+			}
+		})
+	}
+}
+
+func TestComputeErrorBudget_IndexVersion(t *testing.T) {
+	client := NewFakeGCSClient()
+	key := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	client.put("bucket", path.Join("index/v2/unknown/job-state", key, "job/1"), nil, map[string]string{"state": "success"})
+	client.put("bucket", path.Join("index/v2/unknown/job-state", key, "job/2"), nil, map[string]string{"state": "failed"})
+	// only in the v1 layout
+	client.put("bucket", path.Join("index/job-state", key, "job/3"), nil, map[string]string{"state": "failed"})
+
+	budget, err := ComputeErrorBudget(context.TODO(), client, "bucket", "job", 24*time.Hour, 0.5, func(o *Options) {
+		o.IndexVersion = "v2"
+		o.LegacyIndexPath = false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.TotalRuns != 2 || budget.FailureRuns != 1 {
+		t.Errorf("unexpected counts %#v", budget)
+	}
+	if client.get("bucket", "index/v2/error-budget/job") == nil || client.get("bucket", "index/error-budget/job") != nil {
+		t.Error("expected the error budget to be written in the v2 layout")
+	}
+}
+
+func TestComputeErrorBudget_InvalidTarget(t *testing.T) {
+	for _, target := range []float64{0, -0.5, 1.5} {
+		if _, err := ComputeErrorBudget(context.TODO(), NewFakeGCSClient(), "bucket", "job", time.Hour, target); err == nil {
+			t.Errorf("expected target %v to be rejected", target)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package cisearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+
+	"cloud.google.com/go/storage"
+)
+
+// jobStateRecord is the flattened form of a job-state index entry that is
+// loaded into BigQuery.
+type jobStateRecord struct {
+	Job         string `json:"job"`
+	Build       string `json:"build"`
+	State       string `json:"state"`
+	Link        string `json:"link"`
+	CompletedAt int64  `json:"completed_at"`
+	IndexedAt   int64  `json:"indexed_at,omitempty"`
+}
+
+// ExportIndexToJSONL writes every job-state index entry for date in
+// srcBucket to destBucket/destPrefix/<date>.jsonl as newline delimited JSON
+// suitable for loading into BigQuery, and returns the number of records
+// written. Each record is a flat object of strings and integer epoch seconds.
+func ExportIndexToJSONL(ctx context.Context, client StorageClient, srcBucket, destBucket, date, destPrefix string) (int, error) {
+	entries, err := listJobStates(ctx, client, srcBucket, defaultLayout, date)
+	if err != nil {
+		return 0, err
+	}
+
+	// cancelling the context of the writer before closing it aborts the
+	// upload, so a partial export is never loaded as if it were complete
+	name := path.Join(destPrefix, date+".jsonl")
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := client.NewWriter(writeCtx, destBucket, name, storage.ObjectAttrs{ContentType: "application/x-ndjson"}, nil)
+	abort := func() {
+		cancel()
+		if err := w.Close(); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("warn: Unable to abort the upload of %s: %v", name, err)
+		}
+	}
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	var count int
+	for _, entry := range entries {
+		data, err := readIndexEntry(ctx, client, srcBucket, entry.Attrs.Name)
+		if err != nil {
+			abort()
+			return count, fmt.Errorf("unable to read %s: %v", entry.Attrs.Name, err)
+		}
+		var result JobResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			abort()
+			return count, fmt.Errorf("unable to decode %s: %v", entry.Attrs.Name, err)
+		}
+		if err := enc.Encode(jobStateRecord{
+			Job:         entry.Job,
+			Build:       entry.Build,
+			State:       result.State,
+			Link:        result.Link,
+			CompletedAt: result.CompletedAt,
+			IndexedAt:   result.IndexedAt,
+		}); err != nil {
+			abort()
+			return count, fmt.Errorf("unable to write gs://%s/%s: %v", destBucket, name, err)
+		}
+		count++
+	}
+	if err := buf.Flush(); err != nil {
+		abort()
+		return count, fmt.Errorf("unable to write gs://%s/%s: %v", destBucket, name, err)
+	}
+	if err := w.Close(); err != nil {
+		return count, fmt.Errorf("unable to write gs://%s/%s: %v", destBucket, name, err)
+	}
+	return count, nil
+}
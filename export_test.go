@@ -0,0 +1,74 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestExportIndexToJSONL(t *testing.T) {
+	client := NewFakeGCSClient()
+	states := []string{"success", "failed", "error", "success", "failed"}
+	for i, state := range states {
+		job, build := fmt.Sprintf("job-%d", i), fmt.Sprintf("%d", 1000+i)
+		data, _ := json.Marshal(JobResult{
+			State:       state,
+			CompletedAt: int64(1705320000 + i),
+			Link:        "gs://src/logs/" + job + "/" + build,
+			IndexedAt:   int64(1705320060 + i),
+		})
+		client.put("src", path.Join("index/job-state", fmt.Sprintf("2024-01-15T12:00:0%dZ", i), job, build), data, nil)
+	}
+	client.put("src", "index/job-state/2024-01-16T12:00:00Z/job-0/2000", []byte(`{"state":"success"}`), nil)
+
+	count, err := ExportIndexToJSONL(context.TODO(), client, "src", "dest", "2024-01-15", "exports/job-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5 records, got %d", count)
+	}
+	obj := client.get("dest", "exports/job-state/2024-01-15.jsonl")
+	if obj == nil {
+		t.Fatal("expected export to be written")
+	}
+	lines := strings.Split(strings.TrimSuffix(string(obj.data), "\n"), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d:\n%s", len(lines), obj.data)
+	}
+	for i, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v", i, err)
+		}
+		for k, v := range record {
+			switch v.(type) {
+			case string, float64:
+			default:
+				t.Errorf("line %d has nested value for %s: %#v", i, k, v)
+			}
+		}
+		if record["job"] != fmt.Sprintf("job-%d", i) || record["state"] != states[i] || record["completed_at"] != float64(1705320000+i) {
+			t.Errorf("unexpected record %d: %s", i, line)
+		}
+	}
+}
+
+func TestExportIndexToJSONL_Abort(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("src", testStatePath, []byte(`{"state":"success"}`), nil)
+	client.put("src", "index/job-state/2024-01-15T12:00:01Z/job/1001", []byte(`not json`), nil)
+	count, err := ExportIndexToJSONL(context.TODO(), client, "src", "dest", "2024-01-15", "exports/job-state")
+	if err == nil || count != 1 {
+		t.Fatalf("expected an error after 1 record, got %d: %v", count, err)
+	}
+	if client.get("dest", "exports/job-state/2024-01-15.jsonl") != nil {
+		t.Error("expected the partial export not to be written")
+	}
+	if client.openWriters != 0 {
+		t.Errorf("expected the writer to be closed, %d are open", client.openWriters)
+	}
+}
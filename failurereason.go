@@ -0,0 +1,74 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+
+	"cloud.google.com/go/storage"
+)
+
+// FailurePattern names a regular expression that identifies a cause of
+// failure in the log of a build.
+type FailurePattern struct {
+	Name  string
+	Regex string
+}
+
+// failureLogNames are the logs searched for failure patterns, in order. Only
+// the first one that exists is read.
+var failureLogNames = []string{"build-log.txt", "e2e.log"}
+
+// maxFailureLogBytes is how much of a log is searched for failure patterns.
+const maxFailureLogBytes = 1024 * 1024
+
+// ExtractFailureReason returns the name of the first of patterns that
+// matches the log of the build linked from r, or an empty string if none
+// match or the build has no log.
+func ExtractFailureReason(ctx context.Context, client StorageClient, r JobResult, patterns []FailurePattern) (string, error) {
+	if len(patterns) == 0 {
+		return "", nil
+	}
+	expressions := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return "", fmt.Errorf("invalid failure pattern %s: %v", pattern.Name, err)
+		}
+		expressions = append(expressions, re)
+	}
+	e, err := finishedEventFromLink(r.Link)
+	if err != nil {
+		return "", err
+	}
+	dir := path.Dir(e.Name)
+	for _, name := range failureLogNames {
+		data, err := readObjectPrefix(ctx, client, e.Bucket, path.Join(dir, name), maxFailureLogBytes)
+		if err == storage.ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s of %s: %v", name, r.Link, err)
+		}
+		for i, re := range expressions {
+			if re.Match(data) {
+				return patterns[i].Name, nil
+			}
+		}
+		return "", nil
+	}
+	return "", nil
+}
+
+// readObjectPrefix returns at most the first limit bytes of an object.
+func readObjectPrefix(ctx context.Context, client StorageClient, bucket, name string, limit int64) ([]byte, error) {
+	r, err := client.NewReader(ctx, bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(io.LimitReader(r, limit))
+}
@@ -0,0 +1,87 @@
+package cisearch
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var testFailurePatterns = []FailurePattern{
+	{Name: "install-failed", Regex: `level=error msg="?Failed to install`},
+	{Name: "quota", Regex: `(?i)quota exceeded`},
+	{Name: "test-failures", Regex: `Failing tests:`},
+}
+
+func TestExtractFailureReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		logs     map[string]string
+		patterns []FailurePattern
+		expect   string
+		wantErr  bool
+	}{
+		{
+			name:   "first matching pattern wins",
+			logs:   map[string]string{"build-log.txt": "Failing tests:\n[sig-cli] oc\nerror: QUOTA EXCEEDED for region\n"},
+			expect: "quota",
+		},
+		{
+			name:   "falls back to e2e.log",
+			logs:   map[string]string{"e2e.log": "level=error msg=\"Failed to install the cluster\"\n"},
+			expect: "install-failed",
+		},
+		{
+			name:   "build-log.txt takes precedence over e2e.log",
+			logs:   map[string]string{"build-log.txt": "nothing to see\n", "e2e.log": "Failing tests:\n"},
+			expect: "",
+		},
+		{
+			name:   "only the first megabyte is searched",
+			logs:   map[string]string{"build-log.txt": strings.Repeat("x", maxFailureLogBytes) + "Failing tests:\n"},
+			expect: "",
+		},
+		{
+			name: "no logs",
+		},
+		{
+			name:     "invalid pattern",
+			logs:     map[string]string{"build-log.txt": "Failing tests:\n"},
+			patterns: []FailurePattern{{Name: "bad", Regex: `(`}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			for name, data := range tt.logs {
+				client.put("bucket", "logs/job/1000/"+name, []byte(data), nil)
+			}
+			patterns := tt.patterns
+			if patterns == nil {
+				patterns = testFailurePatterns
+			}
+			reason, err := ExtractFailureReason(context.TODO(), client, JobResult{Link: "gs://bucket/logs/job/1000"}, patterns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractFailureReason() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reason != tt.expect {
+				t.Errorf("ExtractFailureReason() = %q, want %q", reason, tt.expect)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_FailureReason(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/build-log.txt", []byte("Failing tests:\n[sig-cli] oc\n"), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.FailurePatterns = testFailurePatterns
+	obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":false}`)
+	if result := jobResultFromAttrs(&obj.attrs); result.FailureReason != "test-failures" {
+		t.Errorf("unexpected failure reason in %v", obj.attrs.Metadata)
+	}
+	if !strings.Contains(string(obj.data), `"failure_reason":"test-failures"`) {
+		t.Errorf("unexpected index entry %s", obj.data)
+	}
+}
@@ -0,0 +1,220 @@
+package cisearch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// FakeGCSClient is an in-memory StorageClient for tests. Like GCS, it
+// enforces the DoesNotExist and GenerationMatch preconditions of writes.
+type FakeGCSClient struct {
+	lock       sync.Mutex
+	objects    map[string]map[string]*fakeObject
+	generation int64
+
+	// writeErr and closeErr are returned by all writers if set
+	writeErr error
+	closeErr error
+	// openWriters counts the writers that have not been closed
+	openWriters int
+}
+
+type fakeObject struct {
+	attrs storage.ObjectAttrs
+	data  []byte
+}
+
+var _ StorageClient = &FakeGCSClient{}
+
+// NewFakeGCSClient returns an empty FakeGCSClient.
+func NewFakeGCSClient() *FakeGCSClient {
+	return &FakeGCSClient{objects: make(map[string]map[string]*fakeObject)}
+}
+
+// put stores data at bucket/name with the provided metadata.
+func (c *FakeGCSClient) put(bucket, name string, data []byte, metadata map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.store(storage.ObjectAttrs{Bucket: bucket, Name: name, Metadata: metadata}, data)
+}
+
+// get returns the object at bucket/name or nil if it does not exist.
+func (c *FakeGCSClient) get(bucket, name string) *fakeObject {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.objects[bucket][name]
+}
+
+func (c *FakeGCSClient) store(attrs storage.ObjectAttrs, data []byte) {
+	if c.objects[attrs.Bucket] == nil {
+		c.objects[attrs.Bucket] = make(map[string]*fakeObject)
+	}
+	c.generation++
+	if attrs.Updated.IsZero() {
+		attrs.Updated = time.Now()
+	}
+	if attrs.Created.IsZero() {
+		attrs.Created = attrs.Updated
+	}
+	attrs.Size = int64(len(data))
+	attrs.Generation = c.generation
+	c.objects[attrs.Bucket][attrs.Name] = &fakeObject{attrs: attrs, data: data}
+}
+
+func (c *FakeGCSClient) NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	obj := c.get(bucket, name)
+	if obj == nil {
+		return nil, storage.ErrObjectNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (c *FakeGCSClient) NewWriter(ctx context.Context, bucket, name string, attrs storage.ObjectAttrs, conds *storage.Conditions) io.WriteCloser {
+	attrs.Bucket, attrs.Name = bucket, name
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.openWriters++
+	return &fakeWriter{ctx: ctx, client: c, attrs: attrs, conds: conds}
+}
+
+func (c *FakeGCSClient) Attrs(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error) {
+	obj := c.get(bucket, name)
+	if obj == nil {
+		return nil, storage.ErrObjectNotExist
+	}
+	attrs := obj.attrs
+	return &attrs, nil
+}
+
+func (c *FakeGCSClient) List(ctx context.Context, bucket string, q *storage.Query) ([]*storage.ObjectAttrs, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var prefix, delimiter string
+	if q != nil {
+		prefix, delimiter = q.Prefix, q.Delimiter
+	}
+	names := make([]string, 0, len(c.objects[bucket]))
+	for name := range c.objects[bucket] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objects []*storage.ObjectAttrs
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i != -1 {
+				p := name[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					objects = append(objects, &storage.ObjectAttrs{Prefix: p})
+				}
+				continue
+			}
+		}
+		attrs := c.objects[bucket][name].attrs
+		objects = append(objects, &attrs)
+	}
+	return objects, nil
+}
+
+func (c *FakeGCSClient) Compose(ctx context.Context, bucket, name string, sources []string, attrs storage.ObjectAttrs, conds *storage.Conditions) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.checkConditions(bucket, name, conds); err != nil {
+		return err
+	}
+	var data []byte
+	for _, source := range sources {
+		obj := c.objects[bucket][source]
+		if obj == nil {
+			return storage.ErrObjectNotExist
+		}
+		data = append(data, obj.data...)
+	}
+	attrs.Bucket, attrs.Name = bucket, name
+	c.store(attrs, data)
+	return nil
+}
+
+func (c *FakeGCSClient) Delete(ctx context.Context, bucket, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.objects[bucket][name] == nil {
+		return storage.ErrObjectNotExist
+	}
+	delete(c.objects[bucket], name)
+	return nil
+}
+
+type fakeWriter struct {
+	// ctx aborts the write if it is done before Close, as for a GCS writer
+	ctx    context.Context
+	client *FakeGCSClient
+	attrs  storage.ObjectAttrs
+	conds  *storage.Conditions
+	buf    bytes.Buffer
+	// err is returned by Close once a write has failed, as by a GCS writer
+	err    error
+	closed bool
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	if w.client.writeErr != nil {
+		w.err = w.client.writeErr
+		return 0, w.err
+	}
+	return w.buf.Write(p)
+}
+
+func (w *fakeWriter) Close() error {
+	c := w.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !w.closed {
+		w.closed = true
+		c.openWriters--
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if c.closeErr != nil {
+		return c.closeErr
+	}
+	if err := c.checkConditions(w.attrs.Bucket, w.attrs.Name, w.conds); err != nil {
+		return err
+	}
+	c.store(w.attrs, w.buf.Bytes())
+	return nil
+}
+
+// checkConditions returns a precondition failure if the named object does
+// not meet conds. The caller must hold the lock.
+func (c *FakeGCSClient) checkConditions(bucket, name string, conds *storage.Conditions) error {
+	if conds == nil {
+		return nil
+	}
+	existing := c.objects[bucket][name]
+	switch {
+	case conds.DoesNotExist && existing != nil,
+		conds.GenerationMatch != 0 && (existing == nil || existing.attrs.Generation != conds.GenerationMatch):
+		return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "Precondition Failed"}
+	}
+	return nil
+}
@@ -5,18 +5,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"math"
 	"net/url"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
-	"google.golang.org/api/option"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GCSEvent is the payload of a GCS event.
@@ -36,10 +39,10 @@ type GCSEvent struct {
 	// RetentionExpirationTime time.Time              `json:"retentionExpirationTime"`
 	// StorageClass            string                 `json:"storageClass"`
 	// TimeStorageClassUpdated time.Time              `json:"timeStorageClassUpdated"`
-	Size      string `json:"size"`
-	MD5Hash   string `json:"md5Hash"`
-	MediaLink string `json:"mediaLink"`
-	// ContentEncoding         string                 `json:"contentEncoding"`
+	Size            string `json:"size"`
+	MD5Hash         string `json:"md5Hash"`
+	MediaLink       string `json:"mediaLink"`
+	ContentEncoding string `json:"contentEncoding"`
 	// ContentDisposition      string                 `json:"contentDisposition"`
 	// CacheControl            string                 `json:"cacheControl"`
 	Metadata map[string]interface{} `json:"metadata"`
@@ -52,6 +55,107 @@ type GCSEvent struct {
 	// }
 	// KMSKeyName    string `json:"kmsKeyName"`
 	// ResourceState string `json:"resourceState"`
+
+	// relatedObjects caches the result of RelatedObjects
+	relatedObjects []string
+}
+
+// maxVersionAttributes limits how many component versions are recorded in
+// the metadata of a job-state index entry.
+const maxVersionAttributes = 10
+
+// ParseSize returns the size of the object in bytes, or an error if the
+// event does not carry a valid size.
+func (e GCSEvent) ParseSize() (int64, error) {
+	size, err := strconv.ParseInt(e.Size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid object size %q: %v", e.Size, err)
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("invalid object size %q: must not be negative", e.Size)
+	}
+	return size, nil
+}
+
+// ParsedSize returns the size of the object in bytes, or -1 if the event
+// does not carry a valid size.
+func (e GCSEvent) ParsedSize() int64 {
+	size, err := e.ParseSize()
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// GSURI returns the gs:// URL of the object.
+func (e GCSEvent) GSURI() string {
+	return fmt.Sprintf("gs://%s/%s", e.Bucket, e.Name)
+}
+
+// IsFinishedJSON returns true if the object is the finished.json of a build.
+func (e GCSEvent) IsFinishedJSON() bool {
+	return path.Base(e.Name) == "finished.json"
+}
+
+// IsJobMetrics returns true if the object is the job_metrics.json of a build.
+func (e GCSEvent) IsJobMetrics() bool {
+	return path.Base(e.Name) == "job_metrics.json"
+}
+
+// ParseMetadata returns the custom metadata of the object. GCS metadata
+// values are strings, so those that hold booleans or numbers are converted as
+// by MetadataFromGCSAttrs. Values that are already booleans or numbers are
+// kept, while values of other types are omitted and reported in the error.
+func (e GCSEvent) ParseMetadata() (Metadata, error) {
+	attrs := make(map[string]string, len(e.Metadata))
+	var invalid []string
+	m := make(Metadata, len(e.Metadata))
+	for k, v := range e.Metadata {
+		switch t := v.(type) {
+		case string:
+			attrs[k] = t
+		case bool, float64:
+			m[k] = t
+		default:
+			invalid = append(invalid, k)
+		}
+	}
+	for k, v := range MetadataFromGCSAttrs(attrs) {
+		m[k] = v
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return m, fmt.Errorf("metadata values of %s are not strings", strings.Join(invalid, ", "))
+	}
+	return m, nil
+}
+
+// MetadataString returns the custom metadata value key and true if it is a
+// string.
+func (e GCSEvent) MetadataString(key string) (string, bool) {
+	v, ok := e.Metadata[key].(string)
+	return v, ok
+}
+
+// RelatedObjects returns the names of all objects in the same directory as
+// the event's object, including those in subdirectories, relative to that
+// directory. The result is cached on the event so that reading several
+// sibling files requires a single listing.
+func (e *GCSEvent) RelatedObjects(ctx context.Context, client StorageClient) ([]string, error) {
+	if e.relatedObjects != nil {
+		return e.relatedObjects, nil
+	}
+	dir := path.Dir(e.Name) + "/"
+	objects, err := client.List(ctx, e.Bucket, &storage.Query{Prefix: dir})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(objects))
+	for _, attrs := range objects {
+		names = append(names, strings.TrimPrefix(attrs.Name, dir))
+	}
+	e.relatedObjects = names
+	return names, nil
 }
 
 // IndexJobs creates a date sharded index of all jobs within
@@ -66,93 +170,361 @@ type GCSEvent struct {
 //
 // Readers should not assume anything about the contents of the
 // object or that the link is in the same bucket.
+//
+// Events for jobs that are already indexed are ignored so that
+// duplicate deliveries are not retried.
 func IndexJobs(ctx context.Context, e GCSEvent) error {
+	return defaultIndexer.HandleEvent(ctx, e)
+}
+
+// IndexJobState indexes the finished.json or finished.yaml described by e
+// into the job-state index as IndexJobs does, ignoring other objects.
+func IndexJobState(ctx context.Context, e GCSEvent) error {
+	opts, err := defaultIndexer.options(ctx)
+	if err != nil {
+		return err
+	}
+	handler := FinishedJSONHandler(opts)
+	router := (&Router{}).Handle("finished.json", handler).Handle("finished.yaml", handler)
+	return ignoreDuplicate(e, router.Dispatch(ctx, e))
+}
+
+// IndexMetrics indexes the job_metrics.json described by e into the
+// job-metrics index as IndexJobs does, ignoring other objects.
+func IndexMetrics(ctx context.Context, e GCSEvent) error {
+	opts, err := defaultIndexer.options(ctx)
+	if err != nil {
+		return err
+	}
+	router := (&Router{}).Handle("job_metrics.json", JobMetricsHandler(opts))
+	return ignoreDuplicate(e, router.Dispatch(ctx, e))
+}
+
+// ignoreDuplicate returns nil if err reports that the event e was already
+// indexed, so that Cloud Functions does not retry the duplicate delivery.
+func ignoreDuplicate(e GCSEvent, err error) error {
+	if IsPreconditionFailure(err) {
+		log.Printf("Ignored duplicate event for %s: %v", e.GSURI(), err)
+		return nil
+	}
+	return err
+}
+
+// IndexJobsWithOptions indexes the object described by e as IndexJobs does,
+// using the client and index layout from opts. Unlike IndexJobs it reports
+// index entries that already exist with an error matching
+// ErrPreconditionFailed.
+func IndexJobsWithOptions(ctx context.Context, e GCSEvent, opts Options) error {
 	// meta, err := metadata.FromContext(ctx)
 	// if err != nil {
 	// 	return fmt.Errorf("metadata.FromContext: %v", err)
 	// }
-	base := path.Base(e.Name)
-	switch base {
-	case "finished.json":
+	return IndexJobsWithResult(ctx, e, opts).Err
+}
+
+// newIndexRouter returns the router used by IndexJobsWithOptions.
+func newIndexRouter(opts Options) *Router {
+	return (&Router{}).
+		Handle("finished.json", FinishedJSONHandler(opts)).
+		Handle("finished.yaml", FinishedJSONHandler(opts)).
+		Handle("started.json", StartedJSONHandler(opts)).
+		Handle("job_metrics.json", JobMetricsHandler(opts)).
+		Handle("e2e-events.json", KubernetesEventsHandler(opts)).
+		Handle("cluster-data.json", ClusterDataHandler(opts))
+}
+
+// FinishedJSONHandler indexes the state of the job whose finished.json is
+// described by the event into the job-state index. Objects with a .yaml
+// extension are parsed as YAML.
+func FinishedJSONHandler(opts Options) HandlerFunc {
+	return func(ctx context.Context, e GCSEvent) error {
+		spanAttrs := eventSpanAttributes(e)
+		_, span := opts.startSpan(ctx, "ValidateEvent", spanAttrs...)
 		parts := strings.Split(e.Name, "/")
 		if len(parts) < 4 {
+			span.End()
+			skipEvent(ctx, "not a build artifact")
+			return nil
+		}
+		if !opts.includesJob(parts[len(parts)-3]) {
+			span.End()
+			skipEvent(ctx, "job excluded by the job kind filter")
 			return nil
 		}
-		client, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadWrite))
+		spanAttrs = append(spanAttrs, attribute.String("job.name", parts[len(parts)-3]))
+		span.SetAttributes(spanAttrs...)
+		span.End()
+
+		_, span = opts.startSpan(ctx, "CreateClient", spanAttrs...)
+		client, err := opts.storageClient(ctx)
+		endSpan(span, err)
 		if err != nil {
 			return err
 		}
-		r, err := client.Bucket(e.Bucket).Object(e.Name).NewReader(ctx)
+
+		readCtx, span := opts.startSpan(ctx, "ReadFinished", spanAttrs...)
+		var data []byte
+		err = retryGCS(readCtx, gcsRetryAttempts, func() (err error) {
+			data, err = readObject(readCtx, client, e.Bucket, e.Name)
+			return err
+		})
+		if err == nil {
+			data, err = decodeContent(data)
+		}
+		endSpan(span, err)
 		if err != nil {
 			return err
 		}
-		data, err := ioutil.ReadAll(r)
-		var finished Finished
-		if err := json.Unmarshal(data, &finished); err != nil {
+
+		_, span = opts.startSpan(ctx, "ParseFinished", spanAttrs...)
+		finished, err := parseFinished(e.Name, data)
+		endSpan(span, err)
+		if err != nil {
 			return err
 		}
-		if finished.Timestamp == nil || *finished.Timestamp == 0 {
+		finishedAt, ok := finished.Time()
+		if !ok {
+			skipEvent(ctx, "job has not finished")
 			return nil
 		}
 
-		var state string
-		switch {
-		case finished.Passed == nil:
-			state = "error"
-		case *finished.Passed:
-			state = "success"
-		default:
-			state = "failed"
+		// values in finished.json take precedence over the object metadata
+		if len(e.Metadata) > 0 {
+			attrs, err := e.ParseMetadata()
+			if err != nil {
+				log.Printf("warn: Ignored some object metadata of %s: %v", e.Name, err)
+			}
+			if finished.Metadata == nil {
+				finished.Metadata = make(Metadata)
+			}
+			for k, v := range attrs {
+				if _, ok := finished.Metadata[k]; !ok {
+					finished.Metadata[k] = v
+				}
+			}
+		}
+
+		if err := finished.Metadata.Validate(); err != nil {
+			log.Printf("warn: Ignored the metadata of %s: %v", e.Name, err)
+			finished.Metadata = nil
 		}
 
+		state := finished.State()
+		spanAttrs = append(spanAttrs, attribute.String("job.state", state))
+		trace.SpanFromContext(ctx).SetAttributes(spanAttrs...)
+
 		// build index components
-		build := parts[len(parts)-2]
+		_, span = opts.startSpan(ctx, "ComputeIndexPath", spanAttrs...)
+		build, err := ParseBuildID(parts[len(parts)-2])
+		if err != nil {
+			span.End()
+			log.Printf("warn: Skipped %s: %v", e.Name, err)
+			skipEvent(ctx, err.Error())
+			return nil
+		}
 		job := parts[len(parts)-3]
-		finishedAt := time.Unix(*finished.Timestamp, 0)
-		key := finishedAt.UTC().Format(time.RFC3339)
+		key := finishedAt.Format(time.RFC3339)
 		u := (&url.URL{
 			Scheme: "gs",
 			Host:   e.Bucket,
 			Path:   path.Dir(e.Name),
 		}).String()
-		indexPath := path.Join("index", "job-state", key, job, build)
+		indexPaths := []string{path.Join(indexPrefix(opts.IndexVersion, path.Join(JobType(job), "job-state")), key, job, string(build))}
+		if opts.LegacyIndexPath {
+			indexPaths = append([]string{path.Join(indexPrefix(opts.IndexVersion, "job-state"), key, job, string(build))}, indexPaths...)
+		}
+		if variant := ExtractVariant(job); opts.VariantIndex && variant != "" {
+			indexPaths = append(indexPaths, path.Join(indexPrefix(opts.IndexVersion, path.Join("variant", variant)), key, job, string(build)))
+		}
+		span.End()
 
 		// set the data for the job to the result
-		if data, err = json.Marshal(JobResult{
+		result := JobResult{
 			State:       state,
 			CompletedAt: finishedAt.Unix(),
 			Link:        u,
-		}); err != nil {
+			JobName:     job,
+			BuildNumber: build,
+			IndexedAt:   time.Now().Unix(),
+			Branch:      ExtractBranch(job),
+		}
+		if v, ok := finished.Version(); ok && v != "" {
+			result.OpenShiftVersion = v
+		} else if v, _ := finished.Metadata.String("openshift-tests-version"); v != nil {
+			result.OpenShiftVersion = *v
+		}
+		if flags, ok := finished.FeatureFlags(); ok && len(flags) > 0 {
+			result.FeatureFlags = flags
+		}
+		if !opts.SkipArtifactSizeCalculation {
+			size, err := artifactSize(ctx, client, e.Bucket, path.Dir(e.Name)+"/")
+			if err != nil {
+				log.Printf("warn: Unable to calculate the artifact size of %s: %v", u, err)
+			} else {
+				result.ArtifactSize = size
+			}
+		}
+		if opts.includesMetricsJob(job) {
+			seconds, ok, err := jobDuration(ctx, client, e.Bucket, path.Dir(e.Name), opts.MaxMetricsFileSizeBytes)
+			if err != nil {
+				log.Printf("warn: Unable to read the duration of %s: %v", u, err)
+			} else if ok {
+				result.DurationSeconds = seconds
+			}
+		}
+		if state != "success" && opts.MaxTopFailures > 0 {
+			suites, err := readJUnitResults(ctx, client, e.Bucket, path.Dir(e.Name))
+			if err != nil {
+				log.Printf("warn: Unable to read the test results of %s: %v", u, err)
+			} else {
+				result.TopFailures = failedTests(suites, opts.MaxTopFailures)
+			}
+		}
+		if state != "success" && len(opts.FailurePatterns) > 0 {
+			reason, err := ExtractFailureReason(ctx, client, result, opts.FailurePatterns)
+			if err != nil {
+				log.Printf("warn: Unable to determine the failure reason of %s: %v", u, err)
+			} else {
+				result.FailureReason = reason
+			}
+		}
+		if data, err = json.Marshal(result); err != nil {
 			return fmt.Errorf("could not serialize job result: %v", err)
 		}
 
-		// write the link with the metadata contents
-		w := client.
-			Bucket(e.Bucket).
-			Object(indexPath).
-			If(storage.Conditions{DoesNotExist: true}).
-			NewWriter(ctx)
-		w.ObjectAttrs.Metadata = map[string]string{
-			"link":      u,
-			"state":     state,
-			"completed": strconv.FormatInt(finishedAt.Unix(), 10),
+		metadata := map[string]string{
+			"link":       u,
+			"state":      state,
+			"completed":  strconv.FormatInt(finishedAt.Unix(), 10),
+			"indexed-at": strconv.FormatInt(result.IndexedAt, 10),
+		}
+		if result.ArtifactSize > 0 {
+			metadata["artifact-size-bytes"] = strconv.FormatInt(result.ArtifactSize, 10)
+		}
+		if result.Branch != "" {
+			metadata["branch"] = result.Branch
+		}
+		if result.OpenShiftVersion != "" {
+			metadata["openshift-version"] = result.OpenShiftVersion
+		}
+		for i, name := range result.TopFailures {
+			metadata["top-failure-"+strconv.Itoa(i)] = name
+		}
+		if result.FailureReason != "" {
+			metadata["failure-reason"] = result.FailureReason
+		}
+		if commit, ok := finished.InfraCommit(); ok && commit != "" {
+			metadata["infra-commit"] = commit
+		}
+		if len(result.FeatureFlags) > 0 {
+			var count int
+			for name, enabled := range result.FeatureFlags {
+				metadata[featureFlagMetadataPrefix+name] = strconv.FormatBool(enabled)
+				if enabled {
+					count++
+				}
+			}
+			metadata["feature-flag-count"] = strconv.Itoa(count)
+		}
+		if versions, ok := finished.Versions(); ok {
+			components := make([]string, 0, len(versions))
+			for component := range versions {
+				components = append(components, component)
+			}
+			sort.Strings(components)
+			if len(components) > maxVersionAttributes {
+				components = components[:maxVersionAttributes]
+			}
+			for _, component := range components {
+				metadata["version-"+component] = versions[component]
+			}
+		}
+
+		// write the link with the metadata contents to each index, the first
+		// of which determines whether the job was already indexed
+		writeCtx, span := opts.startSpan(ctx, "WriteIndex", spanAttrs...)
+		if err := writeIndexEntries(writeCtx, client, e.Bucket, indexPaths, data, metadata, opts); err != nil {
+			endSpan(span, err)
+			return fmt.Errorf("failed to link %s: %w", u, err)
+		}
+		span.End()
+		if err := removeRunningJob(ctx, client, e.Bucket, path.Dir(e.Name), job, build, opts); err != nil {
+			log.Printf("warn: Unable to remove the running job entry of %s: %v", u, err)
 		}
-		if _, err := w.Write(data); err != nil {
-			defer w.Close()
-			return fmt.Errorf("failed to link %s to %s: %v", indexPath, u, err)
+		indexingDelaySeconds.Observe(IndexingDelay(result).Seconds())
+		opts.runSidecars(ctx, client, e.Bucket, result)
+		log.Printf("Indexed job %s with state %s to gs://%s/%s", u, state, e.Bucket, strings.Join(indexPaths, ","))
+		return nil
+	}
+}
+
+// writeIndexEntries creates an entry at each of paths. If the entry at the
+// first path already exists the job was already indexed and a precondition
+// failure is returned, while existing entries at the other paths are
+// ignored. Batched entries are written concurrently, so the other entries
+// are written even when the first already exists. Existing entries are
+// replaced if opts.Overwrite is set.
+func writeIndexEntries(ctx context.Context, client StorageClient, bucket string, paths []string, data []byte, metadata map[string]string, opts Options) error {
+	conds := opts.writeConditions()
+	if opts.BatchWrites {
+		w := &ConcurrentWriter{Writer: opts.indexWriter(client, bucket), Conditions: conds}
+		for _, indexPath := range paths {
+			w.Add(indexPath, data, metadata)
 		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("failed to link %s to %s: %v", indexPath, u, err)
+		err := w.Flush(ctx)
+		var aggErr *AggregateError
+		if !errors.As(err, &aggErr) {
+			return err
 		}
-		log.Printf("Indexed job %s with state %s to gs://%s/%s", u, state, e.Bucket, indexPath)
+		for i, indexPath := range paths {
+			if err, ok := aggErr.Errors[indexPath]; ok && (i == 0 || !IsPreconditionFailure(err)) {
+				return fmt.Errorf("unable to write %s: %w", indexPath, err)
+			}
+		}
+		return nil
+	}
+	writer := opts.indexWriter(client, bucket)
+	for i, indexPath := range paths {
+		err := retryGCS(ctx, gcsRetryAttempts, func() error {
+			return writer.Write(ctx, indexPath, data, metadata, conds)
+		})
+		if err != nil {
+			err = wrapPreconditionFailure(err)
+			if i > 0 && IsPreconditionFailure(err) {
+				continue
+			}
+			return fmt.Errorf("unable to write %s: %w", indexPath, err)
+		}
+	}
+	return nil
+}
 
-	case "job_metrics.json":
+// parseFinished decodes the named finished.json or finished.yaml.
+func parseFinished(name string, data []byte) (Finished, error) {
+	if path.Ext(name) == ".yaml" {
+		f, err := ParseFinishedYAML(data)
+		if err != nil {
+			return Finished{}, err
+		}
+		return *f, nil
+	}
+	var f Finished
+	err := json.Unmarshal(data, &f)
+	return f, err
+}
+
+// JobMetricsHandler indexes the job_metrics.json of release jobs described
+// by the event into the job-metrics index.
+func JobMetricsHandler(opts Options) HandlerFunc {
+	return func(ctx context.Context, e GCSEvent) error {
 		// only process job metrics that appear to be in a smaller set of logs
 		parts := strings.Split(e.Name, "/")
 		if len(parts) < 4 {
+			skipEvent(ctx, "not a build artifact")
 			return nil
 		}
-		var u, build, job string
+		var u, job string
+		var build BuildID
 		switch {
 		case parts[0] == "logs":
 			u = (&url.URL{
@@ -161,61 +533,116 @@ func IndexJobs(ctx context.Context, e GCSEvent) error {
 				Path:   path.Join(parts[:3]...),
 			}).String()
 			job = parts[1]
-			build = parts[2]
-			switch {
-			case strings.HasPrefix(job, "periodic-ci-openshift-release-"),
-				strings.HasPrefix(job, "release-openshift-"):
-			default:
-				// log.Printf("Skip job that is not a release job: %s", e.Name)
+			if !opts.includesMetricsJob(job) {
+				skipEvent(ctx, "not a release job")
+				return nil
+			}
+			if !opts.includesJob(job) {
+				skipEvent(ctx, "job excluded by the job kind filter")
+				return nil
+			}
+			var err error
+			if build, err = ParseBuildID(parts[2]); err != nil {
+				log.Printf("warn: Skipped %s: %v", e.Name, err)
+				skipEvent(ctx, err.Error())
 				return nil
 			}
 		default:
 			//log.Printf("Skip job that is not postsubmit/periodic: %s", e.Name)
+			skipEvent(ctx, "not a postsubmit or periodic job")
 			return nil
 		}
 
-		client, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadWrite))
+		client, err := opts.storageClient(ctx)
 		if err != nil {
 			return err
 		}
+		// skip rather than fail so that the event is not retried
+		if size, err := e.ParseSize(); err == nil && opts.MaxMetricsFileSizeBytes > 0 && size > opts.MaxMetricsFileSizeBytes {
+			log.Printf("warn: Skipped job metrics %s of %d bytes, larger than the limit of %d bytes", e.Name, size, opts.MaxMetricsFileSizeBytes)
+			skipEvent(ctx, fmt.Sprintf("job metrics of %d bytes exceed the limit of %d bytes", size, opts.MaxMetricsFileSizeBytes))
+			return nil
+		}
 
 		// read the raw output and transform into the consolidated form
 		// {
 		//	 "<name>[{<label>="<value>"]": {"timestamp":<int64>,"value":"<float64 string>"},
 		//   ...
 		// }
-		r, err := client.Bucket(e.Bucket).Object(e.Name).NewReader(ctx)
+		var r io.ReadCloser
+		err = retryGCS(ctx, gcsRetryAttempts, func() (err error) {
+			r, err = client.NewReader(ctx, e.Bucket, e.Name)
+			return err
+		})
 		if err != nil {
 			return err
 		}
-		metrics := make(map[string]PrometheusResult)
-		d := json.NewDecoder(r)
-		var rows int
-		for err = d.Decode(&metrics); err == nil; err = d.Decode(&metrics) {
-			rows++
+		defer r.Close()
+		in, err := decompressReader(r)
+		if err != nil {
+			return fmt.Errorf("unable to decode %s: %v", e.Name, err)
 		}
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to decode metric on line %d: %v", rows+1, err)
+		defer in.Close()
+		// events may not report the size, and compressed objects grow as
+		// they are decoded, so the limit also applies to what is read
+		limited := &limitedReader{r: in, n: math.MaxInt64 - 1}
+		if opts.MaxMetricsFileSizeBytes > 0 {
+			limited.n = opts.MaxMetricsFileSizeBytes
 		}
-
-		outputMetrics := make(map[string]OutputMetric, len(metrics))
-		for name, v := range metrics {
-			if v.Status != "success" {
-				continue
+		outputMetrics := make(JobMetricsIndex)
+		var droppedMetrics int
+		addMetric := func(key string, m OutputMetric) bool {
+			if opts.MaxOutputMetrics > 0 && len(outputMetrics) >= opts.MaxOutputMetrics && key != opts.requiredMetric() {
+				droppedMetrics++
+				return false
 			}
-			if v.Data.ResultType != "vector" {
-				continue
+			outputMetrics[key] = m
+			return true
+		}
+		var metricStart, metricEnd time.Time
+		var inputMetrics int
+		err = streamMetrics(json.NewDecoder(limited), func(name string, v PrometheusResult) error {
+			inputMetrics++
+			if !v.IsSuccess() {
+				return nil
+			}
+			if start, end, ok := v.TimeRange(); ok {
+				if metricStart.IsZero() || start.Before(metricStart) {
+					metricStart = start
+				}
+				if end.After(metricEnd) {
+					metricEnd = end
+				}
+			}
+			switch v.Data.ResultType {
+			case "vector":
+			case "matrix":
+				// index the most recent sample of each series
+				results := make([]PrometheusMetric, 0, len(v.Data.Result))
+				for _, result := range v.Data.Result {
+					if len(result.Values) == 0 {
+						continue
+					}
+					result.Value = PrometheusValueSlice(result.Values).Latest()
+					results = append(results, result)
+				}
+				v.Data.Result = results
+			default:
+				return nil
 			}
 			if len(v.Data.Result) == 0 {
-				continue
+				return nil
 			}
-			if len(v.Data.Result) == 1 && len(v.Data.Result[0].Metric) == 0 {
-				outputMetrics[name] = OutputMetric{
-					Value:     v.Data.Result[0].Value.Value,
-					Timestamp: v.Data.Result[0].Value.Timestamp,
+			if value, ok := v.FirstValue(); ok && len(v.Data.Result[0].Metric) == 0 {
+				added := addMetric(name, OutputMetric{
+					Value:      value.Value,
+					Timestamp:  value.Timestamp,
+					MetricName: name,
+				})
+				if added && opts.Verbose {
+					log.Printf("%s %s @ %d", name, value.Value, value.Timestamp)
 				}
-				//log.Printf("%s %s @ %d", name, v.Data.Result[0].Value.Value, v.Data.Result[0].Value.Timestamp)
-				continue
+				return nil
 			}
 			var labels []string
 			for i, result := range v.Data.Result {
@@ -231,6 +658,7 @@ func IndexJobs(ctx context.Context, e GCSEvent) error {
 					}
 				}
 				var metricSelector string
+				selected := make(map[string]string, len(labels))
 				for _, label := range labels {
 					value, ok := result.Metric[label]
 					if !ok {
@@ -241,18 +669,56 @@ func IndexJobs(ctx context.Context, e GCSEvent) error {
 						metricSelector += ","
 					}
 					metricSelector += fmt.Sprintf("%s=%q", label, value)
+					selected[label] = value
 				}
-				outputMetrics[fmt.Sprintf("%s{%s}", name, metricSelector)] = OutputMetric{
-					Value:     result.Value.Value,
-					Timestamp: result.Value.Timestamp,
+				added := addMetric(fmt.Sprintf("%s{%s}", name, metricSelector), OutputMetric{
+					Value:      result.Value.Value,
+					Timestamp:  result.Value.Timestamp,
+					MetricName: name,
+					Labels:     selected,
+				})
+				if added && opts.Verbose {
+					log.Printf("%s{%s} %s @ %d", name, metricSelector, result.Value.Value, result.Value.Timestamp)
 				}
-				//log.Printf("%s{%s} %s @ %d", name, metricSelector, result.Value.Value, result.Value.Timestamp)
 			}
+			return nil
+		})
+		if limited.exceeded {
+			log.Printf("warn: Skipped job metrics %s, larger than the limit of %d bytes once decoded", e.Name, opts.MaxMetricsFileSizeBytes)
+			skipEvent(ctx, fmt.Sprintf("decoded job metrics exceed the limit of %d bytes", opts.MaxMetricsFileSizeBytes))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", e.Name, err)
+		}
+		if droppedMetrics > 0 {
+			log.Printf("warn: Dropped %d metrics of %s beyond the limit of %d", droppedMetrics, e.Name, opts.MaxOutputMetrics)
 		}
 
-		duration, ok := outputMetrics["job:duration:total:seconds"]
-		if !ok {
-			return fmt.Errorf("job not indexed, does not have metric %q", "job:duration:total:seconds")
+		var finishedAt time.Time
+		if required, ok := outputMetrics[opts.requiredMetric()]; ok {
+			finishedAt = time.Unix(required.Timestamp, 0)
+		} else if opts.FallbackToEventTime && !e.Updated.IsZero() {
+			log.Printf("warn: Job metrics %s do not have metric %q, using the time the object was updated", e.Name, opts.requiredMetric())
+			finishedAt = e.Updated
+		} else {
+			return fmt.Errorf("job not indexed, does not have metric %q", opts.requiredMetric())
+		}
+
+		if opts.StaleMetricThreshold > 0 {
+			jobFinishedAt := finishedAt
+			stats := statsFromContext(ctx)
+			for name, m := range outputMetrics {
+				v := PrometheusValue{Timestamp: m.Timestamp, Value: m.Value}
+				if !v.IsStale(jobFinishedAt, opts.StaleMetricThreshold) {
+					continue
+				}
+				delete(outputMetrics, name)
+				staleMetricsSkipped.Inc()
+				if stats != nil {
+					stats.addStaleMetric(StaleMetricReport{MetricName: name, MetricAge: jobFinishedAt.Sub(v.Time())})
+				}
+			}
 		}
 
 		data, err := json.Marshal(outputMetrics)
@@ -261,42 +727,210 @@ func IndexJobs(ctx context.Context, e GCSEvent) error {
 		}
 
 		// build index components
-		finishedAt := time.Unix(duration.Timestamp, 0)
 		key := finishedAt.UTC().Format(time.RFC3339)
-		indexPath := path.Join("index", "job-metrics", key, job, build)
+		indexPath := path.Join(indexPrefix(opts.IndexVersion, "job-metrics"), key, job, string(build))
 
-		// write the link with the metadata contents
-		w := client.
-			Bucket(e.Bucket).
-			Object(indexPath).
-			If(storage.Conditions{DoesNotExist: true}).
-			NewWriter(ctx)
-		w.ObjectAttrs.Metadata = map[string]string{
+		metadata := map[string]string{
 			"link":      u,
 			"completed": strconv.FormatInt(finishedAt.Unix(), 10),
 		}
-		if _, err := w.Write(data); err != nil {
-			defer w.Close()
-			return fmt.Errorf("failed to write metrics %s to %s: %v", indexPath, u, err)
+		if !metricStart.IsZero() {
+			metadata["metric-start"] = strconv.FormatInt(metricStart.Unix(), 10)
+			metadata["metric-end"] = strconv.FormatInt(metricEnd.Unix(), 10)
 		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("failed to write metrics %s to %s: %v", indexPath, u, err)
+
+		// the combined entry marks the build as indexed, so the entries of
+		// each metric are written first and a retried event writes any that
+		// failed
+		if opts.WritePerMetric {
+			if err := writeMetricEntries(ctx, client, e.Bucket, key, job, string(build), outputMetrics, metadata, opts); err != nil {
+				return fmt.Errorf("failed to write metrics of %s: %w", u, err)
+			}
 		}
 
-		log.Printf("Indexed %d job metrics %s in %d bytes to gs://%s/%s (link to %s)", len(metrics), e.Name, len(data), e.Bucket, indexPath, u)
+		// write the link with the metadata contents
+		writer := opts.indexWriter(client, e.Bucket)
+		err = retryGCS(ctx, gcsRetryAttempts, func() error {
+			return writer.Write(ctx, indexPath, data, metadata, opts.writeConditions())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write metrics %s to %s: %w", indexPath, u, wrapPreconditionFailure(err))
+		}
+		if size := e.ParsedSize(); opts.RecordMetricsSizes && size >= 0 {
+			if err := recordObjectSize(ctx, client, e.Bucket, sizeHistogramPath(opts.IndexVersion), DefaultSizeHistogramBounds, size); err != nil {
+				log.Printf("warn: Unable to record the size of %s: %v", e.Name, err)
+			}
+		}
+
+		log.Printf("Indexed %d job metrics %s in %d bytes to gs://%s/%s (link to %s)", inputMetrics, e.Name, len(data), e.Bucket, indexPath, u)
+		return nil
 	}
-	return nil
+}
+
+// writeMetricEntries writes each of metrics to its own entry in the metrics
+// index, opts.WriteWorkers at a time. Metric names are path escaped since
+// label values may contain slashes. Entries that already exist are left
+// unchanged, and the paths that could not be written are returned in an
+// *AggregateError.
+func writeMetricEntries(ctx context.Context, client StorageClient, bucket, key, job, build string, metrics map[string]OutputMetric, metadata map[string]string, opts Options) error {
+	w := &ConcurrentWriter{Writer: opts.indexWriter(client, bucket), Conditions: opts.writeConditions(), MaxConcurrency: opts.WriteWorkers}
+	for name, m := range metrics {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("unable to marshal metric %s: %v", name, err)
+		}
+		w.Add(path.Join(indexPrefix(opts.IndexVersion, "metrics"), url.PathEscape(name), key, job, build), data, metadata)
+	}
+	err := w.Flush(ctx)
+	var aggErr *AggregateError
+	if !errors.As(err, &aggErr) {
+		return err
+	}
+	for indexPath, err := range aggErr.Errors {
+		if IsPreconditionFailure(err) {
+			delete(aggErr.Errors, indexPath)
+		}
+	}
+	if len(aggErr.Errors) == 0 {
+		return nil
+	}
+	return aggErr
 }
 
 type JobResult struct {
-	State       string `json:"state"`
-	CompletedAt int64  `json:"completed_at"`
-	Link        string `json:"link"`
+	State        string `json:"state"`
+	CompletedAt  int64  `json:"completed_at"`
+	Link         string `json:"link"`
+	IndexedAt    int64  `json:"indexed_at,omitempty"`
+	ArtifactSize int64  `json:"artifact_size,omitempty"`
+	// Branch is the branch tested by the job, if known.
+	Branch string `json:"branch,omitempty"`
+	// OpenShiftVersion is the version of OpenShift that was tested, if known.
+	OpenShiftVersion string `json:"openshift_version,omitempty"`
+	// TopFailures names the first tests that failed in an unsuccessful build.
+	TopFailures []string `json:"top_failures,omitempty"`
+	// FailureReason names the first failure pattern that matched the log of
+	// an unsuccessful build.
+	FailureReason string `json:"failure_reason,omitempty"`
+	// Annotations are added by operators after the job is indexed. See
+	// AnnotateJobResult.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// FeatureFlags are the feature gates the job enabled or disabled.
+	FeatureFlags map[string]bool `json:"feature_flags,omitempty"`
+	// JobName and BuildNumber identify the build, as in the index path.
+	JobName     string  `json:"job_name,omitempty"`
+	BuildNumber BuildID `json:"build_number,omitempty"`
+	// DurationSeconds is the value of the job:duration:total:seconds metric
+	// of the build, if known.
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// artifactSize returns the total size of all objects under prefix.
+func artifactSize(ctx context.Context, client StorageClient, bucket, prefix string) (int64, error) {
+	objects, err := client.List(ctx, bucket, &storage.Query{Prefix: prefix})
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, attrs := range objects {
+		size += attrs.Size
+	}
+	return size, nil
+}
+
+// jobDuration returns the value of the DefaultRequiredMetric, the job
+// duration, in the job_metrics.json of the build in dir, and false if the
+// build has no metrics or the metric is missing. As in JobMetricsHandler no
+// more than limit bytes are decoded unless limit is zero.
+func jobDuration(ctx context.Context, client StorageClient, bucket, dir string, limit int64) (float64, bool, error) {
+	r, err := client.NewReader(ctx, bucket, path.Join(dir, "artifacts", "metrics", "job_metrics.json"))
+	if err == storage.ErrObjectNotExist {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer r.Close()
+	in, err := decompressReader(r)
+	if err != nil {
+		return 0, false, err
+	}
+	defer in.Close()
+	limited := &limitedReader{r: in, n: math.MaxInt64 - 1}
+	if limit > 0 {
+		limited.n = limit
+	}
+	var duration *PrometheusValue
+	err = streamMetrics(json.NewDecoder(limited), func(name string, result PrometheusResult) error {
+		if name != DefaultRequiredMetric || !result.IsSuccess() {
+			return nil
+		}
+		if v, ok := result.FirstValue(); ok {
+			duration = &v
+		}
+		return nil
+	})
+	if err != nil || duration == nil {
+		return 0, false, err
+	}
+	seconds, err := duration.Float64()
+	if err != nil {
+		return 0, false, err
+	}
+	return seconds, true, nil
+}
+
+// IndexingDelay returns how long after the job completed its result was
+// indexed.
+func IndexingDelay(r JobResult) time.Duration {
+	return time.Unix(r.IndexedAt, 0).Sub(time.Unix(r.CompletedAt, 0))
+}
+
+// streamMetrics calls handler with each metric in one or more consecutive
+// JSON objects mapping metric names to query results, decoding a single
+// result at a time rather than the whole file.
+func streamMetrics(dec *json.Decoder, handler func(name string, result PrometheusResult) error) error {
+	for row := 1; ; row++ {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", row, err)
+		}
+		if delim, ok := t.(json.Delim); !ok || delim != '{' {
+			return fmt.Errorf("line %d: expected an object, got %v", row, t)
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("line %d: %v", row, err)
+			}
+			name, ok := t.(string)
+			if !ok {
+				return fmt.Errorf("line %d: expected a metric name, got %v", row, t)
+			}
+			var result PrometheusResult
+			if err := dec.Decode(&result); err != nil {
+				return fmt.Errorf("line %d: metric %s: %v", row, name, err)
+			}
+			if err := handler(name, result); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("line %d: %v", row, err)
+		}
+	}
 }
 
 type OutputMetric struct {
 	Timestamp int64  `json:"timestamp"`
 	Value     string `json:"value"`
+	// MetricName and Labels are the parts of the key the metric is stored
+	// under, as returned by ParseMetricKey.
+	MetricName string            `json:"metric_name,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
 }
 
 type PrometheusResult struct {
@@ -304,6 +938,59 @@ type PrometheusResult struct {
 	Data   PrometheusData `json:"data"`
 }
 
+// IsSuccess returns true if the query succeeded.
+func (r PrometheusResult) IsSuccess() bool {
+	return r.Status == "success"
+}
+
+// FirstValue returns the value of the only result of a successful query. It
+// returns false if the query failed or did not return exactly one result.
+func (r PrometheusResult) FirstValue() (PrometheusValue, bool) {
+	if !r.IsSuccess() || len(r.Data.Result) != 1 {
+		return PrometheusValue{}, false
+	}
+	return r.Data.Result[0].Value, true
+}
+
+// SingletonValue returns the value of the only result of a query, or an
+// error if the query failed or returned no or several results.
+func (r PrometheusResult) SingletonValue() (PrometheusValue, error) {
+	if !r.IsSuccess() {
+		return PrometheusValue{}, fmt.Errorf("query was not successful: %s", r.Status)
+	}
+	if n := len(r.Data.Result); n != 1 {
+		return PrometheusValue{}, fmt.Errorf("expected a single result, got %d", n)
+	}
+	return r.Data.Result[0].Value, nil
+}
+
+// TimeRange returns the earliest and latest sample times across all series
+// of a matrix result. It returns false for other result types or when there
+// are no samples.
+func (r PrometheusResult) TimeRange() (start, end time.Time, ok bool) {
+	if r.Data.ResultType != "matrix" {
+		return time.Time{}, time.Time{}, false
+	}
+	var min, max int64
+	for _, series := range r.Data.Result {
+		for _, sample := range series.Values {
+			if !ok || sample.Timestamp < min {
+				min = sample.Timestamp
+			}
+			if !ok || sample.Timestamp > max {
+				max = sample.Timestamp
+			}
+			ok = true
+		}
+	}
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(min, 0).UTC(), time.Unix(max, 0).UTC(), true
+}
+
+// PrometheusData holds the result of a query. Vector results have a single
+// Value per series while matrix results, from range queries, have Values.
 type PrometheusData struct {
 	ResultType string             `json:"resultType"`
 	Result     []PrometheusMetric `json:"result"`
@@ -312,6 +999,8 @@ type PrometheusData struct {
 type PrometheusMetric struct {
 	Metric PrometheusLabels `json:"metric"`
 	Value  PrometheusValue  `json:"value"`
+	// Values holds the samples of a series in a matrix result.
+	Values []PrometheusValue `json:"values,omitempty"`
 }
 
 type PrometheusValue struct {
@@ -319,12 +1008,114 @@ type PrometheusValue struct {
 	Value     string
 }
 
+var _ json.Marshaler = PrometheusValue{}
+var _ json.Unmarshaler = &PrometheusValue{}
+
+// Compare orders values by timestamp and then by value, returning -1, 0 or
+// +1 if v is before, the same as or after other.
+func (v PrometheusValue) Compare(other PrometheusValue) int {
+	switch {
+	case v.Timestamp < other.Timestamp:
+		return -1
+	case v.Timestamp > other.Timestamp:
+		return 1
+	}
+	return strings.Compare(v.Value, other.Value)
+}
+
+// Time returns the time the value was sampled, in UTC.
+func (v PrometheusValue) Time() time.Time {
+	return time.Unix(v.Timestamp, 0).UTC()
+}
+
+// Float64 returns the sampled value, which may be NaN or an infinity.
+func (v PrometheusValue) Float64() (float64, error) {
+	return strconv.ParseFloat(v.Value, 64)
+}
+
+// IsNaN returns true if the sampled value is NaN.
+func (v PrometheusValue) IsNaN() bool {
+	f, err := v.Float64()
+	return err == nil && math.IsNaN(f)
+}
+
+// IsPosInf returns true if the sampled value is +Inf.
+func (v PrometheusValue) IsPosInf() bool {
+	f, err := v.Float64()
+	return err == nil && math.IsInf(f, 1)
+}
+
+// IsNegInf returns true if the sampled value is -Inf.
+func (v PrometheusValue) IsNegInf() bool {
+	f, err := v.Float64()
+	return err == nil && math.IsInf(f, -1)
+}
+
+// IsStale returns true if v was sampled more than maxAge before the job
+// finished.
+func (v PrometheusValue) IsStale(jobFinishedAt time.Time, maxAge time.Duration) bool {
+	return jobFinishedAt.Sub(v.Time()) > maxAge
+}
+
+// PrometheusValueSlice sorts values in the order defined by Compare.
+type PrometheusValueSlice []PrometheusValue
+
+var _ sort.Interface = PrometheusValueSlice(nil)
+
+func (s PrometheusValueSlice) Len() int           { return len(s) }
+func (s PrometheusValueSlice) Less(i, j int) bool { return s[i].Compare(s[j]) < 0 }
+func (s PrometheusValueSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Latest returns the most recent value, or the zero value if s is empty.
+func (s PrometheusValueSlice) Latest() PrometheusValue {
+	var latest PrometheusValue
+	for i, v := range s {
+		if i == 0 || v.Compare(latest) > 0 {
+			latest = v
+		}
+	}
+	return latest
+}
+
 // PrometheusLabels avoids deserialization allocations
 type PrometheusLabels map[string]string
 
 var _ json.Marshaler = PrometheusLabels(nil)
 var _ json.Unmarshaler = &PrometheusLabels{}
 
+// String formats the labels as a Prometheus selector with sorted keys, e.g.
+// {namespace="openshift-etcd",pod="etcd-0"}. Values are quoted as in the
+// metric keys of a job-metrics index entry.
+func (l PrometheusLabels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, l[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Subset returns the labels with the given keys. Keys that are not set are
+// omitted.
+func (l PrometheusLabels) Subset(keys []string) PrometheusLabels {
+	subset := make(PrometheusLabels, len(keys))
+	for _, k := range keys {
+		if v, ok := l[k]; ok {
+			subset[k] = v
+		}
+	}
+	return subset
+}
+
 func (l PrometheusLabels) MarshalJSON() ([]byte, error) {
 	if len(l) == 0 {
 		return []byte(`{}`), nil
@@ -346,7 +1137,7 @@ func (l *PrometheusLabels) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 	if l == nil {
-		*l = make(map[string]string)
+		return fmt.Errorf("cannot decode into a nil PrometheusLabels")
 	}
 	var m *map[string]string = (*map[string]string)(l)
 	return json.Unmarshal(data, m)
@@ -362,6 +1153,19 @@ const (
 	doneState
 )
 
+// MarshalJSON encodes the value in the Prometheus wire format,
+// [<timestamp int>, "<number string>"].
+func (v PrometheusValue) MarshalJSON() ([]byte, error) {
+	value, err := json.Marshal(v.Value)
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte{'['}, strconv.FormatInt(v.Timestamp, 10)...)
+	data = append(data, ',')
+	data = append(data, value...)
+	return append(data, ']'), nil
+}
+
 func (l *PrometheusValue) UnmarshalJSON(data []byte) error {
 	switch {
 	case len(data) == 4 && bytes.Equal(data, []byte("null")):
@@ -378,7 +1182,7 @@ func (l *PrometheusValue) UnmarshalJSON(data []byte) error {
 			switch state {
 			case startState:
 				if l == nil {
-					*l = PrometheusValue{}
+					return fmt.Errorf("cannot decode into a nil PrometheusValue")
 				}
 				data = bytes.TrimSpace(data[1:])
 				state = timestampState
@@ -401,11 +1205,14 @@ func (l *PrometheusValue) UnmarshalJSON(data []byte) error {
 					return fmt.Errorf("expected [<timestamp int>, \"<number string>\"], could not find comma")
 				}
 				timestampBytes := bytes.TrimSpace(data[:pos])
-				var err error
-				l.Timestamp, err = strconv.ParseInt(string(timestampBytes), 10, 64)
+				timestamp, err := strconv.ParseInt(string(timestampBytes), 10, 64)
 				if err != nil {
 					return fmt.Errorf("expected [<timestamp int>, \"<number string>\"], timestamp was not an int64: %v", err)
 				}
+				if timestamp < 0 {
+					return fmt.Errorf("expected [<timestamp int>, \"<number string>\"], timestamp was negative")
+				}
+				l.Timestamp = timestamp
 				data = data[pos+1:]
 				state = stringNumberState
 			case stringNumberState:
@@ -421,6 +1228,7 @@ func (l *PrometheusValue) UnmarshalJSON(data []byte) error {
 				if len(b) != len(bytes.TrimSpace(b)) {
 					return fmt.Errorf("expected [<timestamp int>, \"<number string>\"], number was not a valid float64: whitespace in string")
 				}
+				// NaN, +Inf and -Inf are accepted and stored as written
 				s := string(b)
 				if _, err := strconv.ParseFloat(s, 64); err != nil {
 					return fmt.Errorf("expected [<timestamp int>, \"<number string>\"], number was not a valid float64: %v", err)
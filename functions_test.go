@@ -2,42 +2,504 @@
 package cisearch
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path"
 	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
 )
 
 func TestIndexJobs(t *testing.T) {
 	tests := []struct {
 		name    string
 		e       GCSEvent
+		expect  string
 		wantErr bool
 	}{
-		// TODO: make the actual write a no-op in tests
-		// {
-		// 	e: GCSEvent{
-		// 		Bucket: "origin-ci-test",
-		// 		Name:   "logs/release-openshift-origin-installer-e2e-gcp-upgrade-4.8/1366716541889941504/artifacts/e2e-gcp-upgrade/gather-extra/artifacts/metrics/job_metrics.json",
-		// 	},
-		// },
+		{
+			name:   "job metrics",
+			e:      GCSEvent{Bucket: "bucket", Name: testMetricsName},
+			expect: testMetricsPath,
+		},
+		{
+			name:   "finished.json",
+			e:      GCSEvent{Bucket: "bucket", Name: testFinishedName},
+			expect: testStatePath,
+		},
+		{
+			name: "other objects are ignored",
+			e:    GCSEvent{Bucket: "bucket", Name: "logs/job/1000/build-log.txt"},
+		},
+		{
+			name:    "missing object",
+			e:       GCSEvent{Bucket: "bucket", Name: "logs/job/1001/finished.json"},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			client.put("bucket", testMetricsName, []byte(testJobMetrics), nil)
+			withIndexOptions(t, client)
 			if err := IndexJobs(context.TODO(), tt.e); (err != nil) != tt.wantErr {
 				t.Errorf("IndexJobs() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.expect != "" && client.get("bucket", tt.expect) == nil {
+				t.Errorf("expected index entry %s", tt.expect)
+			}
+			// duplicate deliveries are ignored
+			if err := IndexJobs(context.TODO(), tt.e); (err != nil) != tt.wantErr {
+				t.Errorf("IndexJobs() redelivery error = %v, wantErr %v", err, tt.wantErr)
+			}
 		})
 	}
 }
 
-func TestPrometheusValue_UnmarshalJSON(t *testing.T) {
+// withIndexOptions makes IndexJobs, IndexJobState and IndexMetrics use
+// client for the duration of the test.
+func withIndexOptions(t *testing.T, client StorageClient) {
+	original := defaultIndexer
+	t.Cleanup(func() { defaultIndexer = original })
+	opts := DefaultOptions()
+	opts.Client = client
+	defaultIndexer = NewIndexer(opts)
+}
+
+const (
+	testFinishedName = "logs/job/1000/finished.json"
+	testMetricsName  = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	testStatePath    = "index/job-state/2024-01-15T12:00:00Z/job/1000"
+	testMetricsPath  = "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000"
+)
+
+// indexTestJobState stores finished at testFinishedName in the client of
+// opts, which must be a *FakeGCSClient, indexes it with opts and returns the
+// job-state entry at testStatePath.
+func indexTestJobState(t *testing.T, opts Options, finished string) *fakeObject {
+	t.Helper()
+	client := opts.Client.(*FakeGCSClient)
+	client.put("bucket", testFinishedName, []byte(finished), nil)
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", testStatePath)
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	return obj
+}
+
+func TestIndexJobState(t *testing.T) {
 	tests := []struct {
 		name    string
-		data    []byte
+		e       GCSEvent
+		indexed bool
 		wantErr bool
-		initial *PrometheusValue
-		expect  *PrometheusValue
 	}{
+		{name: "finished.json", e: GCSEvent{Bucket: "bucket", Name: testFinishedName}, indexed: true},
+		{name: "already indexed", e: GCSEvent{Bucket: "bucket", Name: testFinishedName}, indexed: true},
+		{name: "job metrics are ignored", e: GCSEvent{Bucket: "bucket", Name: testMetricsName}},
+		{name: "missing finished.json", e: GCSEvent{Bucket: "bucket", Name: "logs/job/1001/finished.json"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			client.put("bucket", testMetricsName, []byte(testJobMetrics), nil)
+			if tt.name == "already indexed" {
+				client.put("bucket", testStatePath, nil, nil)
+			}
+			withIndexOptions(t, client)
+			if err := IndexJobState(context.TODO(), tt.e); (err != nil) != tt.wantErr {
+				t.Errorf("IndexJobState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if indexed := client.get("bucket", testStatePath) != nil; indexed != tt.indexed {
+				t.Errorf("expected indexed=%t", tt.indexed)
+			}
+			if client.get("bucket", testMetricsPath) != nil {
+				t.Error("unexpected job-metrics index entry")
+			}
+		})
+	}
+}
+
+func TestIndexJobState_DuplicateEvent(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	withIndexOptions(t, client)
+
+	e := GCSEvent{Bucket: "bucket", Name: testFinishedName}
+	if err := IndexJobState(context.TODO(), e); err != nil {
+		t.Fatal(err)
+	}
+	first := client.get("bucket", testStatePath)
+	if first == nil {
+		t.Fatal("expected index entry")
+	}
+	if err := IndexJobState(context.TODO(), e); err != nil {
+		t.Errorf("expected a duplicate event to succeed, got %v", err)
+	}
+	if err := IndexJobsWithOptions(context.TODO(), e, defaultIndexer.Config); !IsPreconditionFailure(err) {
+		t.Errorf("expected the duplicate write to fail its precondition, got %v", err)
+	}
+	if client.get("bucket", testStatePath) != first {
+		t.Error("expected the index entry to be left unchanged")
+	}
+}
+
+func TestIndexMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		e       GCSEvent
+		indexed bool
+		wantErr bool
+	}{
+		{name: "job_metrics.json", e: GCSEvent{Bucket: "bucket", Name: testMetricsName}, indexed: true},
+		{name: "already indexed", e: GCSEvent{Bucket: "bucket", Name: testMetricsName}, indexed: true},
+		{name: "finished.json is ignored", e: GCSEvent{Bucket: "bucket", Name: testFinishedName}},
+		{name: "not a release job", e: GCSEvent{Bucket: "bucket", Name: "logs/job/1000/artifacts/metrics/job_metrics.json"}},
+		{name: "missing job_metrics.json", e: GCSEvent{Bucket: "bucket", Name: "logs/release-openshift-origin-installer-e2e-aws-upgrade/1001/artifacts/metrics/job_metrics.json"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			client.put("bucket", testMetricsName, []byte(testJobMetrics), nil)
+			if tt.name == "already indexed" {
+				client.put("bucket", testMetricsPath, nil, nil)
+			}
+			withIndexOptions(t, client)
+			if err := IndexMetrics(context.TODO(), tt.e); (err != nil) != tt.wantErr {
+				t.Errorf("IndexMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if indexed := client.get("bucket", testMetricsPath) != nil; indexed != tt.indexed {
+				t.Errorf("expected indexed=%t", tt.indexed)
+			}
+			if client.get("bucket", testStatePath) != nil {
+				t.Error("unexpected job-state index entry")
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_RequiredMetric(t *testing.T) {
+	const name = "logs/periodic-ci-openshift-origin-e2e/1000/artifacts/metrics/job_metrics.json"
+	const indexPath = "index/job-metrics/2024-01-15T12:00:00Z/periodic-ci-openshift-origin-e2e/1000"
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(`{"job:duration:e2e:seconds":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"3000"]}]}}}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil || client.get("bucket", indexPath) != nil {
+		t.Fatalf("expected jobs without a default prefix to be skipped, got %v", err)
+	}
+	opts.MetricsJobPrefixes = []string{"periodic-ci-openshift-"}
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err == nil {
+		t.Fatal("expected an error without the default required metric")
+	}
+	opts.RequiredMetric = "job:duration:e2e:seconds"
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", indexPath) == nil {
+		t.Error("expected index entry")
+	}
+}
+
+func TestIndexJobsWithOptions_FallbackToEventTime(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	const indexPath = "index/job-metrics/2024-01-15T13:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000"
+	updated := time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		fallback bool
+		updated  time.Time
+		indexed  bool
+	}{
+		{name: "required metric missing", updated: updated},
+		{name: "fallback to event time", fallback: true, updated: updated, indexed: true},
+		{name: "fallback without event time", fallback: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", name, []byte(testMatrixMetrics), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			opts.FallbackToEventTime = tt.fallback
+			err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, Updated: tt.updated}, opts)
+			if (err == nil) != tt.indexed {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			obj := client.get("bucket", indexPath)
+			if (obj != nil) != tt.indexed {
+				t.Fatalf("expected indexed=%t", tt.indexed)
+			}
+			if obj != nil && obj.attrs.Metadata["completed"] != strconv.FormatInt(updated.Unix(), 10) {
+				t.Errorf("unexpected completed metadata %q", obj.attrs.Metadata["completed"])
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_WriteError(t *testing.T) {
+	for _, name := range []string{testFinishedName, testMetricsName} {
+		t.Run(name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			client.put("bucket", testMetricsName, []byte(testJobMetrics), nil)
+			client.writeErr = fmt.Errorf("connection reset")
+			opts := DefaultOptions()
+			opts.Client = client
+			if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err == nil || !strings.Contains(err.Error(), "connection reset") {
+				t.Errorf("expected the write error, got %v", err)
+			}
+			client.lock.Lock()
+			defer client.lock.Unlock()
+			if client.openWriters != 0 {
+				t.Errorf("expected every writer to be closed before returning, %d are open", client.openWriters)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_IndexedAt(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Client = NewFakeGCSClient()
+	before := time.Now().Unix()
+	obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":false}`)
+	var result JobResult
+	if err := json.Unmarshal(obj.data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.IndexedAt < before || result.IndexedAt > time.Now().Unix() {
+		t.Errorf("unexpected indexed_at %d", result.IndexedAt)
+	}
+	if obj.attrs.Metadata["indexed-at"] != strconv.FormatInt(result.IndexedAt, 10) {
+		t.Errorf("unexpected indexed-at metadata %q", obj.attrs.Metadata["indexed-at"])
+	}
+}
+
+func TestIndexJobsWithOptions_JobIdentity(t *testing.T) {
+	const releaseJob = "release-openshift-origin-installer-e2e-aws-upgrade"
+	tests := []struct {
+		name     string
+		job      string
+		metrics  string
+		duration float64
+	}{
+		{name: "release job with metrics", job: releaseJob, metrics: testJobMetrics, duration: 3600},
+		{name: "compressed metrics", job: releaseJob, metrics: testJobMetrics, duration: 3600},
+		{name: "release job without metrics", job: releaseJob},
+		{name: "release job without duration", job: releaseJob, metrics: `{"cluster:usage:cpu:total:seconds":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"12"]}]}}}`},
+		{name: "metrics of other jobs are not read", job: "job", metrics: testJobMetrics},
+		{name: "metrics larger than the limit", job: releaseJob, metrics: testJobMetrics + strings.Repeat(" ", 1024)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			dir := path.Join("logs", tt.job, "1000")
+			client.put("bucket", path.Join(dir, "finished.json"), []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			if tt.metrics != "" {
+				data := []byte(tt.metrics)
+				if tt.name == "compressed metrics" {
+					data = gzipData(t, tt.metrics)
+				}
+				client.put("bucket", path.Join(dir, "artifacts", "metrics", "job_metrics.json"), data, nil)
+			}
+			opts := DefaultOptions()
+			opts.Client = client
+			opts.MaxMetricsFileSizeBytes = 1024
+			if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: path.Join(dir, "finished.json")}, opts); err != nil {
+				t.Fatal(err)
+			}
+			obj := client.get("bucket", path.Join("index/job-state/2024-01-15T12:00:00Z", tt.job, "1000"))
+			if obj == nil {
+				t.Fatal("expected index entry")
+			}
+			var result JobResult
+			if err := json.Unmarshal(obj.data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.JobName != tt.job || result.BuildNumber != "1000" || result.DurationSeconds != tt.duration {
+				t.Errorf("unexpected job %q build %q duration %v", result.JobName, result.BuildNumber, result.DurationSeconds)
+			}
+		})
+	}
+
+	// entries written before the fields were added still decode
+	var result JobResult
+	if err := json.Unmarshal([]byte(`{"state":"success","completed_at":1705320000,"link":"gs://bucket/logs/job/1000"}`), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.JobName != "" || result.BuildNumber != "" || result.DurationSeconds != 0 {
+		t.Errorf("unexpected fields decoded from a legacy entry: %+v", result)
+	}
+}
+
+func TestIndexingDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		result JobResult
+		expect time.Duration
+	}{
+		{name: "delayed", result: JobResult{CompletedAt: 1705320000, IndexedAt: 1705320090}, expect: 90 * time.Second},
+		{name: "immediate", result: JobResult{CompletedAt: 1705320000, IndexedAt: 1705320000}},
+		{name: "clock skew", result: JobResult{CompletedAt: 1705320000, IndexedAt: 1705319990}, expect: -10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IndexingDelay(tt.result); got != tt.expect {
+				t.Errorf("IndexingDelay() = %v, want %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+const testJobMetrics = `{"job:duration:total:seconds":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"3600"]}]}}}`
+
+func TestIndexJobsWithOptions_MaxMetricsFileSize(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	const indexPath = "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000"
+	tests := []struct {
+		name    string
+		size    int64
+		indexed bool
+	}{
+		{name: "below limit", size: 1024, indexed: true},
+		{name: "at limit", size: 10 * 1024 * 1024, indexed: true},
+		{name: "above limit", size: 100 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", name, []byte(testJobMetrics), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			e := GCSEvent{Bucket: "bucket", Name: name, Size: strconv.FormatInt(tt.size, 10)}
+			if err := IndexJobsWithOptions(context.TODO(), e, opts); err != nil {
+				t.Fatal(err)
+			}
+			if indexed := client.get("bucket", indexPath) != nil; indexed != tt.indexed {
+				t.Errorf("expected indexed=%t, got %t", tt.indexed, indexed)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_MaxMetricsFileSizeDecoded(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	var buf strings.Builder
+	buf.WriteString(testJobMetrics)
+	for buf.Len() < 2*1024*1024 {
+		buf.WriteString("\n" + testJobMetrics)
+	}
+	data := gzipData(t, buf.String())
+	for _, size := range []string{"", strconv.Itoa(len(data))} {
+		t.Run("size="+size, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", name, data, nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			opts.MaxMetricsFileSizeBytes = 1024 * 1024
+			result := IndexJobsWithResult(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, Size: size}, opts)
+			if result.Err != nil || !result.IsSkipped || !strings.Contains(result.SkipReason, "decoded job metrics exceed") {
+				t.Errorf("expected the decoded metrics to be skipped, got %+v", result)
+			}
+		})
+	}
+}
+
+// heapSamplingClient records the largest heap seen while objects are read.
+type heapSamplingClient struct {
+	*FakeGCSClient
+	maxHeap uint64
+}
+
+func (c *heapSamplingClient) NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	r, err := c.FakeGCSClient.NewReader(ctx, bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return &heapSamplingReader{ReadCloser: r, client: c}, nil
+}
+
+type heapSamplingReader struct {
+	io.ReadCloser
+	client *heapSamplingClient
+	reads  int
+}
+
+func (r *heapSamplingReader) Read(p []byte) (int, error) {
+	if r.reads++; r.reads%16 == 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > r.client.maxHeap {
+			r.client.maxHeap = stats.HeapAlloc
+		}
+	}
+	return r.ReadCloser.Read(p)
+}
+
+func TestIndexJobsWithOptions_MetricsMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads 10 MB of metrics")
+	}
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	const fileSize = 10 * 1024 * 1024
+	// failed queries are decoded and dropped, so the index entry stays small
+	var buf bytes.Buffer
+	buf.WriteString(testJobMetrics)
+	padding := strings.Repeat("x", 1000)
+	for i := 0; buf.Len() < fileSize; i++ {
+		fmt.Fprintf(&buf, `{"failed_%d":{"status":"error","error":%q}}`+"\n", i, padding)
+	}
+	data := buf.Bytes()
+
+	client := &heapSamplingClient{FakeGCSClient: NewFakeGCSClient()}
+	client.put("bucket", name, data, nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.MaxMetricsFileSizeBytes = 2 * fileSize
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, Size: strconv.Itoa(len(data))}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000") == nil {
+		t.Fatal("expected index entry")
+	}
+	if growth := int64(client.maxHeap) - int64(before.HeapAlloc); growth > fileSize/2 {
+		t.Errorf("heap grew by %d bytes while decoding %d bytes of metrics", growth, len(data))
+	}
+}
+
+type prometheusValueUnmarshalJSONTest struct {
+	name    string
+	data    []byte
+	wantErr bool
+	initial *PrometheusValue
+	expect  *PrometheusValue
+}
+
+// prometheusValueUnmarshalJSONTests returns new test cases on each call as
+// the initial values are modified. The inputs also seed the fuzz target.
+func prometheusValueUnmarshalJSONTests() []prometheusValueUnmarshalJSONTest {
+	return []prometheusValueUnmarshalJSONTest{
 		{
 			data:    []byte(`null`),
 			initial: &PrometheusValue{},
@@ -123,7 +585,38 @@ func TestPrometheusValue_UnmarshalJSON(t *testing.T) {
 			initial: &PrometheusValue{Value: "test"},
 			expect:  &PrometheusValue{Timestamp: 1, Value: "1.1"},
 		},
+		{
+			data:    []byte(`[1, "NaN"]`),
+			initial: &PrometheusValue{Value: "test"},
+			expect:  &PrometheusValue{Timestamp: 1, Value: "NaN"},
+		},
+		{
+			data:    []byte(`[1, "+Inf"]`),
+			initial: &PrometheusValue{Value: "test"},
+			expect:  &PrometheusValue{Timestamp: 1, Value: "+Inf"},
+		},
+		{
+			data:    []byte(`[1, "-Inf"]`),
+			initial: &PrometheusValue{Value: "test"},
+			expect:  &PrometheusValue{Timestamp: 1, Value: "-Inf"},
+		},
+		{
+			data:    []byte(`[1, "Nope"]`),
+			initial: &PrometheusValue{Value: "test"},
+			expect:  &PrometheusValue{Timestamp: 1, Value: "test"},
+			wantErr: true,
+		},
+		{
+			data:    []byte(`[-1, "1"]`),
+			initial: &PrometheusValue{Value: "test"},
+			expect:  &PrometheusValue{Value: "test"},
+			wantErr: true,
+		},
 	}
+}
+
+func TestPrometheusValue_UnmarshalJSON(t *testing.T) {
+	tests := prometheusValueUnmarshalJSONTests()
 	for _, tt := range tests {
 		n := tt.name
 		if len(n) == 0 {
@@ -138,6 +631,828 @@ func TestPrometheusValue_UnmarshalJSON(t *testing.T) {
 			if !reflect.DeepEqual(tt.expect, tt.initial) {
 				t.Errorf("Unexpected output value: %#v", tt.initial)
 			}
+			// only values that decoded to a number can be encoded and decoded again
+			if tt.wantErr || tt.initial == nil {
+				return
+			}
+			if _, err := tt.initial.Float64(); err != nil {
+				return
+			}
+			data, err := json.Marshal(tt.initial)
+			if err != nil {
+				t.Fatalf("PrometheusValue.MarshalJSON() error = %v", err)
+			}
+			var roundTrip PrometheusValue
+			if err := json.Unmarshal(data, &roundTrip); err != nil {
+				t.Fatalf("unable to decode %s: %v", data, err)
+			}
+			if roundTrip != *tt.initial {
+				t.Errorf("round trip through %s produced %#v", data, roundTrip)
+			}
+		})
+	}
+}
+
+func TestPrometheusValue_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(PrometheusMetric{Value: PrometheusValue{Timestamp: 1705320000, Value: "3600"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"metric":{},"value":[1705320000,"3600"]}`; string(data) != expect {
+		t.Errorf("expected %s, got %s", expect, data)
+	}
+	// values that are not numbers are still encoded as JSON strings
+	data, err = json.Marshal(PrometheusValue{Timestamp: 1705320000, Value: "\x7f\xff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Errorf("expected valid JSON, got %s: %v", data, err)
+	}
+}
+
+func TestPrometheusValue_Float64(t *testing.T) {
+	tests := []struct {
+		value               string
+		nan, posInf, negInf bool
+		expect              float64
+		wantErr             bool
+	}{
+		{value: "1.5", expect: 1.5},
+		{value: "NaN", nan: true, expect: math.NaN()},
+		{value: "+Inf", posInf: true, expect: math.Inf(1)},
+		{value: "-Inf", negInf: true, expect: math.Inf(-1)},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			v := PrometheusValue{Timestamp: 1, Value: tt.value}
+			f, err := v.Float64()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Float64() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && f != tt.expect && !(math.IsNaN(f) && math.IsNaN(tt.expect)) {
+				t.Errorf("Float64() = %v, expected %v", f, tt.expect)
+			}
+			if v.IsNaN() != tt.nan || v.IsPosInf() != tt.posInf || v.IsNegInf() != tt.negInf {
+				t.Errorf("unexpected IsNaN=%t IsPosInf=%t IsNegInf=%t", v.IsNaN(), v.IsPosInf(), v.IsNegInf())
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_Versions(t *testing.T) {
+	versions := make([]string, 0, 12)
+	for i := 0; i < 12; i++ {
+		versions = append(versions, fmt.Sprintf(`"component-%02d":"1.%d"`, i, i))
+	}
+	opts := DefaultOptions()
+	opts.Client = NewFakeGCSClient()
+	obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true,"metadata":{"versions":{`+strings.Join(versions, ",")+`}}}`)
+	var count int
+	for k := range obj.attrs.Metadata {
+		if strings.HasPrefix(k, "version-") {
+			count++
+		}
+	}
+	if count != 10 {
+		t.Errorf("expected 10 version attributes, got %d: %v", count, obj.attrs.Metadata)
+	}
+	if v := obj.attrs.Metadata["version-component-00"]; v != "1.0" {
+		t.Errorf("unexpected version-component-00 %q", v)
+	}
+	if _, ok := obj.attrs.Metadata["version-component-11"]; ok {
+		t.Error("expected version-component-11 to be omitted")
+	}
+}
+
+const testMatrixMetrics = `{"cluster:cpu:usage":{"status":"success","data":{"resultType":"matrix","result":[
+	{"metric":{"node":"a"},"values":[[1705316400,"1"],[1705317300,"2"],[1705318200,"3"],[1705319100,"4"],[1705320000,"5"]]},
+	{"metric":{"node":"b"},"values":[[1705316300,"1"],[1705317300,"2"],[1705318200,"3"],[1705319100,"4"],[1705319900,"5"]]},
+	{"metric":{"node":"c"},"values":[[1705316500,"1"],[1705317300,"2"],[1705318200,"3"],[1705319100,"4"],[1705320100,"5"]]}
+]}}}`
+
+func TestPrometheusResult_TimeRange(t *testing.T) {
+	var metrics map[string]PrometheusResult
+	if err := json.Unmarshal([]byte(testMatrixMetrics), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	start, end, ok := metrics["cluster:cpu:usage"].TimeRange()
+	if !ok || start.Unix() != 1705316300 || end.Unix() != 1705320100 {
+		t.Errorf("TimeRange() = %v, %v, %t", start, end, ok)
+	}
+
+	var vector map[string]PrometheusResult
+	if err := json.Unmarshal([]byte(testJobMetrics), &vector); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := vector["job:duration:total:seconds"].TimeRange(); ok {
+		t.Error("expected vector results to have no time range")
+	}
+	if _, _, ok := (PrometheusResult{Data: PrometheusData{ResultType: "matrix"}}).TimeRange(); ok {
+		t.Error("expected empty matrix results to have no time range")
+	}
+}
+
+func TestPrometheusResult_FirstValue(t *testing.T) {
+	one := PrometheusMetric{Value: PrometheusValue{Timestamp: 1, Value: "1"}}
+	two := PrometheusMetric{Value: PrometheusValue{Timestamp: 2, Value: "2"}}
+	for _, tc := range []struct {
+		name   string
+		result PrometheusResult
+		ok     bool
+	}{
+		{name: "no results", result: PrometheusResult{Status: "success"}},
+		{name: "one result", result: PrometheusResult{Status: "success", Data: PrometheusData{Result: []PrometheusMetric{one}}}, ok: true},
+		{name: "two results", result: PrometheusResult{Status: "success", Data: PrometheusData{Result: []PrometheusMetric{one, two}}}},
+		{name: "failed", result: PrometheusResult{Status: "error", Data: PrometheusData{Result: []PrometheusMetric{one}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			value, ok := tc.result.FirstValue()
+			if ok != tc.ok {
+				t.Fatalf("expected ok=%t, got %t", tc.ok, ok)
+			}
+			if ok && value != one.Value {
+				t.Errorf("expected %v, got %v", one.Value, value)
+			}
+			value, err := tc.result.SingletonValue()
+			if (err == nil) != tc.ok {
+				t.Fatalf("expected ok=%t, got error %v", tc.ok, err)
+			}
+			if err == nil && value != one.Value {
+				t.Errorf("expected %v, got %v", one.Value, value)
+			}
 		})
 	}
 }
+
+func TestIndexJobsWithOptions_MetricTimeRange(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(testJobMetrics+"\n"+testMatrixMetrics), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000")
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	if obj.attrs.Metadata["metric-start"] != "1705316300" || obj.attrs.Metadata["metric-end"] != "1705320100" {
+		t.Errorf("unexpected metadata: %v", obj.attrs.Metadata)
+	}
+}
+
+func TestStreamMetrics(t *testing.T) {
+	const metrics = 10000
+	var buf strings.Builder
+	buf.WriteString("{")
+	for i := 0; i < metrics; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `"metric_%d":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"%d"]}]}}`, i, i)
+	}
+	buf.WriteString("}\n")
+	buf.WriteString(`{"metric_0":{"status":"success","data":{"resultType":"vector","result":[]}}}`)
+
+	var names []string
+	err := streamMetrics(json.NewDecoder(strings.NewReader(buf.String())), func(name string, result PrometheusResult) error {
+		names = append(names, name)
+		if value, ok := result.FirstValue(); ok && name != fmt.Sprintf("metric_%s", value.Value) {
+			t.Errorf("unexpected value %s for %s", value.Value, name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != metrics+1 || names[0] != "metric_0" || names[metrics-1] != fmt.Sprintf("metric_%d", metrics-1) || names[metrics] != "metric_0" {
+		t.Errorf("unexpected %d metrics, first %s and last %s", len(names), names[0], names[len(names)-1])
+	}
+
+	for _, invalid := range []string{`[]`, `{"a":{"status":1}}`, `{"a":{}`, `{"a":{}}{`} {
+		if err := streamMetrics(json.NewDecoder(strings.NewReader(invalid)), func(string, PrometheusResult) error { return nil }); err == nil {
+			t.Errorf("expected an error decoding %s", invalid)
+		}
+	}
+	stop := fmt.Errorf("stop")
+	if err := streamMetrics(json.NewDecoder(strings.NewReader(buf.String())), func(string, PrometheusResult) error { return stop }); err != stop {
+		t.Errorf("expected the handler error, got %v", err)
+	}
+}
+
+func TestIndexJobsWithOptions_LargeMetrics(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	const metrics = 5000
+	var buf strings.Builder
+	buf.WriteString(testJobMetrics)
+	for i := 0; i < metrics; i++ {
+		fmt.Fprintf(&buf, `{"large_%d":{"status":"success","data":{"resultType":"vector","result":[{"metric":{"node":"n%d"},"value":[1705320000,"%d"]}]}}}`, i, i, i)
+		buf.WriteString("\n")
+	}
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(buf.String()), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000")
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	var index JobMetricsIndex
+	if err := json.Unmarshal(obj.data, &index); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, metrics / 2, metrics - 1} {
+		metric, ok := index.GetWithLabels(fmt.Sprintf("large_%d", i), map[string]string{"node": fmt.Sprintf("n%d", i)})
+		if !ok || metric.Value != strconv.Itoa(i) {
+			t.Errorf("unexpected metric large_%d: %v %t", i, metric, ok)
+		}
+	}
+}
+
+func TestIndexJobsWithOptions_MaxOutputMetrics(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	// the required metric is indexed even though it comes after the limit
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(`{"cluster:nodes":{"status":"success","data":{"resultType":"vector","result":[{"metric":{"role":"master"},"value":[1705320000,"3"]},{"metric":{"role":"worker"},"value":[1705320000,"6"]}]}},
+		"job:duration:total:seconds":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"3600"]}]}}}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.MaxOutputMetrics = 1
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	var metrics JobMetricsIndex
+	if err := json.Unmarshal(client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000").data, &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metrics[DefaultRequiredMetric]; !ok || len(metrics) != 2 {
+		t.Errorf("expected one metric and the required metric, got %v", metrics)
+	}
+}
+
+func TestIndexJobsWithOptions_MatrixLatest(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(testJobMetrics+"\n"+testMatrixMetrics), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000")
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	var index JobMetricsIndex
+	if err := json.Unmarshal(obj.data, &index); err != nil {
+		t.Fatal(err)
+	}
+	for node, timestamp := range map[string]int64{"a": 1705320000, "b": 1705319900, "c": 1705320100} {
+		metric, ok := index.GetWithLabels("cluster:cpu:usage", map[string]string{"node": node})
+		if !ok || metric.Value != "5" || metric.Timestamp != timestamp {
+			t.Errorf("unexpected latest sample for node %s: %#v", node, metric)
+		}
+		if metric.MetricName != "cluster:cpu:usage" || !reflect.DeepEqual(metric.Labels, map[string]string{"node": node}) {
+			t.Errorf("unexpected name and labels for node %s: %#v", node, metric)
+		}
+	}
+	if metric, _ := index.Get("job:duration:total:seconds"); metric.MetricName != "job:duration:total:seconds" || metric.Labels != nil {
+		t.Errorf("unexpected name and labels of an unlabeled metric: %#v", metric)
+	}
+}
+
+// testMatrixJobMetrics reports the required metric from a range query.
+const testMatrixJobMetrics = `{"job:duration:total:seconds":{"status":"success","data":{"resultType":"matrix","result":[
+	{"metric":{},"values":[[1705316400,"0"],[1705318200,"1800"],[1705320000,"3600"]]}
+]}}}`
+
+func TestIndexJobsWithOptions_MatrixRequiredMetric(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testMetricsName, []byte(testMatrixJobMetrics), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testMetricsName}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", testMetricsPath)
+	if obj == nil {
+		t.Fatal("expected the entry to be keyed by the latest sample")
+	}
+	var index JobMetricsIndex
+	if err := json.Unmarshal(obj.data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if metric := index["job:duration:total:seconds"]; metric.Value != "3600" || metric.Timestamp != 1705320000 {
+		t.Errorf("unexpected metric %#v", metric)
+	}
+}
+
+func TestPrometheusValue_IsStale(t *testing.T) {
+	finished := time.Unix(1705320000, 0)
+	tests := []struct {
+		name   string
+		value  PrometheusValue
+		expect bool
+	}{
+		{name: "at finish", value: PrometheusValue{Timestamp: 1705320000}},
+		{name: "after finish", value: PrometheusValue{Timestamp: 1705323600}},
+		{name: "within max age", value: PrometheusValue{Timestamp: 1705320000 - 3600}},
+		{name: "exactly max age", value: PrometheusValue{Timestamp: 1705320000 - 7200}},
+		{name: "older than max age", value: PrometheusValue{Timestamp: 1705320000 - 7201}, expect: true},
+		{name: "zero", value: PrometheusValue{}, expect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.value.IsStale(finished, 2*time.Hour); got != tt.expect {
+				t.Errorf("IsStale() = %t, want %t", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_StaleMetrics(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	const metrics = `{"fresh":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705316400,"1"]}]}},` +
+		`"stale":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705305600,"2"]}]}}}`
+	for _, threshold := range []time.Duration{DefaultStaleMetricThreshold, 0} {
+		client := NewFakeGCSClient()
+		client.put("bucket", name, []byte(testJobMetrics+"\n"+metrics), nil)
+		opts := DefaultOptions()
+		opts.Client = client
+		opts.StaleMetricThreshold = threshold
+		stats := &Stats{}
+		if err := IndexJobsWithOptions(WithStats(context.TODO(), stats), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+			t.Fatal(err)
+		}
+		obj := client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000")
+		if obj == nil {
+			t.Fatal("expected index entry")
+		}
+		var index JobMetricsIndex
+		if err := json.Unmarshal(obj.data, &index); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := index.Get("fresh"); !ok {
+			t.Errorf("threshold %s: expected fresh metric to be indexed", threshold)
+		}
+		_, ok := index.Get("stale")
+		if threshold == 0 {
+			if !ok || len(stats.StaleMetrics()) != 0 {
+				t.Errorf("expected every metric to be indexed without a threshold: %v", stats.StaleMetrics())
+			}
+			continue
+		}
+		if ok {
+			t.Error("expected stale metric not to be indexed")
+		}
+		if reports := stats.StaleMetrics(); !reflect.DeepEqual(reports, []StaleMetricReport{{MetricName: "stale", MetricAge: 4 * time.Hour}}) {
+			t.Errorf("unexpected stale metric reports %v", reports)
+		}
+	}
+}
+
+func TestPrometheusValue_Compare(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   PrometheusValue
+		expect int
+	}{
+		{name: "earlier", a: PrometheusValue{Timestamp: 1, Value: "9"}, b: PrometheusValue{Timestamp: 2, Value: "1"}, expect: -1},
+		{name: "later", a: PrometheusValue{Timestamp: 2, Value: "1"}, b: PrometheusValue{Timestamp: 1, Value: "9"}, expect: 1},
+		{name: "equal", a: PrometheusValue{Timestamp: 1, Value: "1"}, b: PrometheusValue{Timestamp: 1, Value: "1"}, expect: 0},
+		{name: "same time lower value", a: PrometheusValue{Timestamp: 1, Value: "1"}, b: PrometheusValue{Timestamp: 1, Value: "2"}, expect: -1},
+		{name: "same time higher value", a: PrometheusValue{Timestamp: 1, Value: "2"}, b: PrometheusValue{Timestamp: 1, Value: "1"}, expect: 1},
+		{name: "values compare lexicographically", a: PrometheusValue{Timestamp: 1, Value: "10"}, b: PrometheusValue{Timestamp: 1, Value: "9"}, expect: -1},
+		{name: "zero", a: PrometheusValue{}, b: PrometheusValue{Timestamp: 1}, expect: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Compare(tt.b); got != tt.expect {
+				t.Errorf("Compare() = %d, want %d", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestPrometheusValueSlice(t *testing.T) {
+	values := PrometheusValueSlice{
+		{Timestamp: 3, Value: "a"},
+		{Timestamp: 1, Value: "b"},
+		{Timestamp: 3, Value: "b"},
+		{Timestamp: 2, Value: "a"},
+	}
+	if latest := values.Latest(); latest != (PrometheusValue{Timestamp: 3, Value: "b"}) {
+		t.Errorf("unexpected latest %#v", latest)
+	}
+	sort.Sort(values)
+	expect := PrometheusValueSlice{
+		{Timestamp: 1, Value: "b"},
+		{Timestamp: 2, Value: "a"},
+		{Timestamp: 3, Value: "a"},
+		{Timestamp: 3, Value: "b"},
+	}
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("unexpected order %v", values)
+	}
+	if latest := PrometheusValueSlice(nil).Latest(); latest != (PrometheusValue{}) {
+		t.Errorf("expected the zero value, got %#v", latest)
+	}
+}
+
+func TestIndexJobsWithOptions_ArtifactSize(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip=%t", skip), func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", "logs/job/1000/build-log.txt", make([]byte, 1000), nil)
+			client.put("bucket", "logs/job/1000/artifacts/junit.xml", make([]byte, 200), nil)
+			client.put("bucket", "logs/job/10000/build-log.txt", make([]byte, 5000), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			opts.SkipArtifactSizeCalculation = skip
+			obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true}`)
+			var result JobResult
+			if err := json.Unmarshal(obj.data, &result); err != nil {
+				t.Fatal(err)
+			}
+			expect, expectAttr := int64(1238), "1238"
+			if skip {
+				expect, expectAttr = 0, ""
+			}
+			if result.ArtifactSize != expect || obj.attrs.Metadata["artifact-size-bytes"] != expectAttr {
+				t.Errorf("unexpected artifact size %d %q", result.ArtifactSize, obj.attrs.Metadata["artifact-size-bytes"])
+			}
+		})
+	}
+}
+
+// countingClient counts the number of List calls made.
+type countingClient struct {
+	*FakeGCSClient
+	lists int
+}
+
+func (c *countingClient) List(ctx context.Context, bucket string, q *storage.Query) ([]*storage.ObjectAttrs, error) {
+	c.lists++
+	return c.FakeGCSClient.List(ctx, bucket, q)
+}
+
+func TestGCSEvent_ParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		expect  int64
+		wantErr bool
+	}{
+		{size: "0", expect: 0},
+		{size: "1024", expect: 1024},
+		{size: "", wantErr: true},
+		{size: "1k", wantErr: true},
+		{size: "-1", wantErr: true},
+		{size: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		e := GCSEvent{Size: tt.size}
+		size, err := e.ParseSize()
+		if (err != nil) != tt.wantErr || size != tt.expect {
+			t.Errorf("ParseSize() for %q = %d, %v", tt.size, size, err)
+		}
+		expect := tt.expect
+		if tt.wantErr {
+			expect = -1
+		}
+		if e.ParsedSize() != expect {
+			t.Errorf("ParsedSize() for %q = %d", tt.size, e.ParsedSize())
+		}
+	}
+}
+
+func TestGCSEvent_Name(t *testing.T) {
+	tests := []struct {
+		bucket, name             string
+		uri                      string
+		finishedJSON, jobMetrics bool
+	}{
+		{uri: "gs:///"},
+		{bucket: "bucket", name: "finished.json", uri: "gs://bucket/finished.json", finishedJSON: true},
+		{bucket: "bucket", name: testFinishedName, uri: "gs://bucket/" + testFinishedName, finishedJSON: true},
+		{bucket: "bucket", name: testMetricsName, uri: "gs://bucket/" + testMetricsName, jobMetrics: true},
+		{bucket: "bucket", name: "logs/job/1000/artifacts/finished.json.gz", uri: "gs://bucket/logs/job/1000/artifacts/finished.json.gz"},
+		{bucket: "bucket", name: "logs/job/1000/job_metrics.json/build-log.txt", uri: "gs://bucket/logs/job/1000/job_metrics.json/build-log.txt"},
+	}
+	for _, tt := range tests {
+		e := GCSEvent{Bucket: tt.bucket, Name: tt.name}
+		if uri := e.GSURI(); uri != tt.uri {
+			t.Errorf("GSURI() = %s, want %s", uri, tt.uri)
+		}
+		if e.IsFinishedJSON() != tt.finishedJSON || e.IsJobMetrics() != tt.jobMetrics {
+			t.Errorf("%q: IsFinishedJSON() = %t, IsJobMetrics() = %t", tt.name, e.IsFinishedJSON(), e.IsJobMetrics())
+		}
+	}
+}
+
+func TestGCSEvent_ParseMetadata(t *testing.T) {
+	e := GCSEvent{Metadata: map[string]interface{}{
+		"repo":        "openshift/origin",
+		"pull":        "12345",
+		"ratio":       "0.5",
+		"passed":      "true",
+		"retried":     "false",
+		"native":      true,
+		"count":       float64(3),
+		"not-a-float": "NaN",
+	}}
+	m, err := e.ParseMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Metadata{
+		"repo":        "openshift/origin",
+		"pull":        float64(12345),
+		"ratio":       0.5,
+		"passed":      true,
+		"retried":     false,
+		"native":      true,
+		"count":       float64(3),
+		"not-a-float": "NaN",
+	}
+	if !reflect.DeepEqual(m, expect) {
+		t.Errorf("unexpected metadata %#v", m)
+	}
+
+	e.Metadata["nested"] = map[string]interface{}{"a": "b"}
+	m, err = e.ParseMetadata()
+	if err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("expected an error naming the invalid value, got %v", err)
+	}
+	if !reflect.DeepEqual(m, expect) {
+		t.Errorf("expected the valid values to be returned, got %#v", m)
+	}
+
+	if v, ok := e.MetadataString("repo"); !ok || v != "openshift/origin" {
+		t.Errorf("unexpected repo %q %t", v, ok)
+	}
+	if v, ok := e.MetadataString("native"); ok || v != "" {
+		t.Errorf("expected a boolean not to be returned as a string, got %q", v)
+	}
+	if _, ok := e.MetadataString("missing"); ok {
+		t.Error("expected a missing key not to be returned")
+	}
+}
+
+func TestGCSEvent_RelatedObjects(t *testing.T) {
+	client := &countingClient{FakeGCSClient: NewFakeGCSClient()}
+	for _, name := range []string{
+		testFinishedName,
+		"logs/job/1000/started.json",
+		"logs/job/1000/prowjob.json",
+		"logs/job/1000/build-log.txt",
+		"logs/job/1000/artifacts/junit.xml",
+		"logs/job/10000/finished.json",
+		"logs/job/999/finished.json",
+	} {
+		client.put("bucket", name, nil, nil)
+	}
+	e := GCSEvent{Bucket: "bucket", Name: testFinishedName}
+	expect := []string{"artifacts/junit.xml", "build-log.txt", "finished.json", "prowjob.json", "started.json"}
+	for i := 0; i < 2; i++ {
+		names, err := e.RelatedObjects(context.TODO(), client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, expect) {
+			t.Errorf("unexpected related objects %v", names)
+		}
+	}
+	if client.lists != 1 {
+		t.Errorf("expected a single listing, got %d", client.lists)
+	}
+}
+
+func TestIndexJobsWithOptions_InfraCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		finished string
+		expect   string
+	}{
+		{
+			name:     "with infra commit",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"infra-commit":"0123abcd"}}`,
+			expect:   "0123abcd",
+		},
+		{
+			name:     "without infra commit",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"repo":"openshift/installer"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Client = NewFakeGCSClient()
+			obj := indexTestJobState(t, opts, tt.finished)
+			commit, ok := obj.attrs.Metadata["infra-commit"]
+			if commit != tt.expect || ok != (tt.expect != "") {
+				t.Errorf("unexpected infra-commit %q in %v", commit, obj.attrs.Metadata)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_FeatureFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		finished string
+		flags    map[string]bool
+		count    string
+	}{
+		{
+			name:     "mixed flags",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"feature-flags":{"TechPreviewNoUpgrade":true,"GatewayAPI":false,"NodeSwap":true,"invalid":"yes"}}}`,
+			flags:    map[string]bool{"TechPreviewNoUpgrade": true, "GatewayAPI": false, "NodeSwap": true},
+			count:    "2",
+		},
+		{
+			name:     "disabled flags",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"feature-flags":{"GatewayAPI":false}}}`,
+			flags:    map[string]bool{"GatewayAPI": false},
+			count:    "0",
+		},
+		{
+			name:     "without flags",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"repo":"openshift/installer"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Client = NewFakeGCSClient()
+			obj := indexTestJobState(t, opts, tt.finished)
+			count, ok := obj.attrs.Metadata["feature-flag-count"]
+			if count != tt.count || ok != (tt.count != "") {
+				t.Errorf("unexpected feature-flag-count %q in %v", count, obj.attrs.Metadata)
+			}
+			var result JobResult
+			if err := json.Unmarshal(obj.data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.FeatureFlags, tt.flags) {
+				t.Errorf("unexpected feature flags %v", result.FeatureFlags)
+			}
+			if flags := jobResultFromAttrs(&obj.attrs).FeatureFlags; !reflect.DeepEqual(flags, tt.flags) {
+				t.Errorf("unexpected feature flags %v from metadata", flags)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_FinishedYAML(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/finished.yaml", []byte("timestamp: 1705320000\npassed: false\nmetadata:\n  infra-commit: 0123abcd\n"), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/finished.yaml"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", testStatePath)
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	if obj.attrs.Metadata["state"] != "failed" || obj.attrs.Metadata["infra-commit"] != "0123abcd" {
+		t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+	}
+}
+
+func TestIndexJobsWithOptions_OpenShiftVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		finished string
+		metadata map[string]interface{}
+		expect   string
+	}{
+		{
+			name:     "job version",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"job-version":"4.15.3","openshift-tests-version":"4.15.0"}}`,
+			expect:   "4.15.3",
+		},
+		{
+			name:     "openshift-tests version",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"openshift-tests-version":"4.15.0"}}`,
+			expect:   "4.15.0",
+		},
+		{
+			name:     "no version",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{"repo":"openshift/origin"}}`,
+		},
+		{
+			name:     "numeric object metadata",
+			finished: `{"timestamp":1705320000,"passed":true}`,
+			metadata: map[string]interface{}{"job-version": "4.15"},
+			expect:   "4.15",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(tt.finished), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName, Metadata: tt.metadata}, opts); err != nil {
+				t.Fatal(err)
+			}
+			obj := client.get("bucket", testStatePath)
+			if obj == nil {
+				t.Fatal("expected index entry")
+			}
+			version, ok := obj.attrs.Metadata["openshift-version"]
+			if version != tt.expect || ok != (tt.expect != "") {
+				t.Errorf("unexpected openshift-version %q in %v", version, obj.attrs.Metadata)
+			}
+			var result JobResult
+			if err := json.Unmarshal(obj.data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.OpenShiftVersion != tt.expect {
+				t.Errorf("unexpected result version %q", result.OpenShiftVersion)
+			}
+		})
+	}
+}
+
+func TestPrometheusLabels_String(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels PrometheusLabels
+		expect string
+	}{
+		{name: "nil", expect: "{}"},
+		{name: "empty", labels: PrometheusLabels{}, expect: "{}"},
+		{name: "single", labels: PrometheusLabels{"node": "a"}, expect: `{node="a"}`},
+		{name: "sorted", labels: PrometheusLabels{"pod": "etcd-0", "namespace": "openshift-etcd", "container": "etcd"}, expect: `{container="etcd",namespace="openshift-etcd",pod="etcd-0"}`},
+		{name: "escaped", labels: PrometheusLabels{"message": `say "hi"\n`}, expect: `{message="say \"hi\"\\n"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s := tt.labels.String(); s != tt.expect {
+				t.Errorf("String() = %s, want %s", s, tt.expect)
+			}
+			if s := fmt.Sprintf("%v", tt.labels); s != tt.expect {
+				t.Errorf("%%v = %s, want %s", s, tt.expect)
+			}
+		})
+	}
+}
+
+func TestPrometheusLabels_Subset(t *testing.T) {
+	labels := PrometheusLabels{"namespace": "openshift-etcd", "pod": "etcd-0", "container": "etcd"}
+	subset := labels.Subset([]string{"pod", "namespace", "missing"})
+	if !reflect.DeepEqual(subset, PrometheusLabels{"namespace": "openshift-etcd", "pod": "etcd-0"}) {
+		t.Errorf("unexpected subset %v", subset)
+	}
+	subset["pod"] = "changed"
+	if labels["pod"] != "etcd-0" {
+		t.Error("expected the subset to be a copy")
+	}
+	if subset := PrometheusLabels(nil).Subset([]string{"pod"}); subset == nil || len(subset) != 0 {
+		t.Errorf("unexpected subset of nil labels %#v", subset)
+	}
+}
+
+func TestPrometheusLabels_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		initial PrometheusLabels
+		expect  PrometheusLabels
+		wantErr bool
+	}{
+		{data: `null`, initial: PrometheusLabels{"a": "b"}, expect: PrometheusLabels{"a": "b"}},
+		{data: `{}`, initial: PrometheusLabels{"a": "b"}, expect: PrometheusLabels{}},
+		{data: `{"c":"d"}`, expect: PrometheusLabels{"c": "d"}},
+		{data: `{"c":1}`, wantErr: true},
+		{data: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			l := tt.initial
+			if err := l.UnmarshalJSON([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(l, tt.expect) {
+				t.Errorf("unexpected labels %#v", l)
+			}
+		})
+	}
+	var nilLabels *PrometheusLabels
+	for _, data := range []string{`null`, `{}`} {
+		if err := nilLabels.UnmarshalJSON([]byte(data)); err != nil {
+			t.Errorf("unexpected error decoding %s into nil labels: %v", data, err)
+		}
+	}
+	if err := nilLabels.UnmarshalJSON([]byte(`{"a":"b"}`)); err == nil {
+		t.Error("expected an error decoding into nil labels")
+	}
+}
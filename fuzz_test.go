@@ -0,0 +1,70 @@
+package cisearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func FuzzPrometheusValueUnmarshalJSON(f *testing.F) {
+	for _, tt := range prometheusValueUnmarshalJSONTests() {
+		f.Add(tt.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v PrometheusValue
+		if err := v.UnmarshalJSON(data); err != nil {
+			return
+		}
+		// null leaves the value unset
+		if v == (PrometheusValue{}) {
+			return
+		}
+		if v.Timestamp < 0 {
+			t.Fatalf("accepted %q with negative timestamp %d", data, v.Timestamp)
+		}
+		if _, err := strconv.ParseFloat(v.Value, 64); err != nil {
+			t.Fatalf("accepted %q with invalid value %q: %v", data, v.Value, err)
+		}
+		out, err := v.MarshalJSON()
+		if err != nil {
+			t.Fatalf("accepted %q but could not marshal %#v: %v", data, v, err)
+		}
+		var roundTrip PrometheusValue
+		if err := roundTrip.UnmarshalJSON(out); err != nil {
+			t.Fatalf("accepted %q but not its encoding %s: %v", data, out, err)
+		}
+		if roundTrip != v {
+			t.Fatalf("round trip of %q through %s produced %#v, want %#v", data, out, roundTrip, v)
+		}
+	})
+}
+
+func FuzzPrometheusLabelsUnmarshalJSON(f *testing.F) {
+	for _, seed := range []string{
+		`null`, `{}`, `{"a":"b"}`, `{"namespace":"openshift-etcd","pod":"etcd-0"}`, `{"quote\"d":"new\nline"}`,
+		`{"a":1}`, `[]`, ` null`, `{ }`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// decoding into a nil receiver must fail rather than panic
+		var nilLabels *PrometheusLabels
+		_ = nilLabels.UnmarshalJSON(data)
+
+		l := PrometheusLabels{"existing": "value"}
+		if err := l.UnmarshalJSON(data); err != nil {
+			return
+		}
+		if string(data) == "null" && !reflect.DeepEqual(l, PrometheusLabels{"existing": "value"}) {
+			t.Fatalf("decoding null changed the labels to %#v", l)
+		}
+		out, err := l.MarshalJSON()
+		if err != nil {
+			t.Fatalf("accepted %q but could not marshal %v: %v", data, l, err)
+		}
+		if !json.Valid(out) {
+			t.Fatalf("accepted %q but marshaled to invalid JSON %q", data, out)
+		}
+	})
+}
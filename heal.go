@@ -0,0 +1,92 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+)
+
+// HealReport counts the job-state index entries checked by HealIndex and
+// what was done to each.
+type HealReport struct {
+	Checked  int      `json:"checked"`
+	Healthy  int      `json:"healthy"`
+	Repaired int      `json:"repaired"`
+	Removed  int      `json:"removed"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// HealIndex checks each job-state index entry under the date prefix against
+// the finished.json of the build it links to. Entries whose build no longer
+// exists or has not finished are removed, and entries whose state or
+// completion time differ from the build are indexed again. Entries that
+// cannot be checked or fixed are reported in HealReport.Errors. With
+// Options.DryRun, the report describes the changes without making them. The
+// entries are read from the layout selected by Options.IndexVersion and
+// Options.LegacyIndexPath.
+func HealIndex(ctx context.Context, client StorageClient, bucket, date string, opts ...func(*Options)) (*HealReport, error) {
+	o := DefaultOptions()
+	for _, fn := range opts {
+		fn(&o)
+	}
+	entries, err := listJobStates(ctx, client, bucket, o.layout(), date)
+	if err != nil {
+		return nil, err
+	}
+	report := &HealReport{}
+	for _, entry := range entries {
+		report.Checked++
+		name := entry.Attrs.Name
+		e, err := finishedEventFromLink(entry.Attrs.Metadata["link"])
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		data, err := readObject(ctx, client, e.Bucket, e.Name)
+		if err == nil {
+			data, err = decodeContent(data)
+		}
+		var finished Finished
+		if err == nil {
+			finished, err = parseFinished(e.Name, data)
+		}
+		switch {
+		case err == storage.ErrObjectNotExist:
+		case err != nil:
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: unable to read %s: %v", name, e.Name, err))
+			continue
+		default:
+			finishedAt, ok := finished.Time()
+			if !ok {
+				break
+			}
+			if entry.Attrs.Metadata["state"] == finished.State() && entry.Attrs.Metadata["completed"] == strconv.FormatInt(finishedAt.Unix(), 10) {
+				report.Healthy++
+				continue
+			}
+			if !o.DryRun {
+				if err := ReprocessFailedIndexEntry(ctx, client, bucket, name, func(r *Options) { *r = o }); err != nil {
+					report.Errors = append(report.Errors, err.Error())
+					continue
+				}
+				log.Printf("Repaired %s from %s", name, e.GSURI())
+			}
+			report.Repaired++
+			continue
+		}
+
+		// the build was deleted or has not finished
+		if !o.DryRun {
+			if err := client.Delete(ctx, bucket, name); err != nil && err != storage.ErrObjectNotExist {
+				report.Errors = append(report.Errors, fmt.Sprintf("unable to delete %s: %v", name, err))
+				continue
+			}
+			log.Printf("Removed %s, %s is missing or unfinished", name, e.GSURI())
+		}
+		report.Removed++
+	}
+	return report, nil
+}
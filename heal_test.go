@@ -0,0 +1,81 @@
+package cisearch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// seedHealIndex indexes four builds and then makes the second stale, deletes
+// the third and breaks the link of the fourth.
+func seedHealIndex(t *testing.T) *FakeGCSClient {
+	client := NewFakeGCSClient()
+	opts := DefaultOptions()
+	opts.Client = client
+	for _, build := range []string{"1", "2", "3", "4"} {
+		name := "logs/job/" + build + "/finished.json"
+		client.put("bucket", name, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.put("bucket", "logs/job/2/finished.json", []byte(`{"timestamp":1705320000,"passed":false}`), nil)
+	if err := client.Delete(context.TODO(), "bucket", "logs/job/3/finished.json"); err != nil {
+		t.Fatal(err)
+	}
+	client.put("bucket", "index/job-state/2024-01-15T12:00:00Z/job/4", nil, map[string]string{"state": "success"})
+	return client
+}
+
+func TestHealIndex(t *testing.T) {
+	client := seedHealIndex(t)
+	report, err := HealIndex(context.TODO(), client, "bucket", "2024-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || report.Healthy != 1 || report.Repaired != 1 || report.Removed != 1 || len(report.Errors) != 1 {
+		t.Errorf("unexpected report %#v", report)
+	}
+	if obj := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/job/2"); obj == nil || obj.attrs.Metadata["state"] != "failed" {
+		t.Errorf("expected the stale entry to be repaired, got %v", obj)
+	}
+	if obj := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/job/3"); obj != nil {
+		t.Error("expected the entry of the missing build to be removed")
+	}
+	if obj := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/job/1"); obj == nil {
+		t.Error("expected the healthy entry to be kept")
+	}
+
+	report, err = HealIndex(context.TODO(), client, "bucket", "2024-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 || report.Healthy != 2 || report.Repaired != 0 || report.Removed != 0 {
+		t.Errorf("expected the index to be healed, got %#v", report)
+	}
+}
+
+func TestHealIndex_DryRun(t *testing.T) {
+	client := seedHealIndex(t)
+	report, err := HealIndex(context.TODO(), client, "bucket", "2024-01-15", func(o *Options) { o.DryRun = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || report.Healthy != 1 || report.Repaired != 1 || report.Removed != 1 {
+		t.Errorf("unexpected report %#v", report)
+	}
+	if obj := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/job/2"); obj == nil || obj.attrs.Metadata["state"] != "success" {
+		t.Errorf("expected the stale entry to be unchanged, got %v", obj)
+	}
+	if obj := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/job/3"); obj == nil {
+		t.Error("expected the entry of the missing build to be kept")
+	}
+
+	empty, err := HealIndex(context.TODO(), client, "bucket", "2024-01-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(empty, &HealReport{}) {
+		t.Errorf("expected an empty report, got %#v", empty)
+	}
+}
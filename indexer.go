@@ -0,0 +1,84 @@
+package cisearch
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// Indexer indexes events with a single GCS client that is reused across
+// events, rather than created for each as when Options.Client is nil.
+type Indexer struct {
+	// Config controls how events are indexed. If Config.Client is nil, a GCS
+	// client is created when the first event is handled.
+	Config Options
+
+	once   sync.Once
+	opts   Options
+	client *storage.Client
+	err    error
+	// configErr is reported by every event when the configuration read by
+	// newIndexerFromEnv is invalid.
+	configErr error
+}
+
+// NewIndexer returns an Indexer for cfg.
+func NewIndexer(cfg Options) *Indexer {
+	return &Indexer{Config: cfg}
+}
+
+// defaultIndexer is used by IndexJobs, IndexJobState and IndexMetrics.
+var defaultIndexer = newIndexerFromEnv()
+
+// newIndexerFromEnv returns an Indexer configured from the environment by
+// IndexerConfigFromEnv, as the Cloud Run service is. The functions cannot
+// refuse to start, so an invalid configuration fails every event instead.
+func newIndexerFromEnv() *Indexer {
+	config, err := IndexerConfigFromEnv(DefaultOptions())
+	if err == nil {
+		err = config.Validate()
+	}
+	i := NewIndexer(config.Options)
+	i.configErr = err
+	return i
+}
+
+// options returns the configured options with the client of the indexer,
+// creating a GCS client on the first call if none is configured. An error
+// creating the client is returned by every call.
+func (i *Indexer) options(ctx context.Context) (Options, error) {
+	i.once.Do(func() {
+		i.opts = i.Config
+		if i.err = i.configErr; i.err != nil {
+			return
+		}
+		if i.opts.Client != nil {
+			return
+		}
+		// the client outlives the event that created it
+		i.client, i.err = storage.NewClient(context.WithoutCancel(ctx), i.opts.clientOptions()...)
+		if i.err == nil {
+			i.opts.Client = NewStorageClient(i.client)
+		}
+	})
+	return i.opts, i.err
+}
+
+// HandleEvent indexes e as IndexJobs does.
+func (i *Indexer) HandleEvent(ctx context.Context, e GCSEvent) error {
+	opts, err := i.options(ctx)
+	if err != nil {
+		return err
+	}
+	return ignoreDuplicate(e, IndexJobsWithOptions(ctx, e, opts))
+}
+
+// Close closes the GCS client created by the indexer, if any. The indexer
+// must not be used afterwards.
+func (i *Indexer) Close() error {
+	if i.client == nil {
+		return nil
+	}
+	return i.client.Close()
+}
@@ -0,0 +1,59 @@
+package cisearch
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIndexer_HandleEvent(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	indexer := NewIndexer(opts)
+	defer indexer.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := indexer.HandleEvent(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	if client.get("bucket", testStatePath) == nil {
+		t.Error("expected index entry")
+	}
+	if indexer.client != nil {
+		t.Error("expected the configured client to be used")
+	}
+	if err := indexer.HandleEvent(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1001/finished.json"}); err == nil {
+		t.Error("expected an error for a missing object")
+	}
+}
+
+func TestIndexer_Close(t *testing.T) {
+	if err := NewIndexer(DefaultOptions()).Close(); err != nil {
+		t.Errorf("unexpected error closing an unused indexer: %v", err)
+	}
+}
+
+func TestNewIndexerFromEnv(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	t.Setenv("CI_SEARCH_REQUIRED_METRIC", "cluster:nodes")
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(`{"cluster:nodes":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"6"]}]}}}`), nil)
+	indexer := newIndexerFromEnv()
+	indexer.Config.Client = client
+	if err := indexer.HandleEvent(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000") == nil {
+		t.Error("expected the metrics to be indexed under the configured required metric")
+	}
+
+	t.Setenv("CI_SEARCH_VERBOSE", "loud")
+	indexer = newIndexerFromEnv()
+	indexer.Config.Client = client
+	if err := indexer.HandleEvent(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}); err == nil || !strings.Contains(err.Error(), "CI_SEARCH_VERBOSE") {
+		t.Errorf("expected the invalid configuration to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package cisearch
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	indexingDelaySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ci_search_indexing_delay_seconds",
+		Help:    "Time between a job completing and its job-state index entry being written.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	})
+	staleMetricsSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ci_search_stale_metrics_skipped_total",
+		Help: "Job metrics that were not indexed because they were sampled too long before the job finished.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(indexingDelaySeconds, staleMetricsSkipped)
+}
@@ -0,0 +1,87 @@
+package cisearch
+
+import (
+	"regexp"
+	"strings"
+)
+
+// jobBranchPattern matches the names of jobs generated by ci-operator, which
+// are <type>-ci-<org>-<repo>-<branch>[-<variant>]-<test>. Organizations have
+// no dashes while repositories may, so the branch is the first component after
+// the organization that looks like a branch name.
+var jobBranchPattern = regexp.MustCompile(`^(?:periodic|pull|branch)-ci-[^-]+-.+?-(master|main|release-\d+\.\d+|openshift-\d+\.\d+|\d+\.\d+)-`)
+
+// ExtractBranch returns the branch tested by the named job, or an empty
+// string if the name does not follow the ci-operator naming scheme.
+func ExtractBranch(jobName string) string {
+	if m := jobBranchPattern.FindStringSubmatch(jobName); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// jobTypes are the values returned by JobType.
+var jobTypes = []string{"periodic", "presubmit", "postsubmit", "unknown"}
+
+// JobType returns whether the named job is a "periodic", "presubmit" or
+// "postsubmit" job based on the prefix conventions of the job names, or
+// "unknown" if the name follows none of them.
+func JobType(jobName string) string {
+	switch {
+	case strings.HasPrefix(jobName, "periodic-"), strings.HasPrefix(jobName, "release-openshift-"):
+		return "periodic"
+	case strings.HasPrefix(jobName, "pull-"):
+		return "presubmit"
+	case strings.HasPrefix(jobName, "branch-"):
+		return "postsubmit"
+	default:
+		return "unknown"
+	}
+}
+
+// jobPlatforms are the tokens of job names that name the platform tested.
+var jobPlatforms = map[string]bool{
+	"aws": true, "gcp": true, "azure": true, "vsphere": true, "metal": true,
+	"openstack": true, "ovirt": true, "libvirt": true, "ibmcloud": true,
+	"nutanix": true, "alibaba": true, "powervs": true,
+}
+
+// ExtractVariant returns the variant of the named job, which is the part of
+// the name after the last platform token, such as "ovn" for e2e-aws-ovn or
+// "ovn-serial" for e2e-gcp-ovn-serial. An empty string is returned if the
+// name has no platform or nothing follows it.
+func ExtractVariant(jobName string) string {
+	tokens := strings.Split(jobName, "-")
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if jobPlatforms[tokens[i]] {
+			return strings.Join(tokens[i+1:], "-")
+		}
+	}
+	return ""
+}
+
+// jobVariants are the tokens of job names that describe how a test is
+// configured rather than what kind of test it is.
+var jobVariants = map[string]bool{
+	"ovn": true, "sdn": true, "serial": true, "parallel": true, "realtime": true,
+	"techpreview": true, "fips": true, "ipv6": true, "dualstack": true,
+	"arm64": true, "multiarch": true, "ipi": true, "upi": true, "proxy": true,
+	"single": true, "node": true, "compact": true,
+}
+
+// ClassifyJobKind returns the kind of test run by the named job, such as
+// "e2e", "upgrade" or "unit". It is the last token of the name once variant
+// tokens are skipped, unless that token is a platform, in which case it is
+// the token preceding the platform: e2e-aws-ovn is an "e2e" job while
+// e2e-aws-ovn-upgrade is an "upgrade" job.
+func ClassifyJobKind(jobName string) string {
+	tokens := strings.Split(jobName, "-")
+	i := len(tokens) - 1
+	for i > 0 && jobVariants[tokens[i]] {
+		i--
+	}
+	for i > 0 && jobPlatforms[tokens[i]] {
+		i--
+	}
+	return tokens[i]
+}
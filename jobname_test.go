@@ -0,0 +1,127 @@
+package cisearch
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExtractBranch(t *testing.T) {
+	tests := map[string]string{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn":    "master",
+		"pull-ci-openshift-origin-master-unit":                             "master",
+		"pull-ci-openshift-installer-main-e2e-vsphere":                     "main",
+		"pull-ci-openshift-cluster-network-operator-main-e2e-gcp":          "main",
+		"periodic-ci-openshift-origin-4.15-e2e-aws":                        "4.15",
+		"branch-ci-openshift-origin-release-4.16-images":                   "release-4.16",
+		"pull-ci-openshift-release-release-4.16-e2e-aws":                   "release-4.16",
+		"periodic-ci-openshift-multiarch-openshift-4.14-e2e-aws-ovn-arm64": "openshift-4.14",
+		"release-openshift-origin-installer-e2e-aws-upgrade":               "",
+		"release-openshift-ocp-installer-e2e-aws-4.15":                     "",
+		"periodic-ci-openshift-release-feature-branch-e2e":                 "",
+		"some-other-job": "",
+		"":               "",
+	}
+	for job, expect := range tests {
+		if branch := ExtractBranch(job); branch != expect {
+			t.Errorf("ExtractBranch(%q) = %q, want %q", job, branch, expect)
+		}
+	}
+}
+
+func TestJobType(t *testing.T) {
+	tests := map[string]string{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn": "periodic",
+		"release-openshift-origin-installer-e2e-aws-upgrade":            "periodic",
+		"pull-ci-openshift-origin-master-unit":                          "presubmit",
+		"branch-ci-openshift-origin-release-4.16-images":                "postsubmit",
+		"promote-release-openshift-machine-os-content-e2e-aws-4.15":     "unknown",
+		"": "unknown",
+	}
+	for job, expect := range tests {
+		if jobType := JobType(job); jobType != expect {
+			t.Errorf("JobType(%q) = %q, want %q", job, jobType, expect)
+		}
+	}
+}
+
+func TestIndexJobsWithOptions_JobTypeIndex(t *testing.T) {
+	job := "pull-ci-openshift-origin-master-unit"
+	for _, legacy := range []bool{true, false} {
+		client := NewFakeGCSClient()
+		client.put("bucket", "logs/"+job+"/1000/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+		opts := DefaultOptions()
+		opts.Client = client
+		opts.LegacyIndexPath = legacy
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/" + job + "/1000/finished.json"}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if client.get("bucket", "index/presubmit/job-state/2024-01-15T12:00:00Z/"+job+"/1000") == nil {
+			t.Errorf("legacy=%t: expected an entry in the presubmit index", legacy)
+		}
+		if exists := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/"+job+"/1000") != nil; exists != legacy {
+			t.Errorf("legacy=%t: unexpected legacy index entry: %t", legacy, exists)
+		}
+	}
+}
+
+func TestExtractVariant(t *testing.T) {
+	tests := map[string]string{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn":        "ovn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-gcp-sdn":        "sdn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn-serial": "ovn-serial",
+		"pull-ci-openshift-origin-master-e2e-gcp-serial":                       "serial",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-gcp-realtime":   "realtime",
+		"release-openshift-origin-installer-e2e-aws-upgrade":                   "upgrade",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-azure":          "",
+		"pull-ci-openshift-origin-master-unit":                                 "",
+		"":                                                                     "",
+	}
+	for job, expect := range tests {
+		if variant := ExtractVariant(job); variant != expect {
+			t.Errorf("ExtractVariant(%q) = %q, want %q", job, variant, expect)
+		}
+	}
+}
+
+func TestClassifyJobKind(t *testing.T) {
+	tests := map[string]string{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn":                             "e2e",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn-serial":                      "e2e",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-metal-ipi-ovn-ipv6":                  "e2e",
+		"periodic-ci-openshift-release-master-ci-4.15-upgrade-from-stable-4.14-e2e-aws-ovn-upgrade": "upgrade",
+		"release-openshift-origin-installer-e2e-aws-upgrade":                                        "upgrade",
+		"pull-ci-openshift-origin-master-unit":                                                      "unit",
+		"pull-ci-openshift-origin-master-verify":                                                    "verify",
+		"branch-ci-openshift-origin-release-4.16-images":                                            "images",
+		"pull-ci-openshift-installer-master-e2e-vsphere":                                            "e2e",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn-single-node":                 "e2e",
+		"periodic-ci-openshift-cluster-etcd-operator-master-e2e-aws-cert-rotation":                  "rotation",
+		"aws": "aws",
+	}
+	for job, expect := range tests {
+		if kind := ClassifyJobKind(job); kind != expect {
+			t.Errorf("ClassifyJobKind(%q) = %q, want %q", job, kind, expect)
+		}
+	}
+}
+
+func TestIndexJobsWithOptions_JobKindFilter(t *testing.T) {
+	client := NewFakeGCSClient()
+	jobs := map[string]bool{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn":         true,
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn-upgrade": true,
+		"pull-ci-openshift-origin-master-unit":                                  false,
+	}
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.JobKindFilter = []string{"e2e", "upgr"}
+	for job, indexed := range jobs {
+		client.put("bucket", "logs/"+job+"/1000/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/" + job + "/1000/finished.json"}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if exists := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/"+job+"/1000") != nil; exists != indexed {
+			t.Errorf("%s: expected indexed=%t", job, indexed)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// TestSuiteResult summarizes a JUnit test suite.
+type TestSuiteResult struct {
+	Name    string           `json:"name"`
+	Total   int              `json:"total"`
+	Failed  int              `json:"failed"`
+	Skipped int              `json:"skipped"`
+	Cases   []TestCaseResult `json:"cases,omitempty"`
+}
+
+// TestCaseResult is the outcome of a single run of a JUnit test case.
+type TestCaseResult struct {
+	Name    string `json:"name"`
+	Failed  bool   `json:"failed,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+}
+
+type junitSuite struct {
+	XMLName xml.Name     `xml:""`
+	Name    string       `xml:"name,attr"`
+	Suites  []junitSuite `xml:"testsuite"`
+	Cases   []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name    string    `xml:"name,attr"`
+	Failure *struct{} `xml:"failure"`
+	Error   *struct{} `xml:"error"`
+	Skipped *struct{} `xml:"skipped"`
+}
+
+// ParseJUnit returns the test suites in a JUnit XML document whose root is
+// either a testsuites or a testsuite element. Nested suites are flattened.
+func ParseJUnit(data []byte) ([]TestSuiteResult, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var suites []TestSuiteResult
+	var flatten func(s junitSuite)
+	flatten = func(s junitSuite) {
+		if s.XMLName.Local != "testsuites" || len(s.Cases) > 0 {
+			suite := TestSuiteResult{Name: s.Name}
+			for _, c := range s.Cases {
+				result := TestCaseResult{
+					Name:    c.Name,
+					Failed:  c.Failure != nil || c.Error != nil,
+					Skipped: c.Skipped != nil,
+				}
+				suite.Total++
+				switch {
+				case result.Failed:
+					suite.Failed++
+				case result.Skipped:
+					suite.Skipped++
+				}
+				suite.Cases = append(suite.Cases, result)
+			}
+			suites = append(suites, suite)
+		}
+		for _, child := range s.Suites {
+			flatten(child)
+		}
+	}
+	flatten(root)
+	return suites, nil
+}
+
+// FlakeReport describes a test that both passed and failed within a single
+// build, typically because the test framework retried the failure.
+type FlakeReport struct {
+	TestName  string `json:"test"`
+	SuiteName string `json:"suite"`
+	PassCount int    `json:"passes"`
+	FailCount int    `json:"failures"`
+}
+
+// DetectIntraRunFlakes returns the tests of each suite that have both
+// passing and failing runs, ordered by suite and test name.
+func DetectIntraRunFlakes(suites []TestSuiteResult) []FlakeReport {
+	type key struct{ suite, test string }
+	counts := make(map[key]*FlakeReport)
+	for _, suite := range suites {
+		for _, c := range suite.Cases {
+			if c.Skipped && !c.Failed {
+				continue
+			}
+			k := key{suite.Name, c.Name}
+			report, ok := counts[k]
+			if !ok {
+				report = &FlakeReport{TestName: c.Name, SuiteName: suite.Name}
+				counts[k] = report
+			}
+			if c.Failed {
+				report.FailCount++
+			} else {
+				report.PassCount++
+			}
+		}
+	}
+	var flakes []FlakeReport
+	for _, report := range counts {
+		if report.PassCount > 0 && report.FailCount > 0 {
+			flakes = append(flakes, *report)
+		}
+	}
+	sort.Slice(flakes, func(i, j int) bool {
+		if flakes[i].SuiteName != flakes[j].SuiteName {
+			return flakes[i].SuiteName < flakes[j].SuiteName
+		}
+		return flakes[i].TestName < flakes[j].TestName
+	})
+	return flakes
+}
+
+// TestSuiteResultsByName returns the first suite with the given name or nil
+// if there is none.
+func TestSuiteResultsByName(suites []TestSuiteResult, name string) *TestSuiteResult {
+	for i := range suites {
+		if suites[i].Name == name {
+			return &suites[i]
+		}
+	}
+	return nil
+}
+
+// GroupSuitesByStatus groups suites as "empty" if they ran no tests,
+// "failing" if any test failed and otherwise "passing".
+func GroupSuitesByStatus(suites []TestSuiteResult) map[string][]TestSuiteResult {
+	groups := make(map[string][]TestSuiteResult)
+	for _, suite := range suites {
+		status := "passing"
+		switch {
+		case suite.Total == 0:
+			status = "empty"
+		case suite.Failed > 0:
+			status = "failing"
+		}
+		groups[status] = append(groups[status], suite)
+	}
+	return groups
+}
+
+// SuiteFailureRate returns the fraction of all tests in suites that failed,
+// or zero if there were no tests.
+func SuiteFailureRate(suites []TestSuiteResult) float64 {
+	var failed, total int
+	for _, suite := range suites {
+		failed += suite.Failed
+		total += suite.Total
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
+
+// failedTests returns the names of up to max tests that failed without also
+// passing in the same suite, in the order they were reported.
+func failedTests(suites []TestSuiteResult, max int) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, suite := range suites {
+		passed := make(map[string]bool)
+		for _, c := range suite.Cases {
+			if !c.Failed && !c.Skipped {
+				passed[c.Name] = true
+			}
+		}
+		for _, c := range suite.Cases {
+			if len(names) >= max {
+				return names
+			}
+			if !c.Failed || passed[c.Name] || seen[c.Name] {
+				continue
+			}
+			seen[c.Name] = true
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// readJUnitResults parses every junit*.xml artifact under the build
+// directory dir.
+func readJUnitResults(ctx context.Context, client StorageClient, bucket, dir string) ([]TestSuiteResult, error) {
+	objects, err := client.List(ctx, bucket, &storage.Query{Prefix: path.Join(dir, "artifacts") + "/"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list artifacts of %s: %v", dir, err)
+	}
+	var suites []TestSuiteResult
+	for _, attrs := range objects {
+		base := path.Base(attrs.Name)
+		if !strings.HasPrefix(base, "junit") || path.Ext(base) != ".xml" {
+			continue
+		}
+		data, err := readObject(ctx, client, bucket, attrs.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", attrs.Name, err)
+		}
+		parsed, err := ParseJUnit(data)
+		if err != nil {
+			log.Printf("warn: Ignored invalid JUnit file %s: %v", attrs.Name, err)
+			continue
+		}
+		suites = append(suites, parsed...)
+	}
+	return suites, nil
+}
+
+// IndexJobsFlakeReport detects the tests that flaked within the completed
+// build logs/<job>/<build> and, if there are any, writes the report to
+// index/flakes/RFC3339_DATE_OF_COMPLETION/JOB_NAME/BUILD_NUMBER in the
+// layout of Options.IndexVersion.
+func IndexJobsFlakeReport(ctx context.Context, client StorageClient, bucket, job, build string, opts ...func(*Options)) ([]FlakeReport, error) {
+	o := DefaultOptions()
+	for _, fn := range opts {
+		fn(&o)
+	}
+	dir := path.Join("logs", job, build)
+	data, err := readObject(ctx, client, bucket, path.Join(dir, "finished.json"))
+	if err == nil {
+		data, err = decodeContent(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read finished.json of %s: %v", dir, err)
+	}
+	var finished Finished
+	if err := json.Unmarshal(data, &finished); err != nil {
+		return nil, fmt.Errorf("unable to decode finished.json of %s: %v", dir, err)
+	}
+	finishedAt, ok := finished.Time()
+	if !ok {
+		return nil, fmt.Errorf("build %s has not finished", dir)
+	}
+
+	suites, err := readJUnitResults(ctx, client, bucket, dir)
+	if err != nil {
+		return nil, err
+	}
+	flakes := DetectIntraRunFlakes(suites)
+	if len(flakes) == 0 {
+		return nil, nil
+	}
+
+	if data, err = json.Marshal(flakes); err != nil {
+		return nil, fmt.Errorf("could not serialize flake report: %v", err)
+	}
+	key := finishedAt.Format(time.RFC3339)
+	indexPath := path.Join(indexPrefix(o.IndexVersion, "flakes"), key, job, build)
+	if err := o.indexWriter(client, bucket).Write(ctx, indexPath, data, map[string]string{
+		"link":        fmt.Sprintf("gs://%s/%s", bucket, dir),
+		"flake-count": strconv.Itoa(len(flakes)),
+	}, nil); err != nil {
+		return nil, fmt.Errorf("failed to write flake report %s: %v", indexPath, err)
+	}
+	return flakes, nil
+}
@@ -0,0 +1,230 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testJUnit = `<testsuites>
+  <testsuite name="openshift-tests" tests="6" failures="2">
+    <testcase name="[sig-network] pods should communicate"/>
+    <testcase name="[sig-storage] volumes should mount">
+      <failure message="timed out">timed out waiting for mount</failure>
+    </testcase>
+    <testcase name="[sig-storage] volumes should mount"/>
+    <testcase name="[sig-cli] oc should fail">
+      <failure message="exit 1">exit 1</failure>
+    </testcase>
+    <testcase name="[sig-apps] skipped test">
+      <skipped message="not supported"/>
+    </testcase>
+    <testcase name="[sig-apps] skipped test"/>
+  </testsuite>
+  <testsuite name="cluster upgrade">
+    <testcase name="[sig-storage] volumes should mount"/>
+  </testsuite>
+</testsuites>`
+
+func TestParseJUnit(t *testing.T) {
+	suites, err := ParseJUnit([]byte(testJUnit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != 2 {
+		t.Fatalf("expected 2 suites, got %#v", suites)
+	}
+	if s := suites[0]; s.Name != "openshift-tests" || s.Total != 6 || s.Failed != 2 || s.Skipped != 1 {
+		t.Errorf("unexpected suite %#v", s)
+	}
+
+	single, err := ParseJUnit([]byte(`<testsuite name="unit"><testcase name="a"/><testcase name="b"><error/></testcase></testsuite>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(single) != 1 || single[0].Name != "unit" || single[0].Total != 2 || single[0].Failed != 1 {
+		t.Errorf("unexpected suites %#v", single)
+	}
+
+	if _, err := ParseJUnit([]byte(`<testsuite`)); err == nil {
+		t.Error("expected invalid XML to fail")
+	}
+}
+
+func TestDetectIntraRunFlakes(t *testing.T) {
+	suites, err := ParseJUnit([]byte(testJUnit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []FlakeReport{
+		{TestName: "[sig-storage] volumes should mount", SuiteName: "openshift-tests", PassCount: 1, FailCount: 1},
+	}
+	if flakes := DetectIntraRunFlakes(suites); !reflect.DeepEqual(flakes, expect) {
+		t.Errorf("unexpected flakes %#v", flakes)
+	}
+	if flakes := DetectIntraRunFlakes(nil); len(flakes) != 0 {
+		t.Errorf("unexpected flakes %#v", flakes)
+	}
+}
+
+func TestIndexJobsFlakeReport(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":false}`), nil)
+	client.put("bucket", "logs/job/1000/artifacts/e2e/junit/junit_e2e.xml", []byte(testJUnit), nil)
+	client.put("bucket", "logs/job/1000/artifacts/e2e/junit/junit_broken.xml", []byte(`not xml`), nil)
+
+	flakes, err := IndexJobsFlakeReport(context.TODO(), client, "bucket", "job", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flakes) != 1 {
+		t.Fatalf("expected a single flake, got %#v", flakes)
+	}
+	obj := client.get("bucket", "index/flakes/2024-01-15T12:00:00Z/job/1000")
+	if obj == nil {
+		t.Fatal("expected flake report to be written")
+	}
+	var written []FlakeReport
+	if err := json.Unmarshal(obj.data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, flakes) || obj.attrs.Metadata["flake-count"] != "1" || obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+		t.Errorf("unexpected report %s %v", obj.data, obj.attrs.Metadata)
+	}
+
+	client.put("bucket", "logs/job/1001/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	if flakes, err := IndexJobsFlakeReport(context.TODO(), client, "bucket", "job", "1001"); err != nil || len(flakes) != 0 {
+		t.Errorf("expected no flakes, got %v %v", flakes, err)
+	}
+	if client.get("bucket", "index/flakes/2024-01-15T12:00:00Z/job/1001") != nil {
+		t.Error("expected no report for a build without flakes")
+	}
+
+	// compressed finished.json in the v2 layout
+	client.put("bucket", "logs/job/1002/finished.json", gzipData(t, `{"timestamp":1705320000,"passed":false}`), nil)
+	client.put("bucket", "logs/job/1002/artifacts/e2e/junit/junit_e2e.xml", []byte(testJUnit), nil)
+	if flakes, err := IndexJobsFlakeReport(context.TODO(), client, "bucket", "job", "1002", func(o *Options) { o.IndexVersion = "v2" }); err != nil || len(flakes) != 1 {
+		t.Fatalf("expected a single flake, got %v %v", flakes, err)
+	}
+	if client.get("bucket", "index/v2/flakes/2024-01-15T12:00:00Z/job/1002") == nil || client.get("bucket", "index/flakes/2024-01-15T12:00:00Z/job/1002") != nil {
+		t.Error("expected the flake report to be written in the v2 layout")
+	}
+}
+
+func TestIndexJobsWithOptions_TopFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		finished string
+		expect   []string
+	}{
+		{
+			name:     "failed build",
+			finished: `{"timestamp":1705320000,"passed":false}`,
+			expect:   []string{"[sig-cli] oc should fail"},
+		},
+		{
+			name:     "successful build",
+			finished: `{"timestamp":1705320000,"passed":true}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", "logs/job/1000/artifacts/e2e/junit/junit_e2e.xml", []byte(testJUnit), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			obj := indexTestJobState(t, opts, tt.finished)
+			if result := jobResultFromAttrs(&obj.attrs); !reflect.DeepEqual(result.TopFailures, tt.expect) {
+				t.Errorf("unexpected top failures %v in %v", result.TopFailures, obj.attrs.Metadata)
+			}
+			var result JobResult
+			if err := json.Unmarshal(obj.data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.TopFailures, tt.expect) {
+				t.Errorf("unexpected top failures %v in %s", result.TopFailures, obj.data)
+			}
+		})
+	}
+}
+
+func TestFailedTests(t *testing.T) {
+	suites := []TestSuiteResult{
+		{Name: "a", Cases: []TestCaseResult{
+			{Name: "flaky", Failed: true}, {Name: "flaky"},
+			{Name: "one", Failed: true}, {Name: "one", Failed: true},
+			{Name: "two", Failed: true},
+			{Name: "skipped", Skipped: true},
+		}},
+		{Name: "b", Cases: []TestCaseResult{
+			{Name: "one", Failed: true},
+			{Name: "three", Failed: true},
+			{Name: "four", Failed: true},
+		}},
+	}
+	if names := failedTests(suites, 5); !reflect.DeepEqual(names, []string{"one", "two", "three", "four"}) {
+		t.Errorf("unexpected failed tests %v", names)
+	}
+	if names := failedTests(suites, 2); !reflect.DeepEqual(names, []string{"one", "two"}) {
+		t.Errorf("unexpected limited failed tests %v", names)
+	}
+	if names := failedTests(suites, 0); names != nil {
+		t.Errorf("expected no failed tests, got %v", names)
+	}
+}
+
+var testSuiteResults = []TestSuiteResult{
+	{Name: "openshift-tests", Total: 10, Failed: 2},
+	{Name: "cluster upgrade", Total: 4},
+	{Name: "conformance", Total: 5, Failed: 1, Skipped: 1},
+	{Name: "disruption"},
+	{Name: "openshift-tests", Total: 3},
+	{Name: "monitor", Total: 1, Skipped: 1},
+}
+
+func TestTestSuiteResultsByName(t *testing.T) {
+	suite := TestSuiteResultsByName(testSuiteResults, "openshift-tests")
+	if suite == nil || suite != &testSuiteResults[0] {
+		t.Errorf("expected the first matching suite, got %v", suite)
+	}
+	if suite := TestSuiteResultsByName(testSuiteResults, "disruption"); suite == nil || suite.Name != "disruption" {
+		t.Errorf("expected the disruption suite, got %v", suite)
+	}
+	if suite := TestSuiteResultsByName(testSuiteResults, "missing"); suite != nil {
+		t.Errorf("expected no suite, got %v", suite)
+	}
+	if suite := TestSuiteResultsByName(nil, "openshift-tests"); suite != nil {
+		t.Errorf("expected no suite, got %v", suite)
+	}
+}
+
+func TestGroupSuitesByStatus(t *testing.T) {
+	groups := GroupSuitesByStatus(testSuiteResults)
+	names := make(map[string][]string)
+	for status, suites := range groups {
+		for _, suite := range suites {
+			names[status] = append(names[status], suite.Name)
+		}
+	}
+	expect := map[string][]string{
+		"passing": {"cluster upgrade", "openshift-tests", "monitor"},
+		"failing": {"openshift-tests", "conformance"},
+		"empty":   {"disruption"},
+	}
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("unexpected groups %v", names)
+	}
+}
+
+func TestSuiteFailureRate(t *testing.T) {
+	if rate := SuiteFailureRate(testSuiteResults); rate != 3.0/23 {
+		t.Errorf("expected failure rate %v, got %v", 3.0/23, rate)
+	}
+	if rate := SuiteFailureRate(testSuiteResults[3:4]); rate != 0 {
+		t.Errorf("expected no failures in empty suites, got %v", rate)
+	}
+	if rate := SuiteFailureRate(nil); rate != 0 {
+		t.Errorf("expected no failures without suites, got %v", rate)
+	}
+}
@@ -0,0 +1,148 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KubernetesEventList is the subset of a Kubernetes EventList recorded in
+// e2e-events.json that is indexed.
+type KubernetesEventList struct {
+	Items []KubernetesEvent `json:"items"`
+}
+
+// KubernetesEvent is the subset of a Kubernetes Event that is indexed.
+type KubernetesEvent struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+	Type    string `json:"type"`
+}
+
+// KubernetesEventSummary counts the events of a build by reason and type.
+// Events that were deduplicated by Kubernetes are counted once per
+// occurrence.
+type KubernetesEventSummary struct {
+	Reasons map[string]int `json:"reasons"`
+	Types   map[string]int `json:"types"`
+}
+
+// SummarizeKubernetesEvents counts the events in list by reason and type.
+func SummarizeKubernetesEvents(list KubernetesEventList) KubernetesEventSummary {
+	summary := KubernetesEventSummary{
+		Reasons: make(map[string]int),
+		Types:   make(map[string]int),
+	}
+	for _, event := range list.Items {
+		count := event.Count
+		if count < 1 {
+			count = 1
+		}
+		summary.Reasons[event.Reason] += count
+		summary.Types[event.Type] += count
+	}
+	return summary
+}
+
+// KubernetesEventsHandler summarizes the e2e-events.json collected by a
+// build into index/k8s-events/RFC3339_DATE_OF_UPLOAD/JOB_NAME/BUILD_NUMBER.
+// The warning-count and error-count metadata attributes hold the number of
+// events of those types. Events without an upload time, and files larger than
+// Options.MaxMetricsFileSizeBytes, are skipped.
+func KubernetesEventsHandler(opts Options) HandlerFunc {
+	return func(ctx context.Context, e GCSEvent) error {
+		parts := strings.Split(e.Name, "/")
+		if len(parts) < 4 || parts[0] != "logs" {
+			skipEvent(ctx, "not a build artifact")
+			return nil
+		}
+		job := parts[1]
+		if !opts.includesJob(job) {
+			skipEvent(ctx, "job excluded by the job kind filter")
+			return nil
+		}
+		build, err := ParseBuildID(parts[2])
+		if err != nil {
+			log.Printf("warn: Skipped %s: %v", e.Name, err)
+			skipEvent(ctx, err.Error())
+			return nil
+		}
+
+		// the key must be the same when the event is redelivered
+		if e.TimeCreated.IsZero() {
+			skipEvent(ctx, "no upload time")
+			return nil
+		}
+		if size, err := e.ParseSize(); err == nil && opts.MaxMetricsFileSizeBytes > 0 && size > opts.MaxMetricsFileSizeBytes {
+			log.Printf("warn: Skipped events %s of %d bytes, larger than the limit of %d bytes", e.Name, size, opts.MaxMetricsFileSizeBytes)
+			skipEvent(ctx, fmt.Sprintf("events of %d bytes exceed the limit of %d bytes", size, opts.MaxMetricsFileSizeBytes))
+			return nil
+		}
+
+		client, err := opts.storageClient(ctx)
+		if err != nil {
+			return err
+		}
+		var r io.ReadCloser
+		err = retryGCS(ctx, gcsRetryAttempts, func() (err error) {
+			r, err = client.NewReader(ctx, e.Bucket, e.Name)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		in, err := decompressReader(r)
+		if err != nil {
+			return fmt.Errorf("unable to decode %s: %v", e.Name, err)
+		}
+		defer in.Close()
+		limited := &limitedReader{r: in, n: math.MaxInt64 - 1}
+		if opts.MaxMetricsFileSizeBytes > 0 {
+			limited.n = opts.MaxMetricsFileSizeBytes
+		}
+		var list KubernetesEventList
+		err = json.NewDecoder(limited).Decode(&list)
+		if limited.exceeded {
+			log.Printf("warn: Skipped events %s, larger than the limit of %d bytes once decoded", e.Name, opts.MaxMetricsFileSizeBytes)
+			skipEvent(ctx, fmt.Sprintf("decoded events exceed the limit of %d bytes", opts.MaxMetricsFileSizeBytes))
+			return nil
+		}
+		if err != nil {
+			log.Printf("warn: Skipped invalid events %s: %v", e.Name, err)
+			skipEvent(ctx, "invalid events: "+err.Error())
+			return nil
+		}
+		summary := SummarizeKubernetesEvents(list)
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return fmt.Errorf("could not serialize event summary: %v", err)
+		}
+
+		key := e.TimeCreated.UTC().Format(time.RFC3339)
+		u := fmt.Sprintf("gs://%s/%s", e.Bucket, path.Join(parts[:3]...))
+		indexPath := path.Join(indexPrefix(opts.IndexVersion, "k8s-events"), key, job, string(build))
+		metadata := map[string]string{
+			"link":          u,
+			"warning-count": strconv.Itoa(summary.Types["Warning"]),
+			"error-count":   strconv.Itoa(summary.Types["Error"]),
+		}
+		writer := opts.indexWriter(client, e.Bucket)
+		err = retryGCS(ctx, gcsRetryAttempts, func() error {
+			return writer.Write(ctx, indexPath, data, metadata, opts.writeConditions())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write events %s to %s: %w", indexPath, u, wrapPreconditionFailure(err))
+		}
+		log.Printf("Indexed %d events %s to gs://%s/%s", len(list.Items), e.Name, e.Bucket, indexPath)
+		return nil
+	}
+}
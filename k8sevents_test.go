@@ -0,0 +1,80 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexJobsWithOptions_KubernetesEvents(t *testing.T) {
+	const name = "logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/1000/artifacts/e2e-aws-ovn/gather-extra/artifacts/e2e-events.json"
+	data, err := ioutil.ReadFile("testdata/e2e-events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewFakeGCSClient()
+	client.put("bucket", name, data, nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	e := GCSEvent{Bucket: "bucket", Name: name, TimeCreated: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)}
+	if err := IndexJobsWithOptions(context.TODO(), e, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", "index/k8s-events/2024-01-15T12:00:00Z/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/1000")
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	if obj.attrs.Metadata["warning-count"] != "6" || obj.attrs.Metadata["error-count"] != "1" {
+		t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+	}
+	if link := obj.attrs.Metadata["link"]; link != "gs://bucket/logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/1000" {
+		t.Errorf("unexpected link %s", link)
+	}
+	var summary KubernetesEventSummary
+	if err := json.Unmarshal(obj.data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	expect := KubernetesEventSummary{
+		Reasons: map[string]int{"Started": 2, "ProbeError": 4, "BackOff": 2, "FailedCreate": 1},
+		Types:   map[string]int{"Normal": 2, "Warning": 6, "Error": 1},
+	}
+	if !reflect.DeepEqual(summary, expect) {
+		t.Errorf("unexpected summary %#v", summary)
+	}
+
+	if err := IndexJobsWithOptions(context.TODO(), e, opts); !IsPreconditionFailure(err) {
+		t.Errorf("expected reindexing to fail the precondition, got %v", err)
+	}
+}
+
+func TestIndexJobsWithOptions_KubernetesEventsInvalid(t *testing.T) {
+	created := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/artifacts/e2e-events.json", []byte(`not json`), nil)
+	client.put("bucket", "logs/job/1001/artifacts/e2e-events.json", []byte(`{"items":[]}`), nil)
+	client.put("bucket", "logs/job/1002/artifacts/e2e-events.json", gzipData(t, `{"items":[{"reason":"`+strings.Repeat("x", 2048)+`"}]}`), nil)
+	client.put("bucket", "pr-logs/pull/1/job/1000/artifacts/e2e-events.json", []byte(`{"items":[]}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.MaxMetricsFileSizeBytes = 1024
+	for _, e := range []GCSEvent{
+		{Bucket: "bucket", Name: "logs/job/1000/artifacts/e2e-events.json", TimeCreated: created},
+		// the index key would change on redelivery
+		{Bucket: "bucket", Name: "logs/job/1001/artifacts/e2e-events.json"},
+		// larger than the limit as reported or once decoded
+		{Bucket: "bucket", Name: "logs/job/1001/artifacts/e2e-events.json", TimeCreated: created, Size: "2048"},
+		{Bucket: "bucket", Name: "logs/job/1002/artifacts/e2e-events.json", TimeCreated: created},
+		{Bucket: "bucket", Name: "pr-logs/pull/1/job/1000/artifacts/e2e-events.json", TimeCreated: created},
+	} {
+		if err := IndexJobsWithOptions(context.TODO(), e, opts); err != nil {
+			t.Errorf("%s: %v", e.Name, err)
+		}
+	}
+	if objects, _ := client.List(context.TODO(), "bucket", nil); len(objects) != 4 {
+		t.Errorf("expected nothing to be indexed, found %d objects", len(objects))
+	}
+}
@@ -0,0 +1,87 @@
+package cisearch
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MetadataDiff describes how the metadata of one build differs from another.
+// Keys of nested metadata are joined to their parent key with a ".".
+type MetadataDiff struct {
+	Added   map[string]interface{}
+	Removed map[string]interface{}
+	Changed map[string]MetadataChange
+}
+
+// MetadataChange holds the values of a key that differs between two builds.
+type MetadataChange struct {
+	Before interface{}
+	After  interface{}
+}
+
+// Diff returns the keys that are added, removed or changed in other relative
+// to m. Nested metadata is compared key by key.
+func (m Metadata) Diff(other Metadata) MetadataDiff {
+	d := MetadataDiff{
+		Added:   make(map[string]interface{}),
+		Removed: make(map[string]interface{}),
+		Changed: make(map[string]MetadataChange),
+	}
+	d.diff("", m, other)
+	return d
+}
+
+func (d MetadataDiff) diff(prefix string, before, after Metadata) {
+	for k, b := range before {
+		a, ok := after[k]
+		if !ok {
+			d.Removed[prefix+k] = b
+			continue
+		}
+		if bm, ok := before.Meta(k); ok && bm != nil {
+			if am, ok := after.Meta(k); ok && am != nil {
+				d.diff(prefix+k+".", *bm, *am)
+				continue
+			}
+		}
+		if !reflect.DeepEqual(a, b) {
+			d.Changed[prefix+k] = MetadataChange{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			d.Added[prefix+k] = a
+		}
+	}
+}
+
+// IsEmpty returns true if there are no differences.
+func (d MetadataDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String describes one difference per line, ordered by key. Added keys are
+// prefixed with "+", removed keys with "-" and changed keys with "~".
+func (d MetadataDiff) String() string {
+	type line struct {
+		key, text string
+	}
+	lines := make([]line, 0, len(d.Added)+len(d.Removed)+len(d.Changed))
+	for k, v := range d.Added {
+		lines = append(lines, line{k, fmt.Sprintf("+ %s: %v", k, v)})
+	}
+	for k, v := range d.Removed {
+		lines = append(lines, line{k, fmt.Sprintf("- %s: %v", k, v)})
+	}
+	for k, c := range d.Changed {
+		lines = append(lines, line{k, fmt.Sprintf("~ %s: %v -> %v", k, c.Before, c.After)})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].key < lines[j].key })
+	texts := make([]string, len(lines))
+	for i, l := range lines {
+		texts[i] = l.text
+	}
+	return strings.Join(texts, "\n")
+}
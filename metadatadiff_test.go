@@ -0,0 +1,69 @@
+package cisearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMetadata_Diff(t *testing.T) {
+	parse := func(s string) Metadata {
+		var m Metadata
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	passing := parse(`{
+		"job-version": "4.15.2",
+		"infra-commit": "aaaa",
+		"pod": "release-4.15",
+		"shards": 3,
+		"versions": {"installer": "4.15.2", "kubernetes": "1.28.6", "machine-os": "415.92"},
+		"links": {"a": "b"}
+	}`)
+	failing := parse(`{
+		"job-version": "4.15.3",
+		"infra-commit": "aaaa",
+		"shards": 3,
+		"work-namespace": "ci-op-1",
+		"versions": {"installer": "4.15.3", "kubernetes": "1.28.6", "ovn": "23.09"},
+		"links": "none"
+	}`)
+
+	d := passing.Diff(failing)
+	if expect := map[string]interface{}{"work-namespace": "ci-op-1", "versions.ovn": "23.09"}; !reflect.DeepEqual(d.Added, expect) {
+		t.Errorf("unexpected added %v", d.Added)
+	}
+	if expect := map[string]interface{}{"pod": "release-4.15", "versions.machine-os": "415.92"}; !reflect.DeepEqual(d.Removed, expect) {
+		t.Errorf("unexpected removed %v", d.Removed)
+	}
+	expectChanged := map[string]MetadataChange{
+		"job-version":        {Before: "4.15.2", After: "4.15.3"},
+		"versions.installer": {Before: "4.15.2", After: "4.15.3"},
+		"links":              {Before: map[string]interface{}{"a": "b"}, After: "none"},
+	}
+	if !reflect.DeepEqual(d.Changed, expectChanged) {
+		t.Errorf("unexpected changed %v", d.Changed)
+	}
+	if d.IsEmpty() {
+		t.Error("expected differences")
+	}
+	expectString := `~ job-version: 4.15.2 -> 4.15.3
+~ links: map[a:b] -> none
+- pod: release-4.15
+~ versions.installer: 4.15.2 -> 4.15.3
+- versions.machine-os: 415.92
++ versions.ovn: 23.09
++ work-namespace: ci-op-1`
+	if s := d.String(); s != expectString {
+		t.Errorf("unexpected string:\n%s", s)
+	}
+
+	if d := passing.Diff(passing); !d.IsEmpty() || d.String() != "" {
+		t.Errorf("expected no differences, got %v", d)
+	}
+	if d := Metadata(nil).Diff(Metadata{"a": "b"}); !reflect.DeepEqual(d.Added, map[string]interface{}{"a": "b"}) {
+		t.Errorf("unexpected diff from nil metadata %v", d)
+	}
+}
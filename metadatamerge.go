@@ -0,0 +1,34 @@
+package cisearch
+
+// Merge returns a copy of m with the keys of other added, replacing those
+// of m on conflict. Neither m nor other is modified.
+func (m Metadata) Merge(other Metadata) Metadata {
+	merged := make(Metadata, len(m)+len(other))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
+// DeepMerge returns a copy of m with the keys of other added as Merge does,
+// except that nested metadata present in both is merged recursively rather
+// than replaced. Neither m nor other is modified.
+func (m Metadata) DeepMerge(other Metadata) Metadata {
+	merged := make(Metadata, len(m)+len(other))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range other {
+		if bm, ok := m.Meta(k); ok && bm != nil {
+			if om, ok := other.Meta(k); ok && om != nil {
+				merged[k] = bm.DeepMerge(*om)
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,86 @@
+package cisearch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMetadata_Merge(t *testing.T) {
+	parse := func(s string) Metadata {
+		if s == "" {
+			return nil
+		}
+		var m Metadata
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	tests := []struct {
+		name      string
+		m, other  string
+		merge     string
+		deepMerge string
+	}{
+		{name: "both nil", merge: `{}`, deepMerge: `{}`},
+		{name: "nil other", m: `{"a":"1"}`, merge: `{"a":"1"}`, deepMerge: `{"a":"1"}`},
+		{name: "nil receiver", other: `{"a":"1"}`, merge: `{"a":"1"}`, deepMerge: `{"a":"1"}`},
+		{
+			name:      "conflict",
+			m:         `{"a":"1","b":"2"}`,
+			other:     `{"b":"3","c":"4"}`,
+			merge:     `{"a":"1","b":"3","c":"4"}`,
+			deepMerge: `{"a":"1","b":"3","c":"4"}`,
+		},
+		{
+			name:      "nested",
+			m:         `{"repos":{"openshift/origin":"master","openshift/api":"master"},"pod":"a"}`,
+			other:     `{"repos":{"openshift/origin":"release-4.15","openshift/installer":"master"}}`,
+			merge:     `{"pod":"a","repos":{"openshift/installer":"master","openshift/origin":"release-4.15"}}`,
+			deepMerge: `{"pod":"a","repos":{"openshift/api":"master","openshift/installer":"master","openshift/origin":"release-4.15"}}`,
+		},
+		{
+			name:      "deeply nested",
+			m:         `{"a":{"b":{"c":1,"d":2}}}`,
+			other:     `{"a":{"b":{"d":3}}}`,
+			merge:     `{"a":{"b":{"d":3}}}`,
+			deepMerge: `{"a":{"b":{"c":1,"d":3}}}`,
+		},
+		{
+			name:      "nested replaced by value",
+			m:         `{"a":{"b":1}}`,
+			other:     `{"a":"none"}`,
+			merge:     `{"a":"none"}`,
+			deepMerge: `{"a":"none"}`,
+		},
+		{
+			name:      "value replaced by nested",
+			m:         `{"a":"none"}`,
+			other:     `{"a":{"b":1}}`,
+			merge:     `{"a":{"b":1}}`,
+			deepMerge: `{"a":{"b":1}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, other := parse(tt.m), parse(tt.other)
+			before, _ := json.Marshal([]Metadata{m, other})
+			for name, fn := range map[string]func(Metadata) Metadata{"Merge": m.Merge, "DeepMerge": m.DeepMerge} {
+				expect := tt.merge
+				if name == "DeepMerge" {
+					expect = tt.deepMerge
+				}
+				data, err := json.Marshal(fn(other))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != expect {
+					t.Errorf("%s() = %s, want %s", name, data, expect)
+				}
+				if after, _ := json.Marshal([]Metadata{m, other}); string(after) != string(before) {
+					t.Errorf("%s() modified its inputs: %s", name, after)
+				}
+			}
+		})
+	}
+}
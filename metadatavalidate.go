@@ -0,0 +1,70 @@
+package cisearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxMetadataDepth is the deepest nesting of objects allowed in Metadata,
+// counting the top level as one.
+const maxMetadataDepth = 3
+
+// ValidationError lists the problems found by Metadata.Validate.
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid metadata: %s", strings.Join(e.Violations, "; "))
+}
+
+// Validate checks that m has no empty keys, no keys reserved for system use
+// (those starting with "_"), is nested no more than three objects deep and
+// only holds values that can be decoded from JSON. All violations are
+// reported in a *ValidationError.
+func (m Metadata) Validate() error {
+	var violations []string
+	validateMetadata("", map[string]interface{}(m), 1, &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: violations}
+}
+
+func validateMetadata(prefix string, m map[string]interface{}, depth int, violations *[]string) {
+	if depth > maxMetadataDepth {
+		*violations = append(*violations, fmt.Sprintf("%s: nested deeper than %d levels", strings.TrimSuffix(prefix, "."), maxMetadataDepth))
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch {
+		case k == "":
+			*violations = append(*violations, strings.TrimPrefix(strings.TrimSuffix(prefix, ".")+": empty key", ": "))
+		case strings.HasPrefix(k, "_"):
+			*violations = append(*violations, fmt.Sprintf("%s%s: keys starting with _ are reserved", prefix, k))
+		}
+		validateMetadataValue(prefix+k, m[k], depth, violations)
+	}
+}
+
+func validateMetadataValue(name string, v interface{}, depth int, violations *[]string) {
+	switch t := v.(type) {
+	case nil, string, float64, bool:
+	case []interface{}:
+		for i, item := range t {
+			validateMetadataValue(fmt.Sprintf("%s[%d]", name, i), item, depth, violations)
+		}
+	case map[string]interface{}:
+		validateMetadata(name+".", t, depth+1, violations)
+	case Metadata:
+		validateMetadata(name+".", t, depth+1, violations)
+	default:
+		*violations = append(*violations, fmt.Sprintf("%s: unsupported value of type %T", name, v))
+	}
+}
@@ -0,0 +1,78 @@
+package cisearch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMetadata_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		metadata   Metadata
+		json       string
+		violations []string
+	}{
+		{
+			name: "valid",
+			json: `{"repo":"openshift/origin","shards":3,"ok":true,"none":null,"tags":["a",1],"versions":{"installer":{"commit":"abc"}}}`,
+		},
+		{
+			name: "nil",
+		},
+		{
+			name:       "empty key",
+			json:       `{"":"value","versions":{"":"value"}}`,
+			violations: []string{"empty key", "versions: empty key"},
+		},
+		{
+			name:       "reserved key",
+			json:       `{"_internal":"value","links":{"_self":"value"}}`,
+			violations: []string{"_internal: keys starting with _ are reserved", "links._self: keys starting with _ are reserved"},
+		},
+		{
+			name:       "too deep",
+			json:       `{"a":{"b":{"c":{"d":"value"}}},"list":[{"b":{"c":{}}}]}`,
+			violations: []string{"a.b.c: nested deeper than 3 levels", "list[0].b.c: nested deeper than 3 levels"},
+		},
+		{
+			name:       "unsupported types",
+			metadata:   Metadata{"count": 3, "child": Metadata{"strings": []string{"a"}}},
+			violations: []string{"child.strings: unsupported value of type []string", "count: unsupported value of type int"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.metadata
+			if tt.json != "" {
+				if err := json.Unmarshal([]byte(tt.json), &m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := m.Validate()
+			if len(tt.violations) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Violations, tt.violations) {
+				t.Errorf("unexpected violations %q", validationErr.Violations)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_InvalidMetadata(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Client = NewFakeGCSClient()
+	obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true,"metadata":{"_reserved":"x","infra-commit":"abc"}}`)
+	if _, ok := obj.attrs.Metadata["infra-commit"]; ok {
+		t.Errorf("expected invalid metadata to be ignored: %v", obj.attrs.Metadata)
+	}
+}
@@ -0,0 +1,142 @@
+package cisearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// MetricDiff is the change of a single metric between two builds. RelChange
+// is relative to the Before value: it is infinite for metrics that were zero
+// or are new, and -1 for metrics that were removed.
+type MetricDiff struct {
+	Name          string
+	Before, After OutputMetric
+	AbsChange     float64
+	RelChange     float64
+	// Direction is "increase", "decrease", "unchanged", "new" or "removed".
+	Direction string
+}
+
+// CompareJobMetrics reads the job-metrics index entries of buildA and buildB
+// of job and returns the difference of each metric from buildA to buildB,
+// ordered by descending magnitude of the relative change. Metrics whose
+// values are not numbers are skipped.
+func CompareJobMetrics(ctx context.Context, client StorageClient, bucket, job, buildA, buildB string) ([]MetricDiff, error) {
+	before, err := readJobMetricsIndex(ctx, client, bucket, job, buildA)
+	if err != nil {
+		return nil, err
+	}
+	after, err := readJobMetricsIndex(ctx, client, bucket, job, buildB)
+	if err != nil {
+		return nil, err
+	}
+	return diffJobMetrics(before, after), nil
+}
+
+// readJobMetricsIndex reads the job-metrics index entry of a build.
+func readJobMetricsIndex(ctx context.Context, client StorageClient, bucket, job, build string) (JobMetricsIndex, error) {
+	entry, ok, err := findIndexEntry(ctx, client, bucket, defaultLayout, "job-metrics", job, build)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find metrics of %s/%s: %v", job, build, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("build %s/%s has no indexed metrics", job, build)
+	}
+	data, err := readIndexEntry(ctx, client, bucket, entry.Attrs.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", entry.Attrs.Name, err)
+	}
+	var index JobMetricsIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", entry.Attrs.Name, err)
+	}
+	return index, nil
+}
+
+// diffJobMetrics returns the difference of each metric from before to after.
+func diffJobMetrics(before, after JobMetricsIndex) []MetricDiff {
+	parse := func(m OutputMetric) (float64, bool) {
+		v, err := m.Float64()
+		return v, err == nil && !math.IsNaN(v)
+	}
+	var diffs []MetricDiff
+	for name, b := range before {
+		x, ok := parse(b)
+		if !ok {
+			continue
+		}
+		diff := MetricDiff{Name: name, Before: b}
+		a, ok := after[name]
+		if !ok {
+			diff.AbsChange, diff.RelChange, diff.Direction = -x, -1, "removed"
+			diffs = append(diffs, diff)
+			continue
+		}
+		y, ok := parse(a)
+		if !ok {
+			continue
+		}
+		diff.After = a
+		diff.AbsChange = y - x
+		switch {
+		case diff.AbsChange == 0:
+			diff.Direction = "unchanged"
+		case x == 0:
+			diff.RelChange = math.Inf(int(math.Copysign(1, diff.AbsChange)))
+		default:
+			diff.RelChange = diff.AbsChange / math.Abs(x)
+		}
+		switch {
+		case diff.AbsChange > 0:
+			diff.Direction = "increase"
+		case diff.AbsChange < 0:
+			diff.Direction = "decrease"
+		}
+		diffs = append(diffs, diff)
+	}
+	for name, a := range after {
+		if _, ok := before[name]; ok {
+			continue
+		}
+		y, ok := parse(a)
+		if !ok {
+			continue
+		}
+		diffs = append(diffs, MetricDiff{Name: name, After: a, AbsChange: y, RelChange: math.Inf(1), Direction: "new"})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		x, y := math.Abs(diffs[i].RelChange), math.Abs(diffs[j].RelChange)
+		if x != y {
+			return x > y
+		}
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs
+}
+
+// FormatMetricDiff formats diffs as a table for display in a terminal.
+func FormatMetricDiff(diffs []MetricDiff) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tBEFORE\tAFTER\tCHANGE\tDIRECTION")
+	for _, diff := range diffs {
+		before, after, change := diff.Before.Value, diff.After.Value, "-"
+		switch diff.Direction {
+		case "new":
+			before = "-"
+		case "removed":
+			after = "-"
+		}
+		if !math.IsInf(diff.RelChange, 0) && diff.Direction != "removed" {
+			change = fmt.Sprintf("%+.1f%%", diff.RelChange*100)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", diff.Name, before, after, change, diff.Direction)
+	}
+	w.Flush()
+	return buf.String()
+}
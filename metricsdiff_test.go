@@ -0,0 +1,86 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareJobMetrics(t *testing.T) {
+	const job = "periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn"
+	client := NewFakeGCSClient()
+	put := func(key, build string, index JobMetricsIndex) {
+		data, err := json.Marshal(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.put("bucket", "index/job-metrics/"+key+"/"+job+"/"+build, data, nil)
+	}
+	// created at 2021-03-02T11:46:30Z
+	put("2021-03-02T13:00:00Z", "1366716541889941504", JobMetricsIndex{
+		"duration":   {Value: "3600"},
+		"cpu":        {Value: "2"},
+		"memory":     {Value: "100"},
+		"alerts":     {Value: "0"},
+		"restarts":   {Value: "5"},
+		"removed":    {Value: "7"},
+		"not-number": {Value: "x"},
+	})
+	put("2021-03-03T01:00:00Z", "1366716541889941600", JobMetricsIndex{
+		"duration":   {Value: "3600"},
+		"cpu":        {Value: "3"},
+		"memory":     {Value: "50"},
+		"alerts":     {Value: "4"},
+		"restarts":   {Value: "4"},
+		"new":        {Value: "1"},
+		"not-number": {Value: "y"},
+	})
+
+	diffs, err := CompareJobMetrics(context.TODO(), client, "bucket", job, "1366716541889941504", "1366716541889941600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type summary struct {
+		Name      string
+		AbsChange float64
+		RelChange float64
+		Direction string
+	}
+	var got []summary
+	for _, d := range diffs {
+		got = append(got, summary{d.Name, d.AbsChange, d.RelChange, d.Direction})
+	}
+	expect := []summary{
+		{"alerts", 4, math.Inf(1), "increase"},
+		{"new", 1, math.Inf(1), "new"},
+		{"removed", -7, -1, "removed"},
+		{"cpu", 1, 0.5, "increase"},
+		{"memory", -50, -0.5, "decrease"},
+		{"restarts", -1, -0.2, "decrease"},
+		{"duration", 0, 0, "unchanged"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("unexpected diffs:\n%v\n%v", got, expect)
+	}
+
+	out := FormatMetricDiff(diffs)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(expect)+1 || !strings.HasPrefix(lines[0], "METRIC") {
+		t.Fatalf("unexpected table:\n%s", out)
+	}
+	for _, want := range []string{"+50.0%", "-50.0%", "-20.0%", "+0.0%"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in table:\n%s", want, out)
+		}
+	}
+	if fields := strings.Fields(lines[3]); !reflect.DeepEqual(fields, []string{"removed", "7", "-", "-", "removed"}) {
+		t.Errorf("unexpected row for removed metric: %q", lines[3])
+	}
+
+	if _, err := CompareJobMetrics(context.TODO(), client, "bucket", job, "1366716541889941504", "1366716541889941700"); err == nil {
+		t.Error("expected an error for a build without metrics")
+	}
+}
@@ -0,0 +1,320 @@
+package cisearch
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewOutputMetric returns a metric with value sampled at timestamp, which is
+// truncated to seconds.
+func NewOutputMetric(timestamp time.Time, value float64) OutputMetric {
+	return OutputMetric{}.WithTimestamp(timestamp).WithValue(value)
+}
+
+// WithTimestamp returns a copy of m sampled at t, truncated to seconds.
+func (m OutputMetric) WithTimestamp(t time.Time) OutputMetric {
+	m.Timestamp = t.Unix()
+	return m
+}
+
+// WithValue returns a copy of m with value v.
+func (m OutputMetric) WithValue(v float64) OutputMetric {
+	m.Value = strconv.FormatFloat(v, 'f', -1, 64)
+	return m
+}
+
+// Float64 returns the value of the metric, which may be NaN or an infinity.
+func (m OutputMetric) Float64() (float64, error) {
+	return strconv.ParseFloat(m.Value, 64)
+}
+
+// Time returns the time the metric was sampled, in UTC.
+func (m OutputMetric) Time() time.Time {
+	return time.Unix(m.Timestamp, 0).UTC()
+}
+
+// MarshalPrometheusText formats m as a sample of the named metric in the
+// Prometheus text exposition format. name may include labels, as the keys
+// of a JobMetricsIndex do.
+func (m OutputMetric) MarshalPrometheusText(name string) string {
+	return fmt.Sprintf("%s %s %d", name, m.Value, m.Timestamp*1000)
+}
+
+// JobMetricsIndex is the content of a job-metrics index entry, mapping
+// metric keys of the form name or name{label="value",...} to their values.
+type JobMetricsIndex map[string]OutputMetric
+
+// Get returns the metric stored under the exact key name.
+func (idx JobMetricsIndex) Get(name string) (OutputMetric, bool) {
+	m, ok := idx[name]
+	return m, ok
+}
+
+// GetWithLabels returns the metric called name whose labels are exactly
+// labels, regardless of the order the labels are encoded in the key.
+func (idx JobMetricsIndex) GetWithLabels(name string, labels map[string]string) (OutputMetric, bool) {
+	if len(labels) == 0 {
+		return idx.Get(name)
+	}
+	for key, m := range idx {
+		if !strings.HasPrefix(key, name+"{") {
+			continue
+		}
+		keyName, keyLabels, err := ParseMetricKey(key)
+		if err != nil || keyName != name {
+			continue
+		}
+		if maps.Equal(keyLabels, labels) {
+			return m, true
+		}
+	}
+	return OutputMetric{}, false
+}
+
+// Names returns the sorted keys of the index.
+func (idx JobMetricsIndex) Names() []string {
+	names := make([]string, 0, len(idx))
+	for name := range idx {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithPrefix returns the subset of the index whose keys start with prefix.
+func (idx JobMetricsIndex) WithPrefix(prefix string) JobMetricsIndex {
+	sub := make(JobMetricsIndex)
+	for name, m := range idx {
+		if strings.HasPrefix(name, prefix) {
+			sub[name] = m
+		}
+	}
+	return sub
+}
+
+// Total returns the number of metrics in the index.
+func (idx JobMetricsIndex) Total() int {
+	return len(idx)
+}
+
+// UngroupedLabel is the group of metrics that do not have the label
+// requested from AggregateByLabel.
+const UngroupedLabel = "__ungrouped__"
+
+// AggregateByLabel groups the metrics of the index by the value of their
+// labelKey label, in the order of their keys. Metrics without the label,
+// including those whose key cannot be parsed, are grouped under
+// UngroupedLabel.
+func (idx JobMetricsIndex) AggregateByLabel(labelKey string) map[string][]OutputMetric {
+	groups := make(map[string][]OutputMetric)
+	for _, key := range idx.Names() {
+		group := UngroupedLabel
+		if _, labels, err := ParseMetricKey(key); err == nil {
+			if value, ok := labels[labelKey]; ok {
+				group = value
+			}
+		}
+		groups[group] = append(groups[group], idx[key])
+	}
+	return groups
+}
+
+// SumByLabel returns the sum of the values of each group of metrics
+// returned by AggregateByLabel. Values that are not numbers are skipped, as
+// are groups without any numbers.
+func (idx JobMetricsIndex) SumByLabel(labelKey string) map[string]float64 {
+	return idx.reduceByLabel(labelKey, func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	})
+}
+
+// MeanByLabel returns the mean of the values of each group of metrics
+// returned by AggregateByLabel, skipping values as SumByLabel does.
+func (idx JobMetricsIndex) MeanByLabel(labelKey string) map[string]float64 {
+	return idx.reduceByLabel(labelKey, func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	})
+}
+
+// MaxByLabel returns the largest value of each group of metrics returned
+// by AggregateByLabel, skipping values as SumByLabel does.
+func (idx JobMetricsIndex) MaxByLabel(labelKey string) map[string]float64 {
+	return idx.reduceByLabel(labelKey, func(values []float64) float64 {
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	})
+}
+
+// reduceByLabel applies reduce to the numeric values of each group of
+// metrics returned by AggregateByLabel.
+func (idx JobMetricsIndex) reduceByLabel(labelKey string, reduce func([]float64) float64) map[string]float64 {
+	results := make(map[string]float64)
+	for group, metrics := range idx.AggregateByLabel(labelKey) {
+		values := make([]float64, 0, len(metrics))
+		for _, m := range metrics {
+			v, err := m.Float64()
+			if err != nil || math.IsNaN(v) {
+				continue
+			}
+			values = append(values, v)
+		}
+		if len(values) > 0 {
+			results[group] = reduce(values)
+		}
+	}
+	return results
+}
+
+// ParseMetricKey splits a key of a JobMetricsIndex, of the form name or
+// name{label="value",...}, into the metric name and its labels. Keys without
+// labels return nil labels.
+func ParseMetricKey(key string) (string, map[string]string, error) {
+	i := strings.IndexByte(key, '{')
+	if i == -1 {
+		return key, nil, nil
+	}
+	if !strings.HasSuffix(key, "}") {
+		return "", nil, fmt.Errorf("metric key %q has no closing brace", key)
+	}
+	name, selector := key[:i], key[i+1:len(key)-1]
+	labels := make(map[string]string)
+	for len(selector) > 0 {
+		eq := strings.IndexByte(selector, '=')
+		if eq < 1 {
+			return "", nil, fmt.Errorf("metric key %q has an invalid label", key)
+		}
+		quoted, err := strconv.QuotedPrefix(selector[eq+1:])
+		if err != nil {
+			return "", nil, fmt.Errorf("metric key %q has an invalid label value: %v", key, err)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", nil, fmt.Errorf("metric key %q has an invalid label value: %v", key, err)
+		}
+		labels[selector[:eq]] = value
+		selector = selector[eq+1+len(quoted):]
+		if len(selector) > 0 {
+			if selector[0] != ',' {
+				return "", nil, fmt.Errorf("metric key %q has unexpected characters after a label", key)
+			}
+			selector = selector[1:]
+		}
+	}
+	return name, labels, nil
+}
+
+// MetricEntry is a single metric of a job-metrics index entry.
+type MetricEntry struct {
+	Name   string
+	Metric OutputMetric
+}
+
+// TopNByValue returns up to n metrics with the largest absolute values,
+// largest first. Values that are not finite numbers are skipped.
+func TopNByValue(metrics map[string]OutputMetric, n int) []MetricEntry {
+	type valued struct {
+		entry MetricEntry
+		abs   float64
+	}
+	values := make([]valued, 0, len(metrics))
+	for name, m := range metrics {
+		v, err := m.Float64()
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		values = append(values, valued{entry: MetricEntry{Name: name, Metric: m}, abs: math.Abs(v)})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].abs != values[j].abs {
+			return values[i].abs > values[j].abs
+		}
+		return values[i].entry.Name < values[j].entry.Name
+	})
+	entries := make([]MetricEntry, 0, len(values))
+	for _, v := range values {
+		if len(entries) >= n {
+			break
+		}
+		entries = append(entries, v.entry)
+	}
+	return entries
+}
+
+// TopNByAge returns up to n metrics sampled at or before referenceTime, most
+// recent first.
+func TopNByAge(metrics map[string]OutputMetric, n int, referenceTime time.Time) []MetricEntry {
+	entries := make([]MetricEntry, 0, len(metrics))
+	for name, m := range metrics {
+		if m.Timestamp > referenceTime.Unix() {
+			continue
+		}
+		entries = append(entries, MetricEntry{Name: name, Metric: m})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Metric.Timestamp != entries[j].Metric.Timestamp {
+			return entries[i].Metric.Timestamp > entries[j].Metric.Timestamp
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if n < 0 {
+		n = 0
+	}
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// ExponentialHistogram counts values into buckets with the given upper
+// bounds, which must be increasing. Buckets are keyed "<= <bound>" and each
+// value is counted in the first bucket whose bound it does not exceed.
+// Values larger than every bound, including +Inf, are counted in the last
+// bucket. NaN values are not counted in any bucket but under the key "NaN".
+func ExponentialHistogram(values []OutputMetric, buckets []float64) (map[string]int, error) {
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("at least one bucket is required")
+	}
+	keys := make([]string, len(buckets))
+	for i, bound := range buckets {
+		if math.IsNaN(bound) || (i > 0 && bound <= buckets[i-1]) {
+			return nil, fmt.Errorf("bucket bounds must be increasing numbers: %v", buckets)
+		}
+		keys[i] = "<= " + strconv.FormatFloat(bound, 'f', -1, 64)
+	}
+	counts := make(map[string]int, len(buckets))
+	for _, key := range keys {
+		counts[key] = 0
+	}
+	for _, m := range values {
+		v, err := m.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric value %q: %v", m.Value, err)
+		}
+		if math.IsNaN(v) {
+			counts["NaN"]++
+			continue
+		}
+		i := sort.SearchFloat64s(buckets, v)
+		if i == len(buckets) {
+			i--
+		}
+		counts[keys[i]]++
+	}
+	return counts, nil
+}
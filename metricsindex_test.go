@@ -0,0 +1,345 @@
+package cisearch
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testMetricsIndex = JobMetricsIndex{
+	"job:duration:total:seconds":                                    {Timestamp: 1705320000, Value: "3600"},
+	"cluster:usage:cpu{namespace=\"openshift-etcd\"}":               {Timestamp: 1705320000, Value: "1.5"},
+	"cluster:usage:cpu{namespace=\"openshift-apiserver\"}":          {Timestamp: 1705320000, Value: "2.5"},
+	"cluster:usage:memory{node=\"a\",namespace=\"openshift-etcd\"}": {Timestamp: 1705320000, Value: "1024"},
+	"cluster:usage:memory{node=\"a\",namespace=\"quote\\\"d\"}":     {Timestamp: 1705320000, Value: "2048"},
+}
+
+func TestJobMetricsIndex_Get(t *testing.T) {
+	if m, ok := testMetricsIndex.Get("job:duration:total:seconds"); !ok || m.Value != "3600" {
+		t.Errorf("Get() = %v, %t", m, ok)
+	}
+	if _, ok := testMetricsIndex.Get("cluster:usage:cpu"); ok {
+		t.Error("expected labeled metrics to require labels")
+	}
+}
+
+func TestJobMetricsIndex_GetWithLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+		value  string
+	}{
+		{name: "no labels", metric: "job:duration:total:seconds", value: "3600"},
+		{name: "single label", metric: "cluster:usage:cpu", labels: map[string]string{"namespace": "openshift-apiserver"}, value: "2.5"},
+		{name: "label order", metric: "cluster:usage:memory", labels: map[string]string{"namespace": "openshift-etcd", "node": "a"}, value: "1024"},
+		{name: "escaped value", metric: "cluster:usage:memory", labels: map[string]string{"namespace": "quote\"d", "node": "a"}, value: "2048"},
+		{name: "subset of labels", metric: "cluster:usage:memory", labels: map[string]string{"node": "a"}},
+		{name: "wrong name", metric: "cluster:usage", labels: map[string]string{"namespace": "openshift-etcd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := testMetricsIndex.GetWithLabels(tt.metric, tt.labels)
+			if ok != (tt.value != "") || m.Value != tt.value {
+				t.Errorf("GetWithLabels() = %v, %t", m, ok)
+			}
+		})
+	}
+}
+
+func TestJobMetricsIndex_Names(t *testing.T) {
+	expect := []string{
+		"cluster:usage:cpu{namespace=\"openshift-apiserver\"}",
+		"cluster:usage:cpu{namespace=\"openshift-etcd\"}",
+		"cluster:usage:memory{node=\"a\",namespace=\"openshift-etcd\"}",
+		"cluster:usage:memory{node=\"a\",namespace=\"quote\\\"d\"}",
+		"job:duration:total:seconds",
+	}
+	if names := testMetricsIndex.Names(); !reflect.DeepEqual(names, expect) {
+		t.Errorf("Names() = %v", names)
+	}
+	if names := (JobMetricsIndex{}).Names(); len(names) != 0 {
+		t.Errorf("Names() = %v", names)
+	}
+}
+
+func TestJobMetricsIndex_WithPrefix(t *testing.T) {
+	sub := testMetricsIndex.WithPrefix("cluster:usage:cpu")
+	if sub.Total() != 2 {
+		t.Errorf("expected 2 metrics, got %v", sub)
+	}
+	if sub := testMetricsIndex.WithPrefix("missing"); sub.Total() != 0 {
+		t.Errorf("expected no metrics, got %v", sub)
+	}
+}
+
+func TestJobMetricsIndex_Total(t *testing.T) {
+	if total := testMetricsIndex.Total(); total != 5 {
+		t.Errorf("Total() = %d", total)
+	}
+	if total := JobMetricsIndex(nil).Total(); total != 0 {
+		t.Errorf("Total() = %d", total)
+	}
+}
+
+func TestJobMetricsIndex_AggregateByLabel(t *testing.T) {
+	groups := testMetricsIndex.AggregateByLabel("namespace")
+	expect := map[string][]OutputMetric{
+		// ordered by key
+		"openshift-etcd":      {{Timestamp: 1705320000, Value: "1.5"}, {Timestamp: 1705320000, Value: "1024"}},
+		"openshift-apiserver": {{Timestamp: 1705320000, Value: "2.5"}},
+		"quote\"d":            {{Timestamp: 1705320000, Value: "2048"}},
+		UngroupedLabel:        {{Timestamp: 1705320000, Value: "3600"}},
+	}
+	if !reflect.DeepEqual(groups, expect) {
+		t.Errorf("unexpected groups by namespace %v", groups)
+	}
+
+	// the second label of a combination
+	groups = testMetricsIndex.AggregateByLabel("node")
+	if len(groups["a"]) != 2 || len(groups[UngroupedLabel]) != 3 {
+		t.Errorf("unexpected groups by node %v", groups)
+	}
+}
+
+func TestJobMetricsIndex_ReduceByLabel(t *testing.T) {
+	idx := JobMetricsIndex{
+		"usage{namespace=\"a\"}":           {Value: "1"},
+		"usage{namespace=\"a\",pod=\"x\"}": {Value: "5"},
+		"usage{namespace=\"a\",pod=\"y\"}": {Value: "NaN"},
+		"usage{namespace=\"b\"}":           {Value: "-2"},
+		"usage{namespace=\"c\"}":           {Value: "not a number"},
+		"total":                            {Value: "10"},
+	}
+	if got, expect := idx.SumByLabel("namespace"), map[string]float64{"a": 6, "b": -2, UngroupedLabel: 10}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("SumByLabel() = %v, want %v", got, expect)
+	}
+	if got, expect := idx.MeanByLabel("namespace"), map[string]float64{"a": 3, "b": -2, UngroupedLabel: 10}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("MeanByLabel() = %v, want %v", got, expect)
+	}
+	if got, expect := idx.MaxByLabel("namespace"), map[string]float64{"a": 5, "b": -2, UngroupedLabel: 10}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("MaxByLabel() = %v, want %v", got, expect)
+	}
+	if got, expect := idx.SumByLabel("pod"), map[string]float64{"x": 5, UngroupedLabel: 9}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("SumByLabel(pod) = %v, want %v", got, expect)
+	}
+}
+
+func TestParseMetricKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		name    string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{key: "job:duration:total:seconds", name: "job:duration:total:seconds"},
+		{key: "cluster:usage:cpu{}", name: "cluster:usage:cpu", labels: map[string]string{}},
+		{key: `cluster:usage:cpu{namespace="openshift-etcd"}`, name: "cluster:usage:cpu", labels: map[string]string{"namespace": "openshift-etcd"}},
+		{key: `cluster:usage:memory{node="a",namespace="quote\"d"}`, name: "cluster:usage:memory", labels: map[string]string{"node": "a", "namespace": `quote"d`}},
+		{key: `cluster:usage:cpu{namespace="a"`, wantErr: true},
+		{key: `cluster:usage:cpu{="a"}`, wantErr: true},
+		{key: `cluster:usage:cpu{namespace=a}`, wantErr: true},
+		{key: `cluster:usage:cpu{namespace="a" node="b"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		name, labels, err := ParseMetricKey(tt.key)
+		if (err != nil) != tt.wantErr || name != tt.name || !reflect.DeepEqual(labels, tt.labels) {
+			t.Errorf("ParseMetricKey(%q) = %q, %v, %v", tt.key, name, labels, err)
+		}
+	}
+}
+
+func TestOutputMetric_Float64(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  OutputMetric
+		expect  float64
+		time    time.Time
+		wantErr bool
+	}{
+		{name: "zero value", wantErr: true, time: time.Unix(0, 0).UTC()},
+		{name: "float", metric: OutputMetric{Timestamp: 1705320000, Value: "3725.5"}, expect: 3725.5, time: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{name: "NaN", metric: OutputMetric{Timestamp: 1705320000, Value: "NaN"}, expect: math.NaN(), time: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{name: "+Inf", metric: OutputMetric{Timestamp: 1705320000, Value: "+Inf"}, expect: math.Inf(1), time: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{name: "-Inf", metric: OutputMetric{Timestamp: 1705320000, Value: "-Inf"}, expect: math.Inf(-1), time: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{name: "invalid", metric: OutputMetric{Timestamp: 1705320000, Value: "many"}, wantErr: true, time: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, v := range map[string]interface {
+				Float64() (float64, error)
+				Time() time.Time
+			}{
+				"OutputMetric":    tt.metric,
+				"PrometheusValue": PrometheusValue{Timestamp: tt.metric.Timestamp, Value: tt.metric.Value},
+			} {
+				f, err := v.Float64()
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("%s.Float64() error = %v, wantErr %v", name, err, tt.wantErr)
+				}
+				if err == nil && f != tt.expect && !(math.IsNaN(f) && math.IsNaN(tt.expect)) {
+					t.Errorf("%s.Float64() = %v, expected %v", name, f, tt.expect)
+				}
+				if got := v.Time(); got != tt.time {
+					t.Errorf("%s.Time() = %v, expected %v", name, got, tt.time)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputMetric_Builders(t *testing.T) {
+	at := time.Date(2024, 1, 15, 12, 0, 0, 500, time.UTC)
+	m := NewOutputMetric(at, 3725.5)
+	if !reflect.DeepEqual(m, OutputMetric{Timestamp: 1705320000, Value: "3725.5"}) {
+		t.Errorf("unexpected metric %#v", m)
+	}
+	later := m.WithTimestamp(at.Add(time.Minute))
+	if later.Timestamp != 1705320060 || later.Value != "3725.5" || m.Timestamp != 1705320000 {
+		t.Errorf("WithTimestamp did not return an updated copy: %#v %#v", m, later)
+	}
+	for v, expect := range map[float64]string{0: "0", 1e21: "1000000000000000000000", 0.000001: "0.000001", -2: "-2"} {
+		if got := m.WithValue(v); got.Value != expect || got.Timestamp != m.Timestamp {
+			t.Errorf("WithValue(%v) = %#v", v, got)
+		}
+	}
+	if m.Value != "3725.5" {
+		t.Errorf("WithValue modified the original metric %#v", m)
+	}
+	if s := m.MarshalPrometheusText(`job:duration:total:seconds`); s != "job:duration:total:seconds 3725.5 1705320000000" {
+		t.Errorf("unexpected text %q", s)
+	}
+	if s := m.MarshalPrometheusText(`cluster:cpu:usage{node="a"}`); s != `cluster:cpu:usage{node="a"} 3725.5 1705320000000` {
+		t.Errorf("unexpected text %q", s)
+	}
+}
+
+func TestTopNByValue(t *testing.T) {
+	metrics := map[string]OutputMetric{
+		"a":     {Value: "10"},
+		"b":     {Value: "-250"},
+		"c":     {Value: "3.5"},
+		"d":     {Value: "NaN"},
+		"e":     {Value: "+Inf"},
+		"f":     {Value: "not a number"},
+		"g":     {Value: "10"},
+		"small": {Value: "0.001"},
+	}
+	names := func(entries []MetricEntry) []string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+	if got := names(TopNByValue(metrics, 3)); !reflect.DeepEqual(got, []string{"b", "a", "g"}) {
+		t.Errorf("unexpected top 3 %v", got)
+	}
+	if got := names(TopNByValue(metrics, 100)); !reflect.DeepEqual(got, []string{"b", "a", "g", "c", "small"}) {
+		t.Errorf("unexpected top 100 %v", got)
+	}
+	if got := TopNByValue(nil, 3); len(got) != 0 {
+		t.Errorf("expected no entries, got %v", got)
+	}
+	if got := TopNByValue(metrics, 0); len(got) != 0 {
+		t.Errorf("expected no entries, got %v", got)
+	}
+	if got := TopNByValue(metrics, 1); got[0].Metric.Value != "-250" {
+		t.Errorf("unexpected metric %#v", got[0])
+	}
+}
+
+func TestTopNByAge(t *testing.T) {
+	reference := time.Unix(1705320000, 0)
+	metrics := map[string]OutputMetric{
+		"oldest": {Timestamp: 1705310000, Value: "1"},
+		"recent": {Timestamp: 1705319990, Value: "NaN"},
+		"latest": {Timestamp: 1705320000, Value: "2"},
+		"also":   {Timestamp: 1705319990, Value: "3"},
+		"future": {Timestamp: 1705320001, Value: "4"},
+	}
+	var names []string
+	for _, e := range TopNByAge(metrics, 3, reference) {
+		names = append(names, e.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"latest", "also", "recent"}) {
+		t.Errorf("unexpected top 3 %v", names)
+	}
+	if got := TopNByAge(metrics, 10, reference); len(got) != 4 {
+		t.Errorf("expected all metrics up to the reference time, got %v", got)
+	}
+	if got := TopNByAge(map[string]OutputMetric{}, 3, reference); len(got) != 0 {
+		t.Errorf("expected no entries, got %v", got)
+	}
+}
+
+func TestExponentialHistogram(t *testing.T) {
+	buckets := []float64{0.5, 1, 2, 4, math.Inf(1)}
+	metrics := func(values ...string) []OutputMetric {
+		var metrics []OutputMetric
+		for _, v := range values {
+			metrics = append(metrics, OutputMetric{Value: v})
+		}
+		return metrics
+	}
+	tests := []struct {
+		name    string
+		values  []OutputMetric
+		buckets []float64
+		expect  map[string]int
+		wantErr bool
+	}{
+		{
+			name:   "uniform",
+			values: metrics("0.25", "0.75", "1.5", "3", "8"),
+			expect: map[string]int{"<= 0.5": 1, "<= 1": 1, "<= 2": 1, "<= 4": 1, "<= +Inf": 1},
+		},
+		{
+			name:   "skewed",
+			values: metrics("0.1", "0.2", "0.5", "1", "-3", "+Inf"),
+			expect: map[string]int{"<= 0.5": 4, "<= 1": 1, "<= 2": 0, "<= 4": 0, "<= +Inf": 1},
+		},
+		{
+			name:   "NaN",
+			values: metrics("NaN", "1", "NaN"),
+			expect: map[string]int{"<= 0.5": 0, "<= 1": 1, "<= 2": 0, "<= 4": 0, "<= +Inf": 0, "NaN": 2},
+		},
+		{
+			name:    "values above the last bound",
+			values:  metrics("2", "100", "+Inf"),
+			buckets: []float64{1, 10},
+			expect:  map[string]int{"<= 1": 0, "<= 10": 3},
+		},
+		{
+			name:    "invalid value",
+			values:  metrics("one"),
+			wantErr: true,
+		},
+		{
+			name:    "decreasing buckets",
+			buckets: []float64{2, 1},
+			wantErr: true,
+		},
+		{
+			name:    "no buckets",
+			buckets: []float64{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.buckets
+			if b == nil {
+				b = buckets
+			}
+			counts, err := ExponentialHistogram(tt.values, b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExponentialHistogram() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(counts, tt.expect) {
+				t.Errorf("ExponentialHistogram() = %v, want %v", counts, tt.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// HandlerFunc processes a single GCS event.
+type HandlerFunc func(ctx context.Context, e GCSEvent) error
+
+// Middleware wraps a HandlerFunc with additional behavior.
+type Middleware func(HandlerFunc) HandlerFunc
+
+// EventFilter decides whether an event should be skipped, returning a
+// human readable reason when it is.
+type EventFilter func(GCSEvent) (skip bool, reason string)
+
+// WithFilters returns a Middleware that skips events matched by any of the
+// filters without calling the handler or returning an error. The reason is
+// logged and reported as the SkipReason of IndexJobsWithResult.
+func WithFilters(filters ...EventFilter) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, e GCSEvent) error {
+			for _, filter := range filters {
+				if skip, reason := filter(e); skip {
+					log.Printf("Skipped %s: %s", e.GSURI(), reason)
+					skipEvent(ctx, reason)
+					return nil
+				}
+			}
+			return next(ctx, e)
+		}
+	}
+}
+
+// FilterByContentType skips events for objects with any of the given content
+// types.
+func FilterByContentType(types ...string) EventFilter {
+	return func(e GCSEvent) (bool, string) {
+		for _, t := range types {
+			if strings.EqualFold(e.ContentType, t) {
+				return true, fmt.Sprintf("content type %s is filtered", e.ContentType)
+			}
+		}
+		return false, ""
+	}
+}
+
+// FilterByNameSuffix skips events for objects whose name ends with any of the
+// given suffixes.
+func FilterByNameSuffix(suffixes ...string) EventFilter {
+	return func(e GCSEvent) (bool, string) {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(e.Name, suffix) {
+				return true, fmt.Sprintf("name has filtered suffix %s", suffix)
+			}
+		}
+		return false, ""
+	}
+}
+
+// FilterByMinSize skips events for objects smaller than the given number of
+// bytes. Events without a valid size are not skipped.
+func FilterByMinSize(bytes int64) EventFilter {
+	return func(e GCSEvent) (bool, string) {
+		if size := e.ParsedSize(); size >= 0 && size < bytes {
+			return true, fmt.Sprintf("size %d is smaller than %d bytes", size, bytes)
+		}
+		return false, ""
+	}
+}
@@ -0,0 +1,72 @@
+package cisearch
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEventFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter EventFilter
+		e      GCSEvent
+		skip   bool
+	}{
+		{name: "content type match", filter: FilterByContentType("text/plain", "application/xml"), e: GCSEvent{ContentType: "application/XML"}, skip: true},
+		{name: "content type mismatch", filter: FilterByContentType("text/plain"), e: GCSEvent{ContentType: "application/json"}},
+		{name: "no content types", filter: FilterByContentType(), e: GCSEvent{ContentType: "application/json"}},
+		{name: "suffix match", filter: FilterByNameSuffix(".log", ".txt"), e: GCSEvent{Name: "logs/job/1/build-log.txt"}, skip: true},
+		{name: "suffix mismatch", filter: FilterByNameSuffix(".log", ".txt"), e: GCSEvent{Name: "logs/job/1/finished.json"}},
+		{name: "smaller than minimum", filter: FilterByMinSize(10), e: GCSEvent{Size: "9"}, skip: true},
+		{name: "equal to minimum", filter: FilterByMinSize(10), e: GCSEvent{Size: "10"}},
+		{name: "invalid size", filter: FilterByMinSize(10), e: GCSEvent{Size: "unknown"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skip, reason := tt.filter(tt.e)
+			if skip != tt.skip {
+				t.Errorf("expected skip=%t, got %t", tt.skip, skip)
+			}
+			if skip && reason == "" {
+				t.Error("expected a reason for skipping")
+			}
+		})
+	}
+}
+
+func TestWithFilters(t *testing.T) {
+	filters := WithFilters(
+		FilterByContentType("text/plain"),
+		FilterByNameSuffix(".xml"),
+		FilterByMinSize(2),
+	)
+	tests := []struct {
+		name   string
+		e      GCSEvent
+		called bool
+	}{
+		{name: "passes all filters", e: GCSEvent{Name: "finished.json", ContentType: "application/json", Size: "100"}, called: true},
+		{name: "skipped by first filter", e: GCSEvent{Name: "finished.json", ContentType: "text/plain", Size: "100"}},
+		{name: "skipped by second filter", e: GCSEvent{Name: "junit.xml", ContentType: "application/json", Size: "100"}},
+		{name: "skipped by last filter", e: GCSEvent{Name: "finished.json", ContentType: "application/json", Size: "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			handler := filters(func(ctx context.Context, e GCSEvent) error {
+				called = true
+				return nil
+			})
+			var reason string
+			if err := handler(context.WithValue(context.TODO(), skipKey{}, &reason), tt.e); err != nil {
+				t.Fatal(err)
+			}
+			if called != tt.called {
+				t.Errorf("expected called=%t, got %t", tt.called, called)
+			}
+			if (reason == "") != tt.called {
+				t.Errorf("unexpected skip reason %q", reason)
+			}
+		})
+	}
+}
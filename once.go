@@ -0,0 +1,81 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+	"time"
+)
+
+// ErrAlreadyProcessed is returned by handlers wrapped with IndexJobsOnce for
+// objects that were indexed recently by the same process. It does not
+// indicate a failure.
+var ErrAlreadyProcessed = errors.New("object was already indexed by this process")
+
+// IndexJobsOnce returns a Middleware that remembers each object successfully
+// handled (or already indexed) for ttl and returns ErrAlreadyProcessed
+// instead of handling it again. An object being handled is remembered as
+// well, so concurrent deliveries of it are handled once, and is forgotten if
+// it fails. Objects are identified by onceKey.
+func IndexJobsOnce(ttl time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		var (
+			processed sync.Map
+			lock      sync.Mutex
+			lastSweep = time.Now()
+		)
+		return func(ctx context.Context, e GCSEvent) error {
+			key := onceKey(e)
+			now := time.Now()
+			expires := now.Add(ttl)
+			for {
+				previous, loaded := processed.LoadOrStore(key, expires)
+				if !loaded {
+					break
+				}
+				if now.Before(previous.(time.Time)) {
+					return ErrAlreadyProcessed
+				}
+				if processed.CompareAndSwap(key, previous, expires) {
+					break
+				}
+			}
+			if err := next(ctx, e); err != nil && !IsPreconditionFailure(err) {
+				processed.CompareAndDelete(key, expires)
+				return err
+			}
+
+			// evict expired keys at most once per ttl
+			lock.Lock()
+			defer lock.Unlock()
+			if now.Sub(lastSweep) >= ttl {
+				lastSweep = now
+				processed.Range(func(k, expires interface{}) bool {
+					if !now.Before(expires.(time.Time)) {
+						processed.Delete(k)
+					}
+					return true
+				})
+			}
+			return nil
+		}
+	}
+}
+
+// onceKey identifies the object of e for IndexJobsOnce. The finished.json and
+// finished.yaml of a build describe the same result, so both are identified
+// by the job and build. Other objects are identified by bucket and name, so
+// the finished.json and job_metrics.json of a build are tracked separately.
+func onceKey(e GCSEvent) string {
+	switch path.Base(e.Name) {
+	case "finished.json", "finished.yaml":
+		return e.Bucket + "/" + path.Dir(e.Name) + "/finished"
+	}
+	return e.Bucket + "/" + e.Name
+}
+
+// NewIndexJobsOnce returns IndexJobs wrapped with IndexJobsOnce.
+func NewIndexJobsOnce(ttl time.Duration) HandlerFunc {
+	return IndexJobsOnce(ttl)(IndexJobs)
+}
@@ -0,0 +1,113 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIndexJobsOnce(t *testing.T) {
+	calls := make(map[string]int)
+	var result error
+	handler := IndexJobsOnce(time.Hour)(func(ctx context.Context, e GCSEvent) error {
+		calls[e.Name]++
+		return result
+	})
+	finished := GCSEvent{Bucket: "bucket", Name: testFinishedName}
+	metrics := GCSEvent{Bucket: "bucket", Name: "logs/job/1000/artifacts/metrics/job_metrics.json"}
+
+	if err := handler(context.TODO(), finished); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler(context.TODO(), finished); err != ErrAlreadyProcessed {
+		t.Errorf("expected the second call to be skipped, got %v", err)
+	}
+	if err := handler(context.TODO(), metrics); err != nil {
+		t.Fatal(err)
+	}
+	if calls[finished.Name] != 1 || calls[metrics.Name] != 1 {
+		t.Errorf("unexpected calls %v", calls)
+	}
+
+	// failures are retried but already indexed objects are not
+	failed := GCSEvent{Bucket: "bucket", Name: "logs/job/1001/finished.json"}
+	result = errors.New("unavailable")
+	if err := handler(context.TODO(), failed); err == nil {
+		t.Fatal("expected the failure to be returned")
+	}
+	result = wrapPreconditionFailure(&googleapi.Error{Code: http.StatusPreconditionFailed})
+	if err := handler(context.TODO(), failed); err != nil {
+		t.Fatalf("expected an already indexed object to succeed, got %v", err)
+	}
+	if err := handler(context.TODO(), failed); err != ErrAlreadyProcessed {
+		t.Errorf("expected the third call to be skipped, got %v", err)
+	}
+	if calls[failed.Name] != 2 {
+		t.Errorf("unexpected calls %v", calls)
+	}
+
+	// the finished.yaml of a build with an indexed finished.json
+	result = nil
+	if err := handler(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/finished.yaml"}); err != ErrAlreadyProcessed {
+		t.Errorf("expected finished.yaml to be skipped, got %v", err)
+	}
+	if err := handler(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1002/finished.yaml"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1002/finished.json"}); err != ErrAlreadyProcessed {
+		t.Errorf("expected finished.json to be skipped, got %v", err)
+	}
+}
+
+func TestIndexJobsOnce_Concurrent(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	handler := IndexJobsOnce(time.Hour)(func(ctx context.Context, e GCSEvent) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	})
+	e := GCSEvent{Bucket: "bucket", Name: testFinishedName}
+	const deliveries = 10
+	errs := make(chan error, deliveries)
+	for i := 0; i < deliveries; i++ {
+		go func() { errs <- handler(context.TODO(), e) }()
+	}
+	// every delivery but the one being handled is skipped
+	for i := 0; i < deliveries-1; i++ {
+		if err := <-errs; err != ErrAlreadyProcessed {
+			t.Errorf("expected a concurrent delivery to be skipped, got %v", err)
+		}
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestIndexJobsOnce_TTL(t *testing.T) {
+	var calls int
+	handler := IndexJobsOnce(time.Millisecond)(func(ctx context.Context, e GCSEvent) error {
+		calls++
+		return nil
+	})
+	e := GCSEvent{Bucket: "bucket", Name: testFinishedName}
+	if err := handler(context.TODO(), e); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := handler(context.TODO(), e); err != nil {
+		t.Fatalf("expected the object to be handled after the ttl, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,296 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+)
+
+// Options controls how events are indexed.
+type Options struct {
+	// Client is used for all GCS access. If nil, a new GCS client is created
+	// for each event that must be indexed.
+	Client StorageClient
+	// IndexVersion is the schema version of the index paths that are written.
+	// "v1" (or empty) writes to index/<type>/... for backwards compatibility
+	// while later versions write to index/<version>/<type>/....
+	IndexVersion string
+	// MaxMetricsFileSizeBytes is the largest job_metrics.json that will be
+	// indexed. Larger files are skipped with a warning rather than risking
+	// running out of memory. Defaults to DefaultMaxMetricsFileSizeBytes; zero
+	// disables the limit.
+	MaxMetricsFileSizeBytes int64
+	// StaleMetricThreshold is how long before a job finished a metric may
+	// have been sampled and still be indexed. Defaults to
+	// DefaultStaleMetricThreshold; zero indexes every metric.
+	StaleMetricThreshold time.Duration
+	// SkipArtifactSizeCalculation disables listing the artifacts of each
+	// finished build to record their total size in the job-state index.
+	SkipArtifactSizeCalculation bool
+	// MaxTopFailures is the number of failed tests of an unsuccessful build
+	// that are recorded in its job-state index entry. Defaults to
+	// DefaultMaxTopFailures; zero disables reading the JUnit results.
+	MaxTopFailures int
+	// FailurePatterns are matched against the log of each unsuccessful build
+	// to record why it failed. See ExtractFailureReason.
+	FailurePatterns []FailurePattern
+	// TracerProvider receives spans for the steps of indexing each event. If
+	// nil, no spans are recorded.
+	TracerProvider trace.TracerProvider
+	// JobKindFilter, if set, limits indexing to the jobs whose kind (see
+	// ClassifyJobKind) starts with one of the given prefixes.
+	JobKindFilter []string
+	// LegacyIndexPath also writes each job to index/job-state, in addition
+	// to the index of its job type (index/<type>/job-state). Queries read the
+	// legacy index.
+	LegacyIndexPath bool
+	// VariantIndex also writes each job with a variant (see ExtractVariant)
+	// to index/variant/<variant>, which is laid out like index/job-state.
+	VariantIndex bool
+	// Overwrite replaces existing index entries instead of leaving jobs that
+	// were already indexed unchanged.
+	Overwrite bool
+	// BatchWrites writes the index entries of each job concurrently rather
+	// than one after another. See ConcurrentWriter.
+	BatchWrites bool
+	// Sidecars are started in the background after each job is indexed.
+	Sidecars []SidecarFunc
+	// SidecarTimeout bounds how long each sidecar may run. Defaults to
+	// DefaultSidecarTimeout.
+	SidecarTimeout time.Duration
+	// DedupRotationInterval is how often IndexJobsServer deletes stale
+	// objects from the deduplication index. Defaults to
+	// DefaultDedupRotationInterval.
+	DedupRotationInterval time.Duration
+	// DeadLetterPrefix, if set, is where IndexJobsWithResult writes the
+	// events that could not be indexed, in the bucket of each event.
+	DeadLetterPrefix string
+	// DryRun reports the changes that maintenance operations such as
+	// HealIndex would make without making them.
+	DryRun bool
+	// RequiredMetric must be present in each job_metrics.json for it to be
+	// indexed, and its timestamp is the key of the entry. Defaults to
+	// DefaultRequiredMetric.
+	RequiredMetric string
+	// FallbackToEventTime indexes job_metrics.json without RequiredMetric
+	// under the time the event reports the object was updated, rather than
+	// failing.
+	FallbackToEventTime bool
+	// MetricsJobPrefixes limit the jobs whose job_metrics.json is indexed.
+	// Defaults to DefaultMetricsJobPrefixes when empty.
+	MetricsJobPrefixes []string
+	// Verbose logs each metric that is indexed.
+	Verbose bool
+	// CompressIndex gzip compresses the job-state and job-metrics index
+	// entries that are written.
+	CompressIndex bool
+	// WritePerMetric also writes each indexed metric of a build to its own
+	// entry at index/metrics/<metric>/<completed>/<job>/<build>, so single
+	// metrics can be queried without reading the job-metrics entry.
+	WritePerMetric bool
+	// WriteWorkers bounds the number of per-metric entries written at once.
+	// Defaults to DefaultMaxConcurrentWrites.
+	WriteWorkers int
+	// MaxOutputMetrics limits the metrics indexed for a single build. Once it
+	// is reached further metrics, other than RequiredMetric, are dropped with
+	// a warning. Zero disables the limit.
+	MaxOutputMetrics int
+	// StorageClass is the storage class of the index entries that are
+	// written. If empty the bucket default is used.
+	StorageClass string
+	// KMSKeyName is the Cloud KMS key that encrypts the index entries that
+	// are written. If empty the bucket default is used.
+	KMSKeyName string
+	// GCSEndpoint overrides the GCS API endpoint of the client created when
+	// Client is nil.
+	GCSEndpoint string
+	// RecordMetricsSizes adds the size of each job_metrics.json that is
+	// indexed to the .size-histogram object of the index (see SizeHistogram).
+	// Every update rewrites that one object, so it is only suited to
+	// occasional sampling.
+	RecordMetricsSizes bool
+}
+
+// DefaultMaxMetricsFileSizeBytes is the default limit on the size of an
+// indexed job_metrics.json (10 MB).
+const DefaultMaxMetricsFileSizeBytes = 10 * 1024 * 1024
+
+// DefaultMaxOutputMetrics is the default limit on the number of metrics
+// indexed for a single build.
+const DefaultMaxOutputMetrics = 10000
+
+// DefaultStaleMetricThreshold is the default age, relative to the end of
+// the job, beyond which metrics are not indexed.
+const DefaultStaleMetricThreshold = 2 * time.Hour
+
+// DefaultMaxTopFailures is the default number of failed tests recorded for
+// each unsuccessful build.
+const DefaultMaxTopFailures = 5
+
+// DefaultRequiredMetric is the metric that job_metrics.json must contain
+// when Options.RequiredMetric is not set.
+const DefaultRequiredMetric = "job:duration:total:seconds"
+
+// DefaultMetricsJobPrefixes are the release jobs whose job_metrics.json is
+// indexed when Options.MetricsJobPrefixes is not set.
+var DefaultMetricsJobPrefixes = []string{"periodic-ci-openshift-release-", "release-openshift-"}
+
+// DefaultOptions returns the options used by IndexJobs.
+func DefaultOptions() Options {
+	return Options{
+		IndexVersion:            "v1",
+		MaxMetricsFileSizeBytes: DefaultMaxMetricsFileSizeBytes,
+		MaxTopFailures:          DefaultMaxTopFailures,
+		MaxOutputMetrics:        DefaultMaxOutputMetrics,
+		StaleMetricThreshold:    DefaultStaleMetricThreshold,
+		LegacyIndexPath:         true,
+		VariantIndex:            true,
+		DedupRotationInterval:   DefaultDedupRotationInterval,
+	}
+}
+
+// includesJob returns true if the named job passes the JobKindFilter.
+func (o Options) includesJob(job string) bool {
+	if len(o.JobKindFilter) == 0 {
+		return true
+	}
+	kind := ClassifyJobKind(job)
+	for _, prefix := range o.JobKindFilter {
+		if strings.HasPrefix(kind, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredMetric returns the configured RequiredMetric or the default.
+func (o Options) requiredMetric() string {
+	if o.RequiredMetric == "" {
+		return DefaultRequiredMetric
+	}
+	return o.RequiredMetric
+}
+
+// includesMetricsJob returns true if the job_metrics.json of the named job
+// should be indexed.
+func (o Options) includesMetricsJob(job string) bool {
+	if len(o.MetricsJobPrefixes) == 0 {
+		return matchesJobFilter(job, DefaultMetricsJobPrefixes)
+	}
+	return matchesJobFilter(job, o.MetricsJobPrefixes)
+}
+
+// matchesJobFilter returns true if job starts with one of filters. No job
+// matches an empty list of filters.
+func matchesJobFilter(job string, filters []string) bool {
+	for _, filter := range filters {
+		if strings.HasPrefix(job, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexWriter returns the writer of index entries into bucket.
+func (o Options) indexWriter(client StorageClient, bucket string) IndexWriter {
+	return IndexWriter{Client: client, Bucket: bucket, Compress: o.CompressIndex, StorageClass: o.StorageClass, KMSKeyName: o.KMSKeyName}
+}
+
+// writeConditions returns the conditions for writing an index entry, which
+// must not already exist unless Overwrite is set.
+func (o Options) writeConditions() *storage.Conditions {
+	if o.Overwrite {
+		return nil
+	}
+	return &storage.Conditions{DoesNotExist: true}
+}
+
+// storageClient returns the configured client or creates a new one.
+func (o Options) storageClient(ctx context.Context) (StorageClient, error) {
+	if o.Client != nil {
+		return o.Client, nil
+	}
+	client, err := storage.NewClient(ctx, o.clientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	return NewStorageClient(client), nil
+}
+
+// clientOptions returns the options of the GCS clients that are created.
+func (o Options) clientOptions() []option.ClientOption {
+	opts := []option.ClientOption{option.WithScopes(storage.ScopeReadWrite)}
+	if o.GCSEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(o.GCSEndpoint))
+	}
+	return opts
+}
+
+// indexPrefix returns the path under which entries of the named index are
+// stored for the given index version.
+func indexPrefix(version, index string) string {
+	if version == "" || version == "v1" {
+		return path.Join("index", index)
+	}
+	return path.Join("index", version, index)
+}
+
+// buildIndices returns the indices holding an entry per build. The indices
+// computed from them, such as error-budget and daily-summary, are not
+// included since they are rewritten by the next computation.
+func buildIndices() []string {
+	indices := []string{"job-state"}
+	for _, jobType := range jobTypes {
+		indices = append(indices, path.Join(jobType, "job-state"))
+	}
+	return append(indices, "variant", "job-running", "job-metrics", "metrics", "cluster-data", "k8s-events", "flakes")
+}
+
+// UpgradeIndex copies the entries of the named indices (every index with an
+// entry per build if none are given) from the fromVersion layout to the
+// toVersion layout. Existing entries at the destination are replaced, so an
+// interrupted upgrade may be safely restarted.
+func UpgradeIndex(ctx context.Context, client StorageClient, bucket, fromVersion, toVersion string, indices ...string) error {
+	if len(indices) == 0 {
+		indices = buildIndices()
+	}
+	for _, index := range indices {
+		from, to := indexPrefix(fromVersion, index)+"/", indexPrefix(toVersion, index)+"/"
+		objects, err := client.List(ctx, bucket, &storage.Query{Prefix: from})
+		if err != nil {
+			return fmt.Errorf("unable to list %s: %v", from, err)
+		}
+		for _, attrs := range objects {
+			if err := copyObject(ctx, client, bucket, attrs, to+strings.TrimPrefix(attrs.Name, from)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyObject copies the object described by attrs to name in the same bucket.
+func copyObject(ctx context.Context, client StorageClient, bucket string, attrs *storage.ObjectAttrs, name string) error {
+	data, err := readObject(ctx, client, bucket, attrs.Name)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", attrs.Name, err)
+	}
+	w := client.NewWriter(ctx, bucket, name, storage.ObjectAttrs{
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+	}, nil)
+	if _, err := w.Write(data); err != nil {
+		closeFailedWriter(w, name)
+		return fmt.Errorf("unable to copy %s to %s: %v", attrs.Name, name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %v", attrs.Name, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package cisearch
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIndexJobsWithOptions_IndexVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		expect  string
+	}{
+		{version: "", expect: testStatePath},
+		{version: "v1", expect: testStatePath},
+		{version: "v2", expect: "index/v2/job-state/2024-01-15T12:00:00Z/job/1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			opts := DefaultOptions()
+			opts.Client = client
+			opts.IndexVersion = tt.version
+			if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts); err != nil {
+				t.Fatal(err)
+			}
+			obj := client.get("bucket", tt.expect)
+			if obj == nil {
+				t.Fatalf("expected index entry at %s", tt.expect)
+			}
+			if obj.attrs.Metadata["state"] != "success" || obj.attrs.Metadata["link"] != "gs://bucket/logs/job/1000" {
+				t.Errorf("unexpected metadata: %v", obj.attrs.Metadata)
+			}
+		})
+	}
+}
+
+func TestUpgradeIndex(t *testing.T) {
+	client := NewFakeGCSClient()
+	metadata := map[string]string{"link": "gs://bucket/logs/job/1000", "state": "failed"}
+	client.put("bucket", testStatePath, []byte(`{"state":"failed"}`), metadata)
+	client.put("bucket", "index/job-metrics/2024-01-15T12:00:00Z/job/1000", []byte(`{}`), nil)
+	others := []string{
+		"index/unknown/job-state/2024-01-15T12:00:00Z/job/1000",
+		"index/variant/ovn/2024-01-15T12:00:00Z/job/1000",
+		"index/job-running/2024-01-15T12:00:00Z/job/1001",
+		"index/cluster-data/2024-01-15T12:00:00Z/job/1000",
+		"index/k8s-events/2024-01-15T12:00:00Z/job/1000",
+	}
+	for _, name := range others {
+		client.put("bucket", name, []byte(`{}`), nil)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := UpgradeIndex(context.TODO(), client, "bucket", "v1", "v2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	obj := client.get("bucket", "index/v2/job-state/2024-01-15T12:00:00Z/job/1000")
+	if obj == nil {
+		t.Fatal("job-state entry was not copied")
+	}
+	if string(obj.data) != `{"state":"failed"}` || obj.attrs.Metadata["state"] != "failed" {
+		t.Errorf("unexpected copy: %s %v", obj.data, obj.attrs.Metadata)
+	}
+	if client.get("bucket", "index/v2/job-metrics/2024-01-15T12:00:00Z/job/1000") == nil {
+		t.Error("job-metrics entry was not copied")
+	}
+	for _, name := range others {
+		if client.get("bucket", "index/v2/"+strings.TrimPrefix(name, "index/")) == nil {
+			t.Errorf("%s was not copied", name)
+		}
+	}
+	if client.get("bucket", testStatePath) == nil {
+		t.Error("source entry should be preserved")
+	}
+}
+
+func TestMatchesJobFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     string
+		filters []string
+		expect  bool
+	}{
+		{name: "no filters", job: "release-openshift-origin-installer-e2e-aws"},
+		{name: "exact match", job: "periodic-ci-openshift-release-master-nightly-4.15-e2e-aws", filters: []string{"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws"}, expect: true},
+		{name: "prefix match", job: "release-openshift-origin-installer-e2e-aws", filters: []string{"periodic-ci-", "release-openshift-"}, expect: true},
+		{name: "no match", job: "pull-ci-openshift-origin-master-e2e-aws", filters: []string{"periodic-ci-", "release-openshift-"}},
+		{name: "longer filter", job: "release-", filters: []string{"release-openshift-"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesJobFilter(tt.job, tt.filters); got != tt.expect {
+				t.Errorf("matchesJobFilter(%q, %v) = %t", tt.job, tt.filters, got)
+			}
+		})
+	}
+}
+
+func TestOptions_IncludesMetricsJob(t *testing.T) {
+	for _, prefixes := range [][]string{nil, {}} {
+		opts := Options{MetricsJobPrefixes: prefixes}
+		if !opts.includesMetricsJob("release-openshift-origin-installer-e2e-aws") || opts.includesMetricsJob("pull-ci-openshift-origin-master-e2e-aws") {
+			t.Errorf("expected %#v to use the default prefixes", prefixes)
+		}
+	}
+	opts := Options{MetricsJobPrefixes: []string{"pull-ci-"}}
+	if opts.includesMetricsJob("release-openshift-origin-installer-e2e-aws") || !opts.includesMetricsJob("pull-ci-openshift-origin-master-e2e-aws") {
+		t.Error("expected the configured prefixes to replace the defaults")
+	}
+}
+
+func TestOptions_ClientOptions(t *testing.T) {
+	opts := DefaultOptions()
+	if n := len(opts.clientOptions()); n != 1 {
+		t.Errorf("expected only the scope by default, got %d options", n)
+	}
+	opts.GCSEndpoint = "http://localhost:4443/storage/v1/"
+	if n := len(opts.clientOptions()); n != 2 {
+		t.Errorf("expected the endpoint to be added, got %d options", n)
+	}
+}
@@ -0,0 +1,383 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// jobStateEntry is an entry of the job-state index.
+type jobStateEntry struct {
+	Time  time.Time
+	Job   string
+	Build string
+	Attrs *storage.ObjectAttrs
+}
+
+// indexLayout selects the paths that are read by queries, which must match
+// the Options the index was written with.
+type indexLayout struct {
+	version string
+	legacy  bool
+}
+
+// defaultLayout is the layout written by DefaultOptions: the v1 index with
+// the legacy job-state path. The exported query functions without Options
+// read it.
+var defaultLayout = DefaultOptions().layout()
+
+// layout returns the layout of the index written with o.
+func (o Options) layout() indexLayout {
+	return indexLayout{version: o.IndexVersion, legacy: o.LegacyIndexPath}
+}
+
+// prefixes returns the path prefixes holding the entries of the named index.
+// Without the legacy path, job-state entries are only written to the index
+// of each job type.
+func (l indexLayout) prefixes(index string) []string {
+	if index == "job-state" && !l.legacy {
+		prefixes := make([]string, 0, len(jobTypes))
+		for _, jobType := range jobTypes {
+			prefixes = append(prefixes, indexPrefix(l.version, path.Join(jobType, "job-state"))+"/")
+		}
+		return prefixes
+	}
+	return []string{indexPrefix(l.version, index) + "/"}
+}
+
+// ListJobsByPattern returns the results of every job in the job-state index
+// whose name matches pattern. Index keys are RFC3339 timestamps, so date may
+// be any prefix of a key: "2024-01-15" selects a whole day while a full key
+// selects a single second. The results are built from the object metadata
+// and do not require reading each index entry.
+//
+// The job name follows the key, so a listing with a "/" delimiter under date
+// returns one prefix per second rather than per job, and cannot skip the
+// jobs that do not match pattern. The index is listed once and filtered.
+func ListJobsByPattern(ctx context.Context, client StorageClient, bucket, date string, pattern *regexp.Regexp) ([]JobResult, error) {
+	return listJobResults(ctx, client, bucket, defaultLayout, date, func(entry jobStateEntry) bool {
+		return pattern.MatchString(entry.Job)
+	})
+}
+
+// ListJobsByInfraCommit returns the results of every job in the job-state
+// index under the date prefix that ran with the given infra commit.
+func ListJobsByInfraCommit(ctx context.Context, client StorageClient, bucket, date, commit string) ([]JobResult, error) {
+	return listJobResults(ctx, client, bucket, defaultLayout, date, func(entry jobStateEntry) bool {
+		return entry.Attrs.Metadata["infra-commit"] == commit
+	})
+}
+
+// ListJobsByVersion returns the results of every job in the job-state index
+// under the date prefix that tested the given OpenShift version.
+func ListJobsByVersion(ctx context.Context, client StorageClient, bucket, date, version string) ([]JobResult, error) {
+	return listJobResults(ctx, client, bucket, defaultLayout, date, func(entry jobStateEntry) bool {
+		return entry.Attrs.Metadata["openshift-version"] == version
+	})
+}
+
+// ListJobsByBranch returns the results of every job in the job-state index
+// under the date prefix that tested the given branch.
+func ListJobsByBranch(ctx context.Context, client StorageClient, bucket, date, branch string) ([]JobResult, error) {
+	return listJobResults(ctx, client, bucket, defaultLayout, date, func(entry jobStateEntry) bool {
+		return entry.Attrs.Metadata["branch"] == branch
+	})
+}
+
+// ListJobsByFeatureFlag returns the results of every job in the job-state
+// index under the date prefix that recorded the named feature flag as
+// enabled or disabled. Jobs that did not record the flag are excluded.
+func ListJobsByFeatureFlag(ctx context.Context, client StorageClient, bucket, date, flagName string, enabled bool) ([]JobResult, error) {
+	return listJobResults(ctx, client, bucket, defaultLayout, date, func(entry jobStateEntry) bool {
+		return entry.Attrs.Metadata[featureFlagMetadataPrefix+flagName] == strconv.FormatBool(enabled)
+	})
+}
+
+// ListJobsByVariant returns the results of every job in the variant index
+// of variant (see ExtractVariant) under the date prefix.
+func ListJobsByVariant(ctx context.Context, client StorageClient, bucket, variant, date string) ([]JobResult, error) {
+	entries, err := listIndexEntries(ctx, client, bucket, defaultLayout, path.Join("variant", variant), date)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]JobResult, 0, len(entries))
+	for _, entry := range entries {
+		results = append(results, entry.jobResult())
+	}
+	return results, nil
+}
+
+// maxBuildDuration bounds how long after a build is created it may complete,
+// which limits how much of the job-state index FindBuildByID searches.
+const maxBuildDuration = 48 * time.Hour
+
+// FindBuildByID returns the result of the given build of job from the
+// job-state index. Only the keys between the creation time embedded in the
+// build ID and maxBuildDuration later are searched. The second return value
+// is false if the build has not been indexed.
+func FindBuildByID(ctx context.Context, client StorageClient, bucket, job, build string) (JobResult, bool, error) {
+	entry, ok, err := findIndexEntry(ctx, client, bucket, defaultLayout, "job-state", job, build)
+	if err != nil || !ok {
+		return JobResult{}, ok, err
+	}
+	return entry.jobResult(), true, nil
+}
+
+// findIndexEntry returns the entry for the given build of job in the named
+// index, which is laid out like the job-state index, searching the keys
+// between the creation of the build and maxBuildDuration later.
+func findIndexEntry(ctx context.Context, client StorageClient, bucket string, layout indexLayout, index, job, build string) (jobStateEntry, bool, error) {
+	created, err := BuildNumberToSnowflakeTime(build)
+	if err != nil {
+		return jobStateEntry{}, false, err
+	}
+	entries, err := listIndexEntriesBetween(ctx, client, bucket, layout, index, created.Truncate(time.Second), created.Add(maxBuildDuration))
+	if err != nil {
+		return jobStateEntry{}, false, err
+	}
+	for _, entry := range entries {
+		if entry.Job == job && entry.Build == build {
+			return entry, true, nil
+		}
+	}
+	return jobStateEntry{}, false, nil
+}
+
+// FailingJobSummary counts the builds of a job that did not succeed.
+type FailingJobSummary struct {
+	JobName     string
+	Total       int
+	Failed      int
+	FailureRate float64
+}
+
+// ListFailingJobs summarizes the results of each job that completed between
+// from and to, ordered by descending failure rate. Jobs with fewer than
+// minSamples builds in the window are omitted. Builds that failed or errored
+// are both counted as failures.
+func ListFailingJobs(ctx context.Context, client StorageClient, bucket string, from, to time.Time, minSamples int) ([]FailingJobSummary, error) {
+	entries, err := listJobStatesBetween(ctx, client, bucket, defaultLayout, from, to)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make(map[string]*FailingJobSummary)
+	for _, entry := range entries {
+		summary, ok := jobs[entry.Job]
+		if !ok {
+			summary = &FailingJobSummary{JobName: entry.Job}
+			jobs[entry.Job] = summary
+		}
+		summary.Total++
+		if entry.Attrs.Metadata["state"] != "success" {
+			summary.Failed++
+		}
+	}
+	summaries := make([]FailingJobSummary, 0, len(jobs))
+	for _, summary := range jobs {
+		if summary.Total < minSamples {
+			continue
+		}
+		summary.FailureRate = float64(summary.Failed) / float64(summary.Total)
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].FailureRate != summaries[j].FailureRate {
+			return summaries[i].FailureRate > summaries[j].FailureRate
+		}
+		return summaries[i].JobName < summaries[j].JobName
+	})
+	return summaries, nil
+}
+
+// LargestArtifacts returns the topN jobs under the date prefix of the
+// job-state index with the largest artifacts, largest first.
+func LargestArtifacts(ctx context.Context, client StorageClient, bucket, date string, topN int) ([]JobResult, error) {
+	results, err := listJobResults(ctx, client, bucket, defaultLayout, date, func(entry jobStateEntry) bool {
+		return entry.Attrs.Metadata["artifact-size-bytes"] != ""
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].ArtifactSize > results[j].ArtifactSize
+	})
+	if len(results) > topN {
+		results = results[:topN]
+	}
+	return results, nil
+}
+
+// listJobResults returns the results of the job-state index entries under
+// the date prefix that are accepted by include.
+func listJobResults(ctx context.Context, client StorageClient, bucket string, layout indexLayout, date string, include func(jobStateEntry) bool) ([]JobResult, error) {
+	entries, err := listJobStates(ctx, client, bucket, layout, date)
+	if err != nil {
+		return nil, err
+	}
+	var results []JobResult
+	for _, entry := range entries {
+		if include(entry) {
+			results = append(results, entry.jobResult())
+		}
+	}
+	return results, nil
+}
+
+// listJobStates lists the job-state index entries whose key starts with date.
+func listJobStates(ctx context.Context, client StorageClient, bucket string, layout indexLayout, date string) ([]jobStateEntry, error) {
+	return listIndexEntries(ctx, client, bucket, layout, "job-state", date)
+}
+
+// listIndexEntries lists the entries of the named index, which are laid out
+// like the job-state index, whose key starts with date.
+func listIndexEntries(ctx context.Context, client StorageClient, bucket string, layout indexLayout, index, date string) ([]jobStateEntry, error) {
+	var entries []jobStateEntry
+	for _, prefix := range layout.prefixes(index) {
+		objects, err := client.List(ctx, bucket, &storage.Query{Prefix: prefix + date})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list %s%s: %v", prefix, date, err)
+		}
+		for _, attrs := range objects {
+			parts := strings.Split(strings.TrimPrefix(attrs.Name, prefix), "/")
+			if len(parts) != 3 {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, parts[0])
+			if err != nil {
+				continue
+			}
+			entries = append(entries, jobStateEntry{Time: t, Job: parts[1], Build: parts[2], Attrs: attrs})
+		}
+	}
+	return entries, nil
+}
+
+// listJobStatesBetween lists the job-state index entries with keys between
+// from and to inclusive, listing one day at a time.
+func listJobStatesBetween(ctx context.Context, client StorageClient, bucket string, layout indexLayout, from, to time.Time) ([]jobStateEntry, error) {
+	return listIndexEntriesBetween(ctx, client, bucket, layout, "job-state", from, to)
+}
+
+// listIndexEntriesBetween lists the entries of the named index with keys
+// between from and to inclusive, listing one day at a time.
+func listIndexEntriesBetween(ctx context.Context, client StorageClient, bucket string, layout indexLayout, index string, from, to time.Time) ([]jobStateEntry, error) {
+	var entries []jobStateEntry
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		dayEntries, err := listIndexEntries(ctx, client, bucket, layout, index, day.Format("2006-01-02"))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range dayEntries {
+			if entry.Time.Before(from) || entry.Time.After(to) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// ListIndexDates returns the keys of the named index between from and to
+// inclusive, in ascending order. Only the common prefixes of each day are
+// listed, so the cost does not depend on the number of entries per key.
+func ListIndexDates(ctx context.Context, client StorageClient, bucket, indexType string, from, to time.Time) ([]time.Time, error) {
+	return listIndexDates(ctx, client, bucket, defaultLayout, indexType, from, to)
+}
+
+// listIndexDates returns the keys of the named index of layout between from
+// and to inclusive, in ascending order and without duplicates.
+func listIndexDates(ctx context.Context, client StorageClient, bucket string, layout indexLayout, indexType string, from, to time.Time) ([]time.Time, error) {
+	seen := make(map[time.Time]bool)
+	var dates []time.Time
+	for _, prefix := range layout.prefixes(indexType) {
+		for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+			dayPrefix := prefix + day.Format("2006-01-02")
+			objects, err := client.List(ctx, bucket, &storage.Query{Prefix: dayPrefix, Delimiter: "/"})
+			if err != nil {
+				return nil, fmt.Errorf("unable to list %s: %v", dayPrefix, err)
+			}
+			for _, attrs := range objects {
+				if attrs.Prefix == "" {
+					continue
+				}
+				t, err := time.Parse(time.RFC3339, strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, prefix), "/"))
+				if err != nil || t.Before(from) || t.After(to) || seen[t] {
+					continue
+				}
+				seen[t] = true
+				dates = append(dates, t)
+			}
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, nil
+}
+
+// jobResult recovers the JobResult of the entry from its metadata and the
+// job and build of its path.
+func (e jobStateEntry) jobResult() JobResult {
+	result := jobResultFromAttrs(e.Attrs)
+	result.JobName, result.BuildNumber = e.Job, BuildID(e.Build)
+	return result
+}
+
+// jobResultFromAttrs recovers a JobResult from the metadata written
+// alongside each job-state index entry.
+func jobResultFromAttrs(attrs *storage.ObjectAttrs) JobResult {
+	completed, _ := strconv.ParseInt(attrs.Metadata["completed"], 10, 64)
+	indexedAt, _ := strconv.ParseInt(attrs.Metadata["indexed-at"], 10, 64)
+	artifactSize, _ := strconv.ParseInt(attrs.Metadata["artifact-size-bytes"], 10, 64)
+	var topFailures []string
+	for i := 0; ; i++ {
+		name, ok := attrs.Metadata["top-failure-"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		topFailures = append(topFailures, name)
+	}
+	return JobResult{
+		State:            attrs.Metadata["state"],
+		CompletedAt:      completed,
+		Link:             attrs.Metadata["link"],
+		IndexedAt:        indexedAt,
+		ArtifactSize:     artifactSize,
+		Branch:           attrs.Metadata["branch"],
+		OpenShiftVersion: attrs.Metadata["openshift-version"],
+		TopFailures:      topFailures,
+		FailureReason:    attrs.Metadata["failure-reason"],
+		Annotations:      annotationsFromMetadata(attrs.Metadata),
+		FeatureFlags:     featureFlagsFromMetadata(attrs.Metadata),
+	}
+}
+
+// featureFlagMetadataPrefix prefixes the metadata keys that record whether
+// each feature flag of a job was enabled.
+const featureFlagMetadataPrefix = "feature-flag."
+
+// featureFlagsFromMetadata returns the feature flags stored in the metadata
+// of an index entry, or nil if there are none.
+func featureFlagsFromMetadata(metadata map[string]string) map[string]bool {
+	var flags map[string]bool
+	for k, v := range metadata {
+		name := strings.TrimPrefix(k, featureFlagMetadataPrefix)
+		if name == k {
+			continue
+		}
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			continue
+		}
+		if flags == nil {
+			flags = make(map[string]bool)
+		}
+		flags[name] = enabled
+	}
+	return flags
+}
@@ -0,0 +1,428 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestListJobsByPattern(t *testing.T) {
+	client := NewFakeGCSClient()
+	jobs := []string{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-gcp-ovn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-sdn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-azure",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-metal-ipi",
+		"release-openshift-origin-installer-e2e-aws-upgrade",
+		"release-openshift-origin-installer-e2e-gcp-upgrade",
+		"pull-ci-openshift-origin-master-unit",
+		"pull-ci-openshift-origin-master-images",
+		"pull-ci-openshift-installer-master-e2e-vsphere",
+	}
+	for i, job := range jobs {
+		build := fmt.Sprintf("%d", 1000+i)
+		link := fmt.Sprintf("gs://bucket/logs/%s/%s", job, build)
+		client.put("bucket", path.Join("index", "job-state", "2024-01-15T12:00:00Z", job, build), nil, map[string]string{
+			"link":      link,
+			"state":     "success",
+			"completed": "1705320000",
+		})
+	}
+	// an entry from another day must not be returned
+	client.put("bucket", "index/job-state/2024-01-16T12:00:00Z/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/2000", nil, map[string]string{
+		"link": "gs://bucket/logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/2000",
+	})
+
+	results, err := ListJobsByPattern(context.TODO(), client, "bucket", "2024-01-15", regexp.MustCompile(`e2e-aws`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, r := range results {
+		if r.State != "success" || r.CompletedAt != 1705320000 {
+			t.Errorf("unexpected result: %#v", r)
+		}
+		links = append(links, r.Link)
+	}
+	sort.Strings(links)
+	expect := []string{
+		"gs://bucket/logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/1000",
+		"gs://bucket/logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-sdn/1002",
+		"gs://bucket/logs/release-openshift-origin-installer-e2e-aws-upgrade/1005",
+	}
+	if fmt.Sprint(links) != fmt.Sprint(expect) {
+		t.Errorf("unexpected results:\n%v\n%v", links, expect)
+	}
+}
+
+func TestListFailingJobs(t *testing.T) {
+	client := NewFakeGCSClient()
+	put := func(key, job, build, state string) {
+		client.put("bucket", path.Join("index/job-state", key, job, build), nil, map[string]string{"state": state})
+	}
+	// day one
+	put("2024-01-15T01:00:00Z", "job-a", "1", "success")
+	put("2024-01-15T02:00:00Z", "job-a", "2", "failed")
+	put("2024-01-15T03:00:00Z", "job-b", "3", "failed")
+	put("2024-01-15T04:00:00Z", "job-c", "4", "success")
+	// day two
+	put("2024-01-16T01:00:00Z", "job-a", "5", "success")
+	put("2024-01-16T02:00:00Z", "job-b", "6", "error")
+	put("2024-01-16T03:00:00Z", "job-c", "7", "success")
+	// outside the window
+	put("2024-01-14T23:59:59Z", "job-c", "0", "failed")
+	put("2024-01-17T00:00:01Z", "job-c", "8", "failed")
+
+	from := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 17, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		minSamples int
+		expect     []FailingJobSummary
+	}{
+		{
+			name: "all jobs",
+			expect: []FailingJobSummary{
+				{JobName: "job-b", Total: 2, Failed: 2, FailureRate: 1},
+				{JobName: "job-a", Total: 3, Failed: 1, FailureRate: 1.0 / 3},
+				{JobName: "job-c", Total: 2, Failed: 0, FailureRate: 0},
+			},
+		},
+		{
+			name:       "minimum samples",
+			minSamples: 3,
+			expect: []FailingJobSummary{
+				{JobName: "job-a", Total: 3, Failed: 1, FailureRate: 1.0 / 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summaries, err := ListFailingJobs(context.TODO(), client, "bucket", from, to, tt.minSamples)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(summaries, tt.expect) {
+				t.Errorf("unexpected summaries:\n%#v\n%#v", summaries, tt.expect)
+			}
+		})
+	}
+}
+
+func TestLargestArtifacts(t *testing.T) {
+	client := NewFakeGCSClient()
+	for i, size := range []string{"100", "5000", "", "300", "2000"} {
+		metadata := map[string]string{"link": fmt.Sprintf("gs://bucket/logs/job/%d", i)}
+		if size != "" {
+			metadata["artifact-size-bytes"] = size
+		}
+		client.put("bucket", fmt.Sprintf("index/job-state/2024-01-15T12:00:0%dZ/job/%d", i, i), nil, metadata)
+	}
+	results, err := LargestArtifacts(context.TODO(), client, "bucket", "2024-01-15", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int64
+	for _, r := range results {
+		sizes = append(sizes, r.ArtifactSize)
+	}
+	if !reflect.DeepEqual(sizes, []int64{5000, 2000, 300}) {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+	if results[0].Link != "gs://bucket/logs/job/1" || results[0].JobName != "job" || results[0].BuildNumber != "1" {
+		t.Errorf("unexpected largest result %#v", results[0])
+	}
+}
+
+func TestListJobsByInfraCommit(t *testing.T) {
+	client := NewFakeGCSClient()
+	for i, commit := range []string{"aaaa", "bbbb", "", "aaaa"} {
+		metadata := map[string]string{"link": fmt.Sprintf("gs://bucket/logs/job/%d", i)}
+		if commit != "" {
+			metadata["infra-commit"] = commit
+		}
+		client.put("bucket", fmt.Sprintf("index/job-state/2024-01-15T12:00:0%dZ/job/%d", i, i), nil, metadata)
+	}
+	results, err := ListJobsByInfraCommit(context.TODO(), client, "bucket", "2024-01-15", "aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, r := range results {
+		links = append(links, r.Link)
+	}
+	if expect := []string{"gs://bucket/logs/job/0", "gs://bucket/logs/job/3"}; !reflect.DeepEqual(links, expect) {
+		t.Errorf("unexpected results %v", links)
+	}
+}
+
+func TestListJobsByFeatureFlag(t *testing.T) {
+	client := NewFakeGCSClient()
+	for i, flags := range []map[string]bool{
+		{"GatewayAPI": true},
+		{"GatewayAPI": false, "NodeSwap": true},
+		nil,
+		{"GatewayAPI": true, "NodeSwap": false},
+	} {
+		metadata := map[string]string{"link": fmt.Sprintf("gs://bucket/logs/job/%d", i)}
+		for name, enabled := range flags {
+			metadata[featureFlagMetadataPrefix+name] = strconv.FormatBool(enabled)
+		}
+		client.put("bucket", fmt.Sprintf("index/job-state/2024-01-15T12:00:0%dZ/job/%d", i, i), nil, metadata)
+	}
+	for _, tt := range []struct {
+		flag    string
+		enabled bool
+		expect  []string
+	}{
+		{flag: "GatewayAPI", enabled: true, expect: []string{"gs://bucket/logs/job/0", "gs://bucket/logs/job/3"}},
+		{flag: "GatewayAPI", enabled: false, expect: []string{"gs://bucket/logs/job/1"}},
+		{flag: "NodeSwap", enabled: true, expect: []string{"gs://bucket/logs/job/1"}},
+		{flag: "Missing", enabled: false},
+	} {
+		results, err := ListJobsByFeatureFlag(context.TODO(), client, "bucket", "2024-01-15", tt.flag, tt.enabled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var links []string
+		for _, r := range results {
+			if r.FeatureFlags[tt.flag] != tt.enabled {
+				t.Errorf("unexpected feature flags in %#v", r)
+			}
+			links = append(links, r.Link)
+		}
+		if !reflect.DeepEqual(links, tt.expect) {
+			t.Errorf("%s=%t: unexpected results %v", tt.flag, tt.enabled, links)
+		}
+	}
+}
+
+func TestListJobsByVersion(t *testing.T) {
+	client := NewFakeGCSClient()
+	for i, version := range []string{"4.15.3", "4.15.30", "", "4.15.3"} {
+		metadata := map[string]string{"link": fmt.Sprintf("gs://bucket/logs/job/%d", i)}
+		if version != "" {
+			metadata["openshift-version"] = version
+		}
+		client.put("bucket", fmt.Sprintf("index/job-state/2024-01-15T12:00:0%dZ/job/%d", i, i), nil, metadata)
+	}
+	results, err := ListJobsByVersion(context.TODO(), client, "bucket", "2024-01-15", "4.15.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, r := range results {
+		if r.OpenShiftVersion != "4.15.3" {
+			t.Errorf("unexpected version in %#v", r)
+		}
+		links = append(links, r.Link)
+	}
+	if expect := []string{"gs://bucket/logs/job/0", "gs://bucket/logs/job/3"}; !reflect.DeepEqual(links, expect) {
+		t.Errorf("unexpected results %v", links)
+	}
+}
+
+func TestListIndexDates(t *testing.T) {
+	client := &countingClient{FakeGCSClient: NewFakeGCSClient()}
+	for _, key := range []string{
+		"2024-01-14T23:59:59Z",
+		"2024-01-15T00:00:00Z",
+		"2024-01-15T12:00:00Z",
+		"2024-01-15T12:00:00Z",
+		"2024-01-16T08:30:00Z",
+		"2024-01-17T00:00:01Z",
+	} {
+		for i := 0; i < 3; i++ {
+			client.put("bucket", fmt.Sprintf("index/job-state/%s/job-%d/%d", key, i, i), nil, nil)
+		}
+	}
+	client.put("bucket", "index/job-state/2024-01-15-invalid/job/1", nil, nil)
+	client.put("bucket", "index/job-metrics/2024-01-15T13:00:00Z/job/1", nil, nil)
+
+	from := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 17, 0, 0, 0, 0, time.UTC)
+	dates, err := ListIndexDates(context.TODO(), client, "bucket", "job-state", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []time.Time{
+		time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 16, 8, 30, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(dates, expect) {
+		t.Errorf("unexpected dates %v", dates)
+	}
+	if client.lists != 3 {
+		t.Errorf("expected one listing per day, got %d", client.lists)
+	}
+}
+
+func TestListJobsByBranch(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/pull-ci-openshift-origin-master-unit/1000/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", "logs/pull-ci-openshift-origin-release-4.16-unit/1001/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", "logs/release-openshift-origin-installer-e2e-aws-upgrade/1002/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	for _, name := range []string{
+		"logs/pull-ci-openshift-origin-master-unit/1000/finished.json",
+		"logs/pull-ci-openshift-origin-release-4.16-unit/1001/finished.json",
+		"logs/release-openshift-origin-installer-e2e-aws-upgrade/1002/finished.json",
+	} {
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if obj := client.get("bucket", "index/job-state/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1002"); obj == nil {
+		t.Fatal("expected index entry")
+	} else if _, ok := obj.attrs.Metadata["branch"]; ok {
+		t.Errorf("expected no branch for an unknown job name: %v", obj.attrs.Metadata)
+	}
+	results, err := ListJobsByBranch(context.TODO(), client, "bucket", "2024-01-15", "release-4.16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Link != "gs://bucket/logs/pull-ci-openshift-origin-release-4.16-unit/1001" || results[0].Branch != "release-4.16" {
+		t.Errorf("unexpected results %#v", results)
+	}
+}
+
+func TestFindBuildByID(t *testing.T) {
+	client := NewFakeGCSClient()
+	job := "periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn"
+	// created at 2021-03-02T11:46:30Z
+	build := "1366716541889941504"
+	client.put("bucket", path.Join("index", "job-state", "2021-03-03T01:00:00Z", job, build), nil, map[string]string{
+		"link":  "gs://bucket/logs/" + job + "/" + build,
+		"state": "failure",
+	})
+	// outside of the window searched
+	client.put("bucket", path.Join("index", "job-state", "2021-03-10T01:00:00Z", job, "1366716541889941505"), nil, map[string]string{
+		"link": "gs://bucket/logs/" + job + "/1366716541889941505",
+	})
+
+	result, ok, err := FindBuildByID(context.TODO(), client, "bucket", job, build)
+	if err != nil || !ok {
+		t.Fatalf("unexpected result: %t %v", ok, err)
+	}
+	if result.State != "failure" || result.Link != "gs://bucket/logs/"+job+"/"+build || result.JobName != job || string(result.BuildNumber) != build {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	if _, ok, err := FindBuildByID(context.TODO(), client, "bucket", job, "1366716541889941505"); err != nil || ok {
+		t.Errorf("expected build outside the window not to be found: %t %v", ok, err)
+	}
+	if _, _, err := FindBuildByID(context.TODO(), client, "bucket", job, "1234"); err == nil {
+		t.Error("expected an error for a build number that is not a Snowflake ID")
+	}
+}
+
+func TestListJobsByVariant(t *testing.T) {
+	client := NewFakeGCSClient()
+	jobs := []string{
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-gcp-ovn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-sdn",
+		"periodic-ci-openshift-release-master-nightly-4.15-e2e-azure",
+	}
+	opts := DefaultOptions()
+	opts.Client = client
+	for i, job := range jobs {
+		name := fmt.Sprintf("logs/%s/%d/finished.json", job, 1000+i)
+		client.put("bucket", name, []byte(`{"timestamp":1705320000,"passed":false}`), nil)
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := ListJobsByVariant(context.TODO(), client, "bucket", "ovn", "2024-01-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, r := range results {
+		if r.State != "failed" {
+			t.Errorf("unexpected result: %#v", r)
+		}
+		links = append(links, r.Link)
+	}
+	sort.Strings(links)
+	expect := []string{
+		"gs://bucket/logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-aws-ovn/1000",
+		"gs://bucket/logs/periodic-ci-openshift-release-master-nightly-4.15-e2e-gcp-ovn/1001",
+	}
+	if !reflect.DeepEqual(links, expect) {
+		t.Errorf("unexpected results:\n%v\n%v", links, expect)
+	}
+	if results, err := ListJobsByVariant(context.TODO(), client, "bucket", "ovn", "2024-01-16"); err != nil || len(results) != 0 {
+		t.Errorf("expected no results on another day: %v %v", results, err)
+	}
+}
+
+func TestIndexLayout(t *testing.T) {
+	client := NewFakeGCSClient()
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.IndexVersion = "v2"
+	opts.LegacyIndexPath = false
+	opts.SkipArtifactSizeCalculation = true
+	for _, job := range []string{"periodic-ci-openshift-release-master-e2e-aws", "pull-ci-openshift-origin-master-e2e-gcp"} {
+		name := "logs/" + job + "/1000/finished.json"
+		client.put("bucket", name, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := listJobStates(context.TODO(), client, "bucket", opts.layout(), "2024-01-15")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected both job types to be listed, got %d: %v", len(entries), err)
+	}
+	dates, err := listIndexDates(context.TODO(), client, "bucket", opts.layout(), "job-state", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC))
+	if err != nil || len(dates) != 1 {
+		t.Errorf("expected a single key across job types, got %v: %v", dates, err)
+	}
+	report, err := HealIndex(context.TODO(), client, "bucket", "2024-01-15", func(o *Options) { *o = opts })
+	if err != nil || report.Checked != 2 || report.Healthy != 2 {
+		t.Errorf("expected the entries to be healed in the layout of the options, got %#v: %v", report, err)
+	}
+	// stale entries are repaired in the same layout, from compressed builds too
+	client.put("bucket", "logs/periodic-ci-openshift-release-master-e2e-aws/1000/finished.json", gzipData(t, `{"timestamp":1705320000,"passed":false}`), nil)
+	report, err = HealIndex(context.TODO(), client, "bucket", "2024-01-15", func(o *Options) { *o = opts })
+	if err != nil || report.Checked != 2 || report.Healthy != 1 || report.Repaired != 1 {
+		t.Errorf("expected the stale entry to be repaired, got %#v: %v", report, err)
+	}
+	if obj := client.get("bucket", "index/v2/periodic/job-state/2024-01-15T12:00:00Z/periodic-ci-openshift-release-master-e2e-aws/1000"); obj == nil || obj.attrs.Metadata["state"] != "failed" {
+		t.Errorf("expected the v2 entry to be repaired, got %v", obj)
+	}
+	if objects, _ := client.List(context.TODO(), "bucket", &storage.Query{Prefix: "index/job-state/"}); len(objects) != 0 {
+		t.Errorf("expected no entries outside of the v2 layout, got %d", len(objects))
+	}
+
+	indexer, err := NewGCSBucketIndexer(context.TODO(), "bucket", func(o *Options) { *o = opts })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.DailySummary(context.TODO(), "2024-01-15"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := client.get("bucket", "index/v2/daily-summary/2024-01-15"); obj == nil || obj.attrs.Metadata["total"] != "2" {
+		t.Errorf("expected a summary of both entries in the v2 index, got %v", obj)
+	}
+
+	// the exported queries read the layout of DefaultOptions only
+	results, err := ListJobsByPattern(context.TODO(), client, "bucket", "2024-01-15", regexp.MustCompile(".*"))
+	if err != nil || len(results) != 0 {
+		t.Errorf("expected no results outside the default layout, got %v: %v", results, err)
+	}
+}
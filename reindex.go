@@ -0,0 +1,114 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/mod/semver"
+)
+
+// IndexerVersion is recorded in the indexer-version metadata of every index
+// entry so that entries written by a buggy release can be found and
+// reindexed. Set it at build time with
+//
+//	-ldflags "-X github.com/openshift/ci-search-functions.IndexerVersion=v1.2.3"
+var IndexerVersion = "v1.1.0"
+
+// ReindexByVersion calls handler with a finished.json event for the build
+// linked from each index entry under indexPrefix that was written by an
+// indexer older than beforeVersion. Entries without a valid indexer-version
+// predate versioning and are always reindexed. The handler must be able to
+// replace existing entries. It returns the number of entries reindexed.
+func ReindexByVersion(ctx context.Context, client StorageClient, bucket, indexPrefix, beforeVersion string, handler HandlerFunc) (int, error) {
+	if !semver.IsValid(beforeVersion) {
+		return 0, fmt.Errorf("%q is not a valid semantic version", beforeVersion)
+	}
+	objects, err := client.List(ctx, bucket, &storage.Query{Prefix: indexPrefix})
+	if err != nil {
+		return 0, fmt.Errorf("unable to list %s: %v", indexPrefix, err)
+	}
+	var count int
+	for _, attrs := range objects {
+		if semver.Compare(attrs.Metadata["indexer-version"], beforeVersion) >= 0 {
+			continue
+		}
+		e, err := finishedEventFromLink(attrs.Metadata["link"])
+		if err != nil {
+			return count, fmt.Errorf("unable to reindex %s: %v", attrs.Name, err)
+		}
+		if err := handler(ctx, e); err != nil {
+			return count, fmt.Errorf("unable to reindex %s: %v", attrs.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// finishedEventFromLink synthesizes the event for the finished.json of the
+// build at a gs:// link recorded in an index entry.
+func finishedEventFromLink(link string) (GCSEvent, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return GCSEvent{}, err
+	}
+	if u.Scheme != "gs" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return GCSEvent{}, fmt.Errorf("%q is not a gs:// link to a build", link)
+	}
+	return GCSEvent{
+		Bucket: u.Host,
+		Name:   path.Join(strings.TrimPrefix(u.Path, "/"), "finished.json"),
+	}, nil
+}
+
+// ReprocessFailedIndexEntry replaces a corrupt or incomplete job-state index
+// entry by indexing the build it links to again with opts, overwriting the
+// index entries of the build. The entry is only deleted if the build is now
+// indexed under another key. The entry must exist, have a link and the
+// linked build must have a finished.json that is indexed rather than skipped.
+func ReprocessFailedIndexEntry(ctx context.Context, client StorageClient, bucket, indexPath string, opts ...func(*Options)) error {
+	if !strings.Contains("/"+indexPath, "/job-state/") {
+		return fmt.Errorf("%s is not a job-state index entry", indexPath)
+	}
+	attrs, err := client.Attrs(ctx, bucket, indexPath)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", indexPath, err)
+	}
+	link, ok := attrs.Metadata["link"]
+	if !ok {
+		return fmt.Errorf("%s has no link", indexPath)
+	}
+	e, err := finishedEventFromLink(link)
+	if err != nil {
+		return fmt.Errorf("unable to reprocess %s: %v", indexPath, err)
+	}
+	if _, err := client.Attrs(ctx, e.Bucket, e.Name); err != nil {
+		return fmt.Errorf("unable to reprocess %s from %s: %v", indexPath, e.Name, err)
+	}
+	o := DefaultOptions()
+	for _, fn := range opts {
+		fn(&o)
+	}
+	o.Client = client
+	o.Overwrite = true
+	result := IndexJobsWithResult(ctx, e, o)
+	if result.Err != nil {
+		return fmt.Errorf("unable to reprocess %s: %v", indexPath, result.Err)
+	}
+	if result.IsSkipped {
+		return fmt.Errorf("unable to reprocess %s: %s", indexPath, result.SkipReason)
+	}
+	// the completion time of the build may have changed, in which case the
+	// entry was not overwritten
+	current, err := client.Attrs(ctx, bucket, indexPath)
+	if err == nil && current.Generation == attrs.Generation {
+		err = client.Delete(ctx, bucket, indexPath)
+	}
+	if err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("unable to remove the replaced entry %s: %v", indexPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestReindexByVersion(t *testing.T) {
+	client := NewFakeGCSClient()
+	for build, version := range map[string]string{
+		"1": "v0.9.0",
+		"2": "v1.0.0",
+		"3": "v1.1.0",
+		"4": "",
+		"5": "not-a-version",
+	} {
+		metadata := map[string]string{"link": "gs://bucket/logs/job/" + build}
+		if version != "" {
+			metadata["indexer-version"] = version
+		}
+		client.put("bucket", "index/job-state/2024-01-15T12:00:00Z/job/"+build, nil, metadata)
+	}
+
+	var reindexed []string
+	count, err := ReindexByVersion(context.TODO(), client, "bucket", "index/job-state/", "v1.1.0", func(ctx context.Context, e GCSEvent) error {
+		if e.Bucket != "bucket" {
+			t.Errorf("unexpected bucket %q", e.Bucket)
+		}
+		reindexed = append(reindexed, e.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(reindexed)
+	expect := []string{"logs/job/1/finished.json", "logs/job/2/finished.json", "logs/job/4/finished.json", "logs/job/5/finished.json"}
+	if count != len(expect) || !reflect.DeepEqual(reindexed, expect) {
+		t.Errorf("unexpected reindexed events %d %v", count, reindexed)
+	}
+
+	if _, err := ReindexByVersion(context.TODO(), client, "bucket", "index/job-state/", "1.1", nil); err == nil {
+		t.Error("expected an invalid version to be rejected")
+	}
+}
+
+func TestIndexJobsWithOptions_IndexerVersion(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Client = NewFakeGCSClient()
+	if obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true}`); obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+		t.Errorf("expected indexer-version %s on index entry: %#v", IndexerVersion, obj)
+	}
+}
+
+func TestReprocessFailedIndexEntry(t *testing.T) {
+	newClient := func() *FakeGCSClient {
+		client := NewFakeGCSClient()
+		client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+		client.put("bucket", testStatePath, []byte(`{"sta`), map[string]string{"link": "gs://bucket/logs/job/1000"})
+		client.put("bucket", "index/unknown/job-state/2024-01-15T12:00:00Z/job/1000", []byte(`{}`), nil)
+		return client
+	}
+
+	client := newClient()
+	if err := ReprocessFailedIndexEntry(context.TODO(), client, "bucket", testStatePath); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{testStatePath, "index/unknown/job-state/2024-01-15T12:00:00Z/job/1000"} {
+		obj := client.get("bucket", name)
+		if obj == nil {
+			t.Fatalf("expected %s to be rewritten", name)
+		}
+		var result JobResult
+		if err := json.Unmarshal(obj.data, &result); err != nil || result.State != "success" {
+			t.Errorf("unexpected content of %s: %s", name, obj.data)
+		}
+		if obj.attrs.Metadata["state"] != "success" {
+			t.Errorf("unexpected metadata of %s: %v", name, obj.attrs.Metadata)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		modify func(client *FakeGCSClient)
+	}{
+		{name: "missing entry", path: "index/job-state/2024-01-15T12:00:00Z/job/1001"},
+		{name: "not a job-state entry", path: "index/job-metrics/2024-01-15T12:00:00Z/job/1000", modify: func(client *FakeGCSClient) {
+			client.put("bucket", "index/job-metrics/2024-01-15T12:00:00Z/job/1000", nil, map[string]string{"link": "gs://bucket/logs/job/1000"})
+		}},
+		{name: "no link", path: testStatePath, modify: func(client *FakeGCSClient) {
+			client.put("bucket", testStatePath, nil, nil)
+		}},
+		{name: "invalid link", path: testStatePath, modify: func(client *FakeGCSClient) {
+			client.put("bucket", testStatePath, nil, map[string]string{"link": "https://example.com/job/1000"})
+		}},
+		{name: "no finished.json", path: testStatePath, modify: func(client *FakeGCSClient) {
+			client.Delete(context.TODO(), "bucket", testFinishedName)
+		}},
+		{name: "build skipped", path: testStatePath, modify: func(client *FakeGCSClient) {
+			client.put("bucket", testFinishedName, []byte(`{"passed":true}`), nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient()
+			if tt.modify != nil {
+				tt.modify(client)
+			}
+			if err := ReprocessFailedIndexEntry(context.TODO(), client, "bucket", tt.path); err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.path == testStatePath && client.get("bucket", testStatePath) == nil {
+				t.Error("expected the entry not to be deleted")
+			}
+		})
+	}
+}
+
+func TestReprocessFailedIndexEntry_Options(t *testing.T) {
+	const v2Path = "index/v2/job-state/2024-01-15T12:00:00Z/job/1000"
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", v2Path, []byte(`{"sta`), map[string]string{"link": "gs://bucket/logs/job/1000"})
+	if err := ReprocessFailedIndexEntry(context.TODO(), client, "bucket", v2Path, func(o *Options) { o.IndexVersion = "v2" }); err != nil {
+		t.Fatal(err)
+	}
+	if obj := client.get("bucket", v2Path); obj == nil || obj.attrs.Metadata["state"] != "success" {
+		t.Errorf("expected the v2 entry to be rewritten, got %v", obj)
+	}
+	if client.get("bucket", testStatePath) != nil {
+		t.Error("expected no v1 entry")
+	}
+
+	// an entry under a stale key is replaced by that of the build
+	const stalePath = "index/job-state/2024-01-15T11:00:00Z/job/1000"
+	client.put("bucket", stalePath, []byte(`{}`), map[string]string{"link": "gs://bucket/logs/job/1000"})
+	if err := ReprocessFailedIndexEntry(context.TODO(), client, "bucket", stalePath); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", stalePath) != nil || client.get("bucket", testStatePath) == nil {
+		t.Error("expected the build to be indexed under its completion time only")
+	}
+}
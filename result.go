@@ -0,0 +1,113 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Result describes the outcome of indexing a single event. See
+// IndexJobsWithResult.
+type Result struct {
+	// Stats holds the details of indexing the event, such as the metrics
+	// that were skipped as stale.
+	*Stats
+	// Err is the error that prevented the event from being indexed. It is
+	// set for duplicates, as IndexJobsWithOptions returns them.
+	Err error
+	// IsDuplicate is true if the event had already been indexed.
+	IsDuplicate bool
+	// IsSkipped is true if the event was intentionally not indexed, such as
+	// because the job did not pass the JobKindFilter.
+	IsSkipped bool
+	// SkipReason explains why the event was skipped.
+	SkipReason string
+	// DLQPath is the name of the object the failed event was written to
+	// under Options.DeadLetterPrefix, if any.
+	DLQPath string
+}
+
+// skipKey identifies the skip reason of the event being indexed.
+type skipKey struct{}
+
+// skipEvent records in ctx, if it was created by IndexJobsWithResult, that
+// the event was not indexed for reason.
+func skipEvent(ctx context.Context, reason string) {
+	if skipped, ok := ctx.Value(skipKey{}).(*string); ok && *skipped == "" {
+		*skipped = reason
+	}
+}
+
+// deadLetter is the content of the objects written under
+// Options.DeadLetterPrefix.
+type deadLetter struct {
+	Event    GCSEvent  `json:"event"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// IndexJobsWithResult indexes the object described by e as
+// IndexJobsWithOptions does and describes the outcome. If ctx was returned
+// by WithStats its Stats are used, otherwise new ones are recorded. Events
+// that fail for reasons other than already being indexed are written to
+// Options.DeadLetterPrefix when it is set.
+func IndexJobsWithResult(ctx context.Context, e GCSEvent, opts Options) Result {
+	stats := statsFromContext(ctx)
+	if stats == nil {
+		stats = &Stats{}
+		ctx = WithStats(ctx, stats)
+	}
+	var skipped string
+	ctx = context.WithValue(ctx, skipKey{}, &skipped)
+
+	result := Result{Stats: stats}
+	result.Err = newIndexRouter(opts).Dispatch(ctx, e)
+	switch {
+	case result.Err == nil:
+		result.IsSkipped = skipped != ""
+		result.SkipReason = skipped
+	case IsPreconditionFailure(result.Err):
+		result.IsDuplicate = true
+	case opts.DeadLetterPrefix != "":
+		name, err := writeDeadLetter(ctx, e, result.Err, opts)
+		if err != nil {
+			log.Printf("error: Unable to write %s to the dead letter queue: %v", e.Name, err)
+		}
+		result.DLQPath = name
+	}
+	return result
+}
+
+// writeDeadLetter records the failure to index e under
+// Options.DeadLetterPrefix in the bucket of the event and returns the name
+// of the object.
+func writeDeadLetter(ctx context.Context, e GCSEvent, indexErr error, opts Options) (string, error) {
+	client, err := opts.storageClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	data, err := json.Marshal(deadLetter{Event: e, Error: indexErr.Error(), FailedAt: now})
+	if err != nil {
+		return "", fmt.Errorf("could not serialize event: %v", err)
+	}
+	name := path.Join(opts.DeadLetterPrefix, e.Name, strconv.FormatInt(now.UnixNano(), 10))
+	w := client.NewWriter(ctx, e.Bucket, name, storage.ObjectAttrs{
+		ContentType: "application/json",
+		Metadata:    map[string]string{"error": indexErr.Error()},
+	}, nil)
+	if _, err := w.Write(data); err != nil {
+		closeFailedWriter(w, name)
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,118 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIndexJobsWithResult(t *testing.T) {
+	const finished = `{"timestamp":1705320000,"passed":true}`
+	tests := []struct {
+		name      string
+		objects   map[string]string
+		event     string
+		filter    []string
+		duplicate bool
+		skip      string
+		dlq       bool
+	}{
+		{
+			name:    "success",
+			objects: map[string]string{testFinishedName: finished},
+			event:   testFinishedName,
+		},
+		{
+			name:    "no handler",
+			objects: map[string]string{"logs/job/1000/build-log.txt": ``},
+			event:   "logs/job/1000/build-log.txt",
+			skip:    "no handler for build-log.txt",
+		},
+		{
+			name:    "filtered job",
+			objects: map[string]string{testFinishedName: finished},
+			event:   testFinishedName,
+			filter:  []string{"release"},
+			skip:    "job excluded by the job kind filter",
+		},
+		{
+			name:    "unfinished job",
+			objects: map[string]string{testFinishedName: `{"passed":true}`},
+			event:   testFinishedName,
+			skip:    "job has not finished",
+		},
+		{
+			name: "duplicate",
+			objects: map[string]string{
+				testFinishedName: finished,
+				testStatePath:    `{}`,
+			},
+			event:     testFinishedName,
+			duplicate: true,
+		},
+		{
+			name:    "dead letter",
+			objects: map[string]string{testFinishedName: `{"timestamp":`},
+			event:   testFinishedName,
+			dlq:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			for name, data := range tt.objects {
+				client.put("bucket", name, []byte(data), nil)
+			}
+			opts := DefaultOptions()
+			opts.Client = client
+			opts.JobKindFilter = tt.filter
+			opts.DeadLetterPrefix = "dead-letter"
+			result := IndexJobsWithResult(context.TODO(), GCSEvent{Bucket: "bucket", Name: tt.event}, opts)
+
+			if result.Stats == nil {
+				t.Error("expected stats to be recorded")
+			}
+			if (result.Err != nil) != (tt.duplicate || tt.dlq) {
+				t.Errorf("unexpected error: %v", result.Err)
+			}
+			if result.IsDuplicate != tt.duplicate {
+				t.Errorf("expected IsDuplicate=%t", tt.duplicate)
+			}
+			if result.IsSkipped != (tt.skip != "") || result.SkipReason != tt.skip {
+				t.Errorf("unexpected skip %t %q", result.IsSkipped, result.SkipReason)
+			}
+			if (result.DLQPath != "") != tt.dlq {
+				t.Fatalf("unexpected dead letter %q", result.DLQPath)
+			}
+			if tt.dlq {
+				if !strings.HasPrefix(result.DLQPath, "dead-letter/"+tt.event+"/") {
+					t.Errorf("unexpected dead letter path %s", result.DLQPath)
+				}
+				obj := client.get("bucket", result.DLQPath)
+				if obj == nil {
+					t.Fatal("expected the event to be written to the dead letter queue")
+				}
+				var letter deadLetter
+				if err := json.Unmarshal(obj.data, &letter); err != nil {
+					t.Fatal(err)
+				}
+				if letter.Event.Name != tt.event || letter.Error != result.Err.Error() {
+					t.Errorf("unexpected dead letter %s", obj.data)
+				}
+			}
+			indexed := client.get("bucket", testStatePath) != nil
+			if indexed != (tt.skip == "" && !tt.dlq) {
+				t.Errorf("expected indexed=%t", !indexed)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithResult_Stats(t *testing.T) {
+	stats := &Stats{}
+	result := IndexJobsWithResult(WithStats(context.TODO(), stats), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/build-log.txt"}, Options{Client: NewFakeGCSClient()})
+	if result.Stats != stats {
+		t.Error("expected the stats of the context to be used")
+	}
+}
@@ -0,0 +1,76 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gcsRetryAttempts is how many times the handlers try each GCS read and
+// write before giving up on a transient error.
+const gcsRetryAttempts = 4
+
+// maxGCSRetryDelay caps the backoff between attempts.
+const maxGCSRetryDelay = 30 * time.Second
+
+// gcsRetryDelay is the backoff before the second attempt, which doubles for
+// each later attempt.
+var gcsRetryDelay = 500 * time.Millisecond
+
+// retryGCS calls fn up to attempts times while it returns a transient GCS
+// error, waiting a jittered exponential backoff between attempts. Other
+// errors, and the last error once attempts are exhausted or ctx is done,
+// are returned unchanged.
+func retryGCS(ctx context.Context, attempts int, fn func() error) error {
+	delay := gcsRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !isTransientGCSError(err) {
+			return err
+		}
+		// wait between half and all of the delay so that retries spread out
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxGCSRetryDelay {
+			delay = maxGCSRetryDelay
+		}
+	}
+}
+
+// isTransientGCSError returns true if err is likely to succeed when
+// retried, such as rate limiting, server errors and dropped connections.
+func isTransientGCSError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.OK {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.DeadlineExceeded:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) || (errors.As(err, &netErr) && netErr.Timeout())
+}
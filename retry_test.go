@@ -0,0 +1,100 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingN returns a function that fails with err the first n times it is
+// called and succeeds afterwards, and a pointer to the number of calls.
+func failingN(n int, err error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= n {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func shortRetryDelay(t *testing.T) {
+	delay := gcsRetryDelay
+	gcsRetryDelay = time.Millisecond
+	t.Cleanup(func() { gcsRetryDelay = delay })
+}
+
+func TestRetryGCS(t *testing.T) {
+	shortRetryDelay(t)
+	unavailable := &googleapi.Error{Code: 503}
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		attempts  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "success", failures: 0, err: unavailable, attempts: 4, wantCalls: 1},
+		{name: "transient then success", failures: 3, err: unavailable, attempts: 4, wantCalls: 4},
+		{name: "rate limited", failures: 1, err: &googleapi.Error{Code: 429}, attempts: 4, wantCalls: 2},
+		{name: "grpc unavailable", failures: 2, err: status.Error(codes.Unavailable, "unavailable"), attempts: 4, wantCalls: 3},
+		{name: "wrapped transient", failures: 1, err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), attempts: 4, wantCalls: 2},
+		{name: "attempts exhausted", failures: 5, err: unavailable, attempts: 4, wantCalls: 4, wantErr: true},
+		{name: "not found", failures: 1, err: storage.ErrObjectNotExist, attempts: 4, wantCalls: 1, wantErr: true},
+		{name: "forbidden", failures: 1, err: &googleapi.Error{Code: 403}, attempts: 4, wantCalls: 1, wantErr: true},
+		{name: "precondition failed", failures: 1, err: &googleapi.Error{Code: 412}, attempts: 4, wantCalls: 1, wantErr: true},
+		{name: "grpc not found", failures: 1, err: status.Error(codes.NotFound, "not found"), attempts: 4, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, calls := failingN(tt.failures, tt.err)
+			err := retryGCS(context.TODO(), tt.attempts, fn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr && !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("expected %d calls, got %d", tt.wantCalls, *calls)
+			}
+		})
+	}
+}
+
+func TestRetryGCS_ContextDone(t *testing.T) {
+	shortRetryDelay(t)
+	gcsRetryDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	fn, calls := failingN(5, &googleapi.Error{Code: 503})
+	if err := retryGCS(ctx, gcsRetryAttempts, fn); err == nil {
+		t.Error("expected an error")
+	}
+	if *calls != 1 {
+		t.Errorf("expected no retries once the context is done, got %d calls", *calls)
+	}
+}
+
+func TestIsTransientGCSError(t *testing.T) {
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, errors.New("invalid"), &googleapi.Error{Code: 400}} {
+		if isTransientGCSError(err) {
+			t.Errorf("%v: expected a permanent error", err)
+		}
+	}
+	for _, err := range []error{&googleapi.Error{Code: 500}, &googleapi.Error{Code: 502}, &googleapi.Error{Code: 504}, status.Error(codes.ResourceExhausted, "quota")} {
+		if !isTransientGCSError(err) {
+			t.Errorf("%v: expected a transient error", err)
+		}
+	}
+}
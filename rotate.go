@@ -0,0 +1,100 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// DefaultDedupRotationInterval is how often IndexJobsServer rotates the
+// deduplication index when Options.DedupRotationInterval is not set.
+const DefaultDedupRotationInterval = 24 * time.Hour
+
+// batchDeleter is implemented by clients that can delete many objects in a
+// single request.
+type batchDeleter interface {
+	DeleteObjects(ctx context.Context, bucket string, names []string) error
+}
+
+// RotateIndexKey deletes the objects under prefix that were created more
+// than keepWindow ago, such as the MD5 sentinels of the deduplication index,
+// and returns how many were deleted. Objects that were already deleted are
+// ignored.
+func RotateIndexKey(ctx context.Context, client StorageClient, bucket, prefix string, keepWindow time.Duration) (int, error) {
+	objects, err := client.List(ctx, bucket, &storage.Query{Prefix: prefix})
+	if err != nil {
+		return 0, fmt.Errorf("unable to list %s: %v", prefix, err)
+	}
+	cutoff := time.Now().Add(-keepWindow)
+	var stale []string
+	for _, attrs := range objects {
+		if attrs.Created.IsZero() || !attrs.Created.Before(cutoff) {
+			continue
+		}
+		stale = append(stale, attrs.Name)
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	if batch, ok := client.(batchDeleter); ok {
+		if err := batch.DeleteObjects(ctx, bucket, stale); err != nil {
+			return 0, fmt.Errorf("unable to delete %d objects under %s: %v", len(stale), prefix, err)
+		}
+		return len(stale), nil
+	}
+	deleted := 0
+	for _, name := range stale {
+		if err := client.Delete(ctx, bucket, name); err != nil {
+			if err == storage.ErrObjectNotExist {
+				continue
+			}
+			return deleted, fmt.Errorf("unable to delete %s: %v", name, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// rotateDedupIndex runs RotateIndexKey over the server's deduplication
+// index every Opts.DedupRotationInterval until ctx is done. Without
+// Opts.Client, a GCS client is created on the first rotation and closed when
+// ctx is done.
+func (s *IndexJobsServer) rotateDedupIndex(ctx context.Context) {
+	interval := s.Opts.DedupRotationInterval
+	if interval <= 0 {
+		interval = DefaultDedupRotationInterval
+	}
+	keepWindow := s.DedupKeepWindow
+	if keepWindow <= 0 {
+		keepWindow = interval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	client := s.Opts.Client
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if client == nil {
+			gcs, err := storage.NewClient(ctx, s.Opts.clientOptions()...)
+			if err != nil {
+				log.Printf("error: Unable to rotate gs://%s/%s: %v", s.DedupBucket, s.DedupPrefix, err)
+				continue
+			}
+			defer gcs.Close()
+			client = NewStorageClient(gcs)
+		}
+		deleted, err := RotateIndexKey(ctx, client, s.DedupBucket, s.DedupPrefix, keepWindow)
+		if err != nil {
+			log.Printf("error: Unable to rotate gs://%s/%s: %v", s.DedupBucket, s.DedupPrefix, err)
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d stale objects under gs://%s/%s", deleted, s.DedupBucket, s.DedupPrefix)
+		}
+	}
+}
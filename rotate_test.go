@@ -0,0 +1,99 @@
+package cisearch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func putSentinel(client *FakeGCSClient, name string, created time.Time) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.store(storage.ObjectAttrs{Bucket: "bucket", Name: name, Created: created}, nil)
+}
+
+func TestRotateIndexKey(t *testing.T) {
+	client := NewFakeGCSClient()
+	now := time.Now()
+	putSentinel(client, "index/dedup/fresh", now.Add(-time.Hour))
+	putSentinel(client, "index/dedup/stale", now.Add(-48*time.Hour))
+	putSentinel(client, "index/dedup/older", now.Add(-72*time.Hour))
+	putSentinel(client, "index/other/stale", now.Add(-72*time.Hour))
+
+	deleted, err := RotateIndexKey(context.TODO(), client, "bucket", "index/dedup/", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 objects to be deleted, got %d", deleted)
+	}
+	for name, exists := range map[string]bool{
+		"index/dedup/fresh": true,
+		"index/dedup/stale": false,
+		"index/dedup/older": false,
+		"index/other/stale": true,
+	} {
+		if (client.get("bucket", name) != nil) != exists {
+			t.Errorf("%s: expected exists=%t", name, exists)
+		}
+	}
+}
+
+type batchDeletingClient struct {
+	*FakeGCSClient
+	batches [][]string
+}
+
+func (c *batchDeletingClient) DeleteObjects(ctx context.Context, bucket string, names []string) error {
+	c.batches = append(c.batches, names)
+	for _, name := range names {
+		if err := c.Delete(ctx, bucket, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRotateIndexKey_Batch(t *testing.T) {
+	client := &batchDeletingClient{FakeGCSClient: NewFakeGCSClient()}
+	now := time.Now()
+	putSentinel(client.FakeGCSClient, "index/dedup/a", now.Add(-48*time.Hour))
+	putSentinel(client.FakeGCSClient, "index/dedup/b", now.Add(-48*time.Hour))
+	putSentinel(client.FakeGCSClient, "index/dedup/c", now)
+
+	deleted, err := RotateIndexKey(context.TODO(), client, "bucket", "index/dedup/", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 || len(client.batches) != 1 || len(client.batches[0]) != 2 {
+		t.Errorf("expected a single batch of 2 deletes, got %d deleted in %v", deleted, client.batches)
+	}
+}
+
+func TestIndexJobsServer_RotatesDedupIndex(t *testing.T) {
+	client := NewFakeGCSClient()
+	putSentinel(client, "index/dedup/stale", time.Now().Add(-time.Hour))
+	putSentinel(client, "index/dedup/fresh", time.Now().Add(time.Hour))
+
+	s := &IndexJobsServer{
+		DedupBucket:     "bucket",
+		DedupPrefix:     "index/dedup/",
+		DedupKeepWindow: time.Minute,
+		Opts:            Options{Client: client, DedupRotationInterval: time.Millisecond},
+	}
+	s.Start(context.Background())
+	defer s.Shutdown(time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.get("bucket", "index/dedup/stale") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("stale sentinel was not rotated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if client.get("bucket", "index/dedup/fresh") == nil {
+		t.Error("fresh sentinel was rotated")
+	}
+}
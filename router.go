@@ -0,0 +1,41 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+// Router dispatches events to handlers by the base name of the object.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	pattern string
+	handler HandlerFunc
+}
+
+// Handle registers handler for objects whose base name matches pattern, using
+// the syntax of path.Match. Routes are tried in the order they were
+// registered. Handle panics if pattern is malformed.
+func (r *Router) Handle(pattern string, handler HandlerFunc) *Router {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("invalid route pattern %q: %v", pattern, err))
+	}
+	r.routes = append(r.routes, route{pattern: pattern, handler: handler})
+	return r
+}
+
+// Dispatch calls the handler of the first route that matches e. Events that
+// match no route are ignored.
+func (r *Router) Dispatch(ctx context.Context, e GCSEvent) error {
+	base := path.Base(e.Name)
+	for _, route := range r.routes {
+		if ok, _ := path.Match(route.pattern, base); ok {
+			return route.handler(ctx, e)
+		}
+	}
+	skipEvent(ctx, "no handler for "+base)
+	return nil
+}
@@ -0,0 +1,73 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var called []string
+	handler := func(name string) HandlerFunc {
+		return func(ctx context.Context, e GCSEvent) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+	router := (&Router{}).
+		Handle("finished.json", handler("finished")).
+		Handle("junit*.xml", handler("junit")).
+		Handle("*.xml", handler("xml")).
+		Handle("*", handler("any"))
+
+	tests := []struct {
+		name   string
+		object string
+		expect string
+	}{
+		{name: "exact", object: "logs/job/1/finished.json", expect: "finished"},
+		{name: "first match wins", object: "logs/job/1/artifacts/junit_e2e.xml", expect: "junit"},
+		{name: "later match", object: "logs/job/1/artifacts/results.xml", expect: "xml"},
+		{name: "catch all", object: "logs/job/1/build-log.txt", expect: "any"},
+		{name: "pattern does not match directories", object: "logs/job/1/finished.json/other", expect: "any"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = nil
+			if err := router.Dispatch(context.TODO(), GCSEvent{Name: tt.object}); err != nil {
+				t.Fatal(err)
+			}
+			if len(called) != 1 || called[0] != tt.expect {
+				t.Errorf("expected %s to be handled by %s, got %v", tt.object, tt.expect, called)
+			}
+		})
+	}
+}
+
+func TestRouter_NoMatch(t *testing.T) {
+	router := (&Router{}).Handle("finished.json", func(ctx context.Context, e GCSEvent) error {
+		return errors.New("unexpected call")
+	})
+	if err := router.Dispatch(context.TODO(), GCSEvent{Name: "logs/job/1/started.json"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouter_HandlerError(t *testing.T) {
+	expect := errors.New("failed")
+	router := (&Router{}).Handle("*.json", func(ctx context.Context, e GCSEvent) error {
+		return expect
+	})
+	if err := router.Dispatch(context.TODO(), GCSEvent{Name: "logs/job/1/finished.json"}); err != expect {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRouter_InvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	(&Router{}).Handle("[", func(ctx context.Context, e GCSEvent) error { return nil })
+}
@@ -0,0 +1,186 @@
+package cisearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// shutdownTimeout is how long in-flight requests are given to complete after
+// the server receives SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
+// CloudRunMain serves the indexer over HTTP on the port named by the PORT
+// environment variable (8080 by default) until the process receives SIGTERM.
+// Events are accepted on POST /index, GET /health reports liveness and
+// GET /metrics exposes the Prometheus metrics of the indexer. The process
+// exits without serving if the configuration is invalid.
+func CloudRunMain(opts Options) {
+	config, err := IndexerConfigFromEnv(opts)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	mux, indexer := newServeMux(config.Options)
+	server := &http.Server{
+		Addr:    ":" + config.Port,
+		Handler: mux,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		log.Printf("Shutting down, waiting up to %s for requests to complete", shutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error: Unable to shut down cleanly: %v", err)
+		}
+		if err := indexer.Close(); err != nil {
+			log.Printf("error: Unable to close the GCS client: %v", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("error: Server failed: %v", err)
+	}
+	<-done
+}
+
+// newServeMux returns the routes served by CloudRunMain and the Indexer
+// that handles every event, which the caller must close once the server has
+// shut down.
+func newServeMux(opts Options) (*http.ServeMux, *Indexer) {
+	indexer := NewIndexer(opts)
+	mux := http.NewServeMux()
+	mux.Handle("POST /index", indexer.HTTPHandler())
+	mux.HandleFunc("GET /health", HealthCheckHandler)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	return mux, indexer
+}
+
+// pubSubPush is the body of a Pub/Sub push subscription request. The data of
+// a GCS notification is the JSON representation of the object.
+type pubSubPush struct {
+	Message *pubSubMessage `json:"message"`
+}
+
+// pubSubMessage is a Pub/Sub message. Data is base64 encoded in JSON.
+type pubSubMessage struct {
+	Data []byte `json:"data"`
+}
+
+// IndexJobsHTTPHandler indexes the GCS event in the body of each request.
+// The body may be either the object resource itself, as sent by Eventarc, or
+// a Pub/Sub push message carrying a GCS notification. Failures are reported
+// with a 5xx status so that the event is redelivered; jobs that are already
+// indexed are reported as success. If opts.Client is nil a GCS client is
+// created for each request; Indexer.HTTPHandler reuses one instead.
+func IndexJobsHTTPHandler(opts Options) http.Handler {
+	return eventHTTPHandler(func(ctx context.Context, e GCSEvent) error {
+		return IndexJobsWithOptions(ctx, e, opts)
+	})
+}
+
+// HTTPHandler returns a handler that indexes the GCS event in the body of
+// each request with HandleEvent, as IndexJobsHTTPHandler does.
+func (i *Indexer) HTTPHandler() http.Handler {
+	return eventHTTPHandler(i.HandleEvent)
+}
+
+// eventHTTPHandler decodes the GCS event of each request and passes it to
+// handle.
+func eventHTTPHandler(handle func(context.Context, GCSEvent) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, err := decodeHTTPEvent(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := handle(r.Context(), e); err != nil && !IsPreconditionFailure(err) {
+			log.Printf("error: Unable to index %s: %v", e.GSURI(), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// decodeHTTPEvent reads the GCS event from the body of r.
+func decodeHTTPEvent(r *http.Request) (GCSEvent, error) {
+	var e GCSEvent
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return e, fmt.Errorf("unable to read request: %v", err)
+	}
+	var push pubSubPush
+	if err := json.Unmarshal(data, &push); err != nil {
+		return e, fmt.Errorf("request is not a valid event: %v", err)
+	}
+	if push.Message != nil {
+		data = push.Message.Data
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, fmt.Errorf("request is not a valid event: %v", err)
+	}
+	if len(e.Bucket) == 0 || len(e.Name) == 0 {
+		return e, errors.New("event must have a bucket and name")
+	}
+	return e, nil
+}
+
+// ToHTTPRequest returns a request to url with e as its JSON body, as
+// accepted by IndexJobsHTTPHandler.
+func (e GCSEvent) ToHTTPRequest(method, url string) (*http.Request, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return newJSONRequest(method, url, data)
+}
+
+// ToPubSubHTTPRequest returns a request to url with e wrapped in a Pub/Sub
+// push message as its body, as accepted by IndexJobsHTTPHandler.
+func (e GCSEvent) ToPubSubHTTPRequest(method, url string) (*http.Request, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = json.Marshal(pubSubPush{Message: &pubSubMessage{Data: data}}); err != nil {
+		return nil, err
+	}
+	return newJSONRequest(method, url, data)
+}
+
+// newJSONRequest returns a request to url with the JSON body data.
+func newJSONRequest(method, url string, data []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// HealthCheckHandler reports that the server is able to handle requests.
+func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
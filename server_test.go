@@ -0,0 +1,156 @@
+package cisearch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeMux(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", "logs/job/1001/finished.json", []byte(`{"timestamp":1705320060,"passed":false}`), nil)
+	client.put("bucket", "logs/job/1002/finished.json", []byte(`not json`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	mux, indexer := newServeMux(opts)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer indexer.Close()
+
+	pubSub := func(object string) string {
+		return fmt.Sprintf(`{"message":{"data":%q},"subscription":"projects/p/subscriptions/s"}`, base64.StdEncoding.EncodeToString([]byte(object)))
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		index  string
+	}{
+		{
+			name: "health", method: "GET", path: "/health", status: http.StatusOK,
+		},
+		{
+			name: "metrics", method: "GET", path: "/metrics", status: http.StatusOK,
+		},
+		{
+			name: "event", method: "POST", path: "/index", status: http.StatusNoContent,
+			body:  `{"bucket":"bucket","name":"logs/job/1000/finished.json"}`,
+			index: testStatePath,
+		},
+		{
+			name: "already indexed", method: "POST", path: "/index", status: http.StatusNoContent,
+			body: `{"bucket":"bucket","name":"logs/job/1000/finished.json"}`,
+		},
+		{
+			name: "pub/sub event", method: "POST", path: "/index", status: http.StatusNoContent,
+			body:  pubSub(`{"bucket":"bucket","name":"logs/job/1001/finished.json"}`),
+			index: "index/job-state/2024-01-15T12:01:00Z/job/1001",
+		},
+		{
+			name: "invalid body", method: "POST", path: "/index", status: http.StatusBadRequest,
+			body: `[]`,
+		},
+		{
+			name: "missing name", method: "POST", path: "/index", status: http.StatusBadRequest,
+			body: `{"bucket":"bucket"}`,
+		},
+		{
+			name: "indexing failure", method: "POST", path: "/index", status: http.StatusInternalServerError,
+			body: `{"bucket":"bucket","name":"logs/job/1002/finished.json"}`,
+		},
+		{
+			name: "wrong method", method: "GET", path: "/index", status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, resp.StatusCode, body)
+			}
+			if tt.index != "" && client.get("bucket", tt.index) == nil {
+				t.Errorf("expected index entry %s to be written", tt.index)
+			}
+		})
+	}
+	if indexer.opts.Client != client {
+		t.Error("expected events to be handled by the indexer of the server")
+	}
+}
+
+func TestIndexJobsHTTPHandler(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	req, err := GCSEvent{Bucket: "bucket", Name: testFinishedName}.ToHTTPRequest("POST", "/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	IndexJobsHTTPHandler(opts).ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || client.get("bucket", testStatePath) == nil {
+		t.Errorf("expected the event to be indexed, got status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestGCSEvent_ToHTTPRequest(t *testing.T) {
+	e := GCSEvent{
+		Bucket:      "bucket",
+		Name:        testFinishedName,
+		ContentType: "application/json",
+		TimeCreated: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+	}
+	for name, toRequest := range map[string]func(method, url string) (*http.Request, error){
+		"plain":   e.ToHTTPRequest,
+		"pub/sub": e.ToPubSubHTTPRequest,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := toRequest("POST", "http://localhost/index")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != "POST" || req.URL.Path != "/index" || req.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("unexpected request %s %s %v", req.Method, req.URL, req.Header)
+			}
+			decoded, err := decodeHTTPEvent(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, e) {
+				t.Errorf("unexpected event %#v", decoded)
+			}
+		})
+	}
+
+	req, err := e.ToPubSubHTTPRequest("POST", "http://localhost/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var push map[string]map[string]string
+	if err := json.NewDecoder(req.Body).Decode(&push); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base64.StdEncoding.DecodeString(push["message"]["data"]); err != nil {
+		t.Errorf("expected base64 encoded data: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package cisearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrShuttingDown is returned for events received after shutdown began.
+var ErrShuttingDown = errors.New("indexer is shutting down")
+
+// shutdownCancelGrace is how long Shutdown waits for invocations to return
+// once their contexts are cancelled.
+var shutdownCancelGrace = 5 * time.Second
+
+// IndexJobsServer runs indexing invocations so that they can be allowed to
+// complete when the process is terminated. Start must be called before
+// Index.
+type IndexJobsServer struct {
+	// Handler indexes each event. Defaults to IndexJobs.
+	Handler HandlerFunc
+	// DedupBucket and DedupPrefix locate the deduplication index. If both
+	// are set, objects under DedupPrefix are deleted by RotateIndexKey every
+	// Opts.DedupRotationInterval while the server is running.
+	DedupBucket string
+	DedupPrefix string
+	// DedupKeepWindow is how long deduplication objects are kept. Defaults
+	// to the rotation interval.
+	DedupKeepWindow time.Duration
+	// Opts provides the client and rotation interval used to rotate the
+	// deduplication index.
+	Opts Options
+
+	lock     sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping bool
+	active   sync.WaitGroup
+}
+
+// Start prepares the server to accept events. The server shuts down, waiting
+// for in-flight invocations as Shutdown does, when the process receives
+// SIGTERM or ctx is done.
+func (s *IndexJobsServer) Start(ctx context.Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.DedupBucket != "" && s.DedupPrefix != "" {
+		go s.rotateDedupIndex(s.ctx)
+	}
+	signalCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM)
+	go func() {
+		defer stop()
+		select {
+		case <-signalCtx.Done():
+		case <-s.ctx.Done():
+			return
+		}
+		if err := s.Shutdown(shutdownTimeout); err != nil {
+			log.Printf("error: %v", err)
+		}
+	}()
+}
+
+// Index handles e unless the server is shutting down. The context passed to
+// the handler is cancelled if ctx is or if Shutdown times out.
+func (s *IndexJobsServer) Index(ctx context.Context, e GCSEvent) error {
+	s.lock.Lock()
+	if s.ctx == nil {
+		s.lock.Unlock()
+		return errors.New("indexer has not been started")
+	}
+	if s.stopping {
+		s.lock.Unlock()
+		return ErrShuttingDown
+	}
+	s.active.Add(1)
+	serverCtx := s.ctx
+	s.lock.Unlock()
+	defer s.active.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(serverCtx, cancel)()
+
+	handler := s.Handler
+	if handler == nil {
+		handler = IndexJobs
+	}
+	return handler(ctx, e)
+}
+
+// Shutdown stops accepting events and waits up to timeout for in-flight
+// invocations to complete. If they do not, their contexts are cancelled and
+// an error is returned once they return, or after a short grace period if
+// they ignore the cancellation.
+func (s *IndexJobsServer) Shutdown(timeout time.Duration) error {
+	s.lock.Lock()
+	if s.ctx == nil {
+		s.lock.Unlock()
+		return nil
+	}
+	s.stopping = true
+	cancel := s.cancel
+	s.lock.Unlock()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		cancel()
+	}
+	select {
+	case <-done:
+		return fmt.Errorf("in-flight indexing did not complete within %s and was cancelled", timeout)
+	case <-time.After(shutdownCancelGrace):
+		return fmt.Errorf("in-flight indexing did not complete within %s and did not stop when cancelled", timeout)
+	}
+}
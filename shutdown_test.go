@@ -0,0 +1,105 @@
+package cisearch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIndexJobsServer_Shutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := &IndexJobsServer{Handler: func(ctx context.Context, e GCSEvent) error {
+		if e.Name == "" {
+			return nil
+		}
+		close(started)
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+	s.Start(context.Background())
+
+	result := make(chan error)
+	go func() { result <- s.Index(context.Background(), GCSEvent{Name: "logs/job/1/finished.json"}) }()
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(time.Minute) }()
+	// wait for the server to stop accepting events
+	for s.Index(context.Background(), GCSEvent{}) != ErrShuttingDown {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown completed with an invocation in flight: %v", err)
+	default:
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("expected the invocation to complete, got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+}
+
+func TestIndexJobsServer_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	s := &IndexJobsServer{Handler: func(ctx context.Context, e GCSEvent) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	s.Start(context.Background())
+
+	result := make(chan error)
+	go func() { result <- s.Index(context.Background(), GCSEvent{Name: "logs/job/1/finished.json"}) }()
+	<-started
+	if err := s.Shutdown(10 * time.Millisecond); err == nil {
+		t.Error("expected shutdown to time out")
+	}
+	if err := <-result; err != context.Canceled {
+		t.Errorf("expected the invocation to be cancelled, got %v", err)
+	}
+}
+
+func TestIndexJobsServer_ShutdownIgnoredCancel(t *testing.T) {
+	original := shutdownCancelGrace
+	t.Cleanup(func() { shutdownCancelGrace = original })
+	shutdownCancelGrace = 10 * time.Millisecond
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s := &IndexJobsServer{Handler: func(ctx context.Context, e GCSEvent) error {
+		close(started)
+		<-release
+		return nil
+	}}
+	s.Start(context.Background())
+	go s.Index(context.Background(), GCSEvent{Name: "logs/job/1/finished.json"})
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(10 * time.Millisecond) }()
+	select {
+	case err := <-shutdown:
+		if err == nil {
+			t.Error("expected shutdown to time out")
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("shutdown waited for an invocation that ignores cancellation")
+	}
+}
+
+func TestIndexJobsServer_NotStarted(t *testing.T) {
+	s := &IndexJobsServer{Handler: func(ctx context.Context, e GCSEvent) error { return nil }}
+	if err := s.Index(context.Background(), GCSEvent{}); err == nil {
+		t.Error("expected an error before Start")
+	}
+	if err := s.Shutdown(time.Second); err != nil {
+		t.Error(err)
+	}
+}
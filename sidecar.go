@@ -0,0 +1,58 @@
+package cisearch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// SidecarFunc enriches a job after it has been indexed. Sidecars run in the
+// background and cannot fail the indexing of the job.
+type SidecarFunc func(ctx context.Context, client StorageClient, bucket string, r JobResult)
+
+// DefaultSidecarTimeout bounds how long a sidecar may run when
+// Options.SidecarTimeout is not set.
+const DefaultSidecarTimeout = 30 * time.Second
+
+// activeSidecars tracks the sidecars that have not completed.
+var activeSidecars sync.WaitGroup
+
+// runSidecars starts each of the configured sidecars for r in its own
+// goroutine. Sidecars are not cancelled when ctx is.
+func (o Options) runSidecars(ctx context.Context, client StorageClient, bucket string, r JobResult) {
+	timeout := o.SidecarTimeout
+	if timeout <= 0 {
+		timeout = DefaultSidecarTimeout
+	}
+	for _, sidecar := range o.Sidecars {
+		activeSidecars.Add(1)
+		go func(sidecar SidecarFunc) {
+			defer activeSidecars.Done()
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("error: Sidecar for %s panicked: %v", r.Link, err)
+				}
+			}()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+			defer cancel()
+			sidecar(ctx, client, bucket, r)
+		}(sidecar)
+	}
+}
+
+// WaitForSidecars waits up to timeout for all running sidecars to complete.
+func WaitForSidecars(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		activeSidecars.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("sidecars did not complete within %s", timeout)
+	}
+}
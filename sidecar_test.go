@@ -0,0 +1,59 @@
+package cisearch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIndexJobsWithOptions_Sidecars(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", "logs/job/1001/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	client.put("bucket", "index/job-state/2024-01-15T12:00:00Z/job/1001", []byte(`{}`), nil)
+
+	var lock sync.Mutex
+	var links []string
+	release := make(chan struct{})
+	var deadline time.Time
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.SidecarTimeout = time.Minute
+	opts.Sidecars = []SidecarFunc{
+		func(ctx context.Context, client StorageClient, bucket string, r JobResult) {
+			lock.Lock()
+			defer lock.Unlock()
+			links = append(links, r.Link)
+		},
+		func(ctx context.Context, client StorageClient, bucket string, r JobResult) {
+			deadline, _ = ctx.Deadline()
+			<-release
+		},
+		func(ctx context.Context, client StorageClient, bucket string, r JobResult) {
+			panic("sidecar failure")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, name := range []string{testFinishedName, "logs/job/1001/finished.json"} {
+		if err := IndexJobsWithOptions(ctx, GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil && !IsPreconditionFailure(err) {
+			t.Fatal(err)
+		}
+	}
+	// sidecars outlive the invocation
+	cancel()
+	if err := WaitForSidecars(10 * time.Millisecond); err == nil {
+		t.Error("expected the blocked sidecar to still be running")
+	}
+	close(release)
+	if err := WaitForSidecars(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0] != "gs://bucket/logs/job/1000" {
+		t.Errorf("expected sidecars to run only for the newly indexed job, got %v", links)
+	}
+	if remaining := time.Until(deadline); remaining <= 0 || remaining > time.Minute {
+		t.Errorf("unexpected sidecar deadline %s", deadline)
+	}
+}
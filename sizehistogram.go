@@ -0,0 +1,178 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// DefaultSizeHistogramBounds are the bucket boundaries used to record the
+// sizes of indexed job_metrics.json files.
+var DefaultSizeHistogramBounds = []int64{10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+// sizeHistogramPath returns where the sizes of indexed job_metrics.json
+// files are recorded for the given index version.
+func sizeHistogramPath(version string) string {
+	return indexPrefix(version, ".size-histogram")
+}
+
+// maxSizeHistogramRetries bounds how often recording a size is retried when
+// another invocation updates the histogram concurrently.
+const maxSizeHistogramRetries = 5
+
+// SizeHistogram counts object sizes into buckets. Counts[i] holds the sizes
+// no larger than Bounds[i] and greater than the previous bound, and the last
+// count holds the sizes larger than every bound.
+type SizeHistogram struct {
+	Bounds []int64 `json:"bounds"`
+	Counts []int64 `json:"counts"`
+	// Max is the largest recorded size.
+	Max int64 `json:"max"`
+}
+
+// NewSizeHistogram returns an empty histogram with the given bucket bounds.
+func NewSizeHistogram(bounds ...int64) *SizeHistogram {
+	sorted := append([]int64(nil), bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &SizeHistogram{
+		Bounds: sorted,
+		Counts: make([]int64, len(sorted)+1),
+	}
+}
+
+// Record adds size to the histogram.
+func (h *SizeHistogram) Record(size int64) {
+	i := sort.Search(len(h.Bounds), func(i int) bool { return size <= h.Bounds[i] })
+	h.Counts[i]++
+	if size > h.Max {
+		h.Max = size
+	}
+}
+
+// Total returns the number of recorded sizes.
+func (h *SizeHistogram) Total() int64 {
+	var total int64
+	for _, count := range h.Counts {
+		total += count
+	}
+	return total
+}
+
+// Percentile returns the upper bound of the bucket holding the pth
+// percentile (0-100) of recorded sizes. Sizes larger than every bound are
+// reported as the largest recorded size. It returns 0 if nothing has been
+// recorded.
+func (h *SizeHistogram) Percentile(p float64) int64 {
+	total := h.Total()
+	if total == 0 {
+		return 0
+	}
+	switch {
+	case p < 0:
+		p = 0
+	case p > 100:
+		p = 100
+	}
+	// nearest rank
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.Counts {
+		seen += count
+		if seen >= rank {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// String describes the count of each bucket, e.g. "<=10KiB:3 <=100KiB:1 >100KiB:0".
+func (h *SizeHistogram) String() string {
+	parts := make([]string, 0, len(h.Counts))
+	for i, count := range h.Counts {
+		if i < len(h.Bounds) {
+			parts = append(parts, fmt.Sprintf("<=%s:%d", formatSize(h.Bounds[i]), count))
+			continue
+		}
+		var last int64
+		if len(h.Bounds) > 0 {
+			last = h.Bounds[len(h.Bounds)-1]
+		}
+		parts = append(parts, fmt.Sprintf(">%s:%d", formatSize(last), count))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatSize formats a size in bytes using the largest binary unit that
+// divides it evenly.
+func formatSize(size int64) string {
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if size >= unit.bytes && size%unit.bytes == 0 {
+			return fmt.Sprintf("%d%s", size/unit.bytes, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// recordObjectSize adds size to the histogram stored at name in bucket,
+// resetting it if it was recorded with different bounds.
+func recordObjectSize(ctx context.Context, client StorageClient, bucket, name string, bounds []int64, size int64) error {
+	writer := IndexWriter{Client: client, Bucket: bucket}
+	for retries := 0; ; retries++ {
+		h := NewSizeHistogram(bounds...)
+		conds := storage.Conditions{DoesNotExist: true}
+		attrs, err := client.Attrs(ctx, bucket, name)
+		switch {
+		case err == storage.ErrObjectNotExist:
+		case err != nil:
+			return fmt.Errorf("unable to check %s: %v", name, err)
+		default:
+			conds = storage.Conditions{GenerationMatch: attrs.Generation}
+			data, err := readObject(ctx, client, bucket, name)
+			if err != nil && err != storage.ErrObjectNotExist {
+				return fmt.Errorf("unable to read %s: %v", name, err)
+			}
+			var existing SizeHistogram
+			if err == nil && json.Unmarshal(data, &existing) == nil && existing.matches(h) {
+				h = &existing
+			}
+		}
+		h.Record(size)
+		data, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		err = writer.Write(ctx, name, data, nil, &conds)
+		if !isGoogleAPICode(err, http.StatusPreconditionFailed) || retries >= maxSizeHistogramRetries {
+			return err
+		}
+	}
+}
+
+// matches returns true if h has the same bounds as other and consistent
+// counts.
+func (h *SizeHistogram) matches(other *SizeHistogram) bool {
+	if len(h.Bounds) != len(other.Bounds) || len(h.Counts) != len(h.Bounds)+1 {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
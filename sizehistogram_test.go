@@ -0,0 +1,143 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSizeHistogram_Record(t *testing.T) {
+	h := NewSizeHistogram(1<<20, 10<<10, 100<<10)
+	for _, size := range []int64{0, 10 << 10, 10<<10 + 1, 100 << 10, 1 << 20, 1<<20 + 1, 50 << 20} {
+		h.Record(size)
+	}
+	if expect := []int64{10 << 10, 100 << 10, 1 << 20}; !reflect.DeepEqual(h.Bounds, expect) {
+		t.Errorf("expected sorted bounds, got %v", h.Bounds)
+	}
+	if expect := []int64{2, 2, 1, 2}; !reflect.DeepEqual(h.Counts, expect) {
+		t.Errorf("unexpected counts %v", h.Counts)
+	}
+	if h.Max != 50<<20 || h.Total() != 7 {
+		t.Errorf("unexpected max %d or total %d", h.Max, h.Total())
+	}
+	if s := h.String(); s != "<=10KiB:2 <=100KiB:2 <=1MiB:1 >1MiB:2" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestSizeHistogram_Percentile(t *testing.T) {
+	h := NewSizeHistogram(DefaultSizeHistogramBounds...)
+	if p := h.Percentile(50); p != 0 {
+		t.Errorf("expected 0 for an empty histogram, got %d", p)
+	}
+	for i := 0; i < 50; i++ {
+		h.Record(5 << 10)
+	}
+	for i := 0; i < 40; i++ {
+		h.Record(500 << 10)
+	}
+	for i := 0; i < 9; i++ {
+		h.Record(5 << 20)
+	}
+	h.Record(30 << 20)
+	tests := []struct {
+		p      float64
+		expect int64
+	}{
+		{p: 0, expect: 10 << 10},
+		{p: 50, expect: 10 << 10},
+		{p: 51, expect: 1 << 20},
+		{p: 90, expect: 1 << 20},
+		{p: 95, expect: 10 << 20},
+		{p: 99, expect: 10 << 20},
+		{p: 100, expect: 30 << 20},
+		{p: 200, expect: 30 << 20},
+	}
+	for _, tt := range tests {
+		if got := h.Percentile(tt.p); got != tt.expect {
+			t.Errorf("Percentile(%v) = %d, want %d", tt.p, got, tt.expect)
+		}
+	}
+
+	// an odd total, where truncating the rank selects a lower bucket
+	h = NewSizeHistogram(DefaultSizeHistogramBounds...)
+	for _, size := range []int64{5 << 10, 500 << 10, 5 << 20} {
+		h.Record(size)
+	}
+	for p, expect := range map[float64]int64{0: 10 << 10, 33: 10 << 10, 34: 1 << 20, 50: 1 << 20, 67: 10 << 20, 100: 10 << 20} {
+		if got := h.Percentile(p); got != expect {
+			t.Errorf("Percentile(%v) of 3 sizes = %d, want %d", p, got, expect)
+		}
+	}
+}
+
+func TestSizeHistogram_JSON(t *testing.T) {
+	h := NewSizeHistogram(DefaultSizeHistogramBounds...)
+	h.Record(1)
+	h.Record(20 << 20)
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SizeHistogram
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, h) {
+		t.Errorf("histogram changed after round trip:\n%#v\n%#v", decoded, h)
+	}
+}
+
+func TestIndexJobsWithOptions_RecordsMetricsSize(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(testJobMetrics), nil)
+	// histograms recorded with other bounds are replaced
+	client.put("bucket", "index/.size-histogram", []byte(`{"bounds":[1],"counts":[5,5],"max":2}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.RecordMetricsSizes = true
+	// only indexed files are recorded, not skipped or duplicate events
+	for _, size := range []string{"2048", "20971520", "4096"} {
+		if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, Size: size}, opts); err != nil && !IsPreconditionFailure(err) {
+			t.Fatal(err)
+		}
+	}
+	obj := client.get("bucket", "index/.size-histogram")
+	if obj == nil {
+		t.Fatal("expected the size histogram to be written")
+	}
+	var h SizeHistogram
+	if err := json.Unmarshal(obj.data, &h); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []int64{1, 0, 0, 0, 0}; !reflect.DeepEqual(h.Counts, expect) {
+		t.Errorf("unexpected counts %v", h.Counts)
+	}
+	if version := obj.attrs.Metadata["indexer-version"]; version != IndexerVersion {
+		t.Errorf("unexpected indexer-version %q", version)
+	}
+
+	// the histogram follows the index version and is off by default
+	client = NewFakeGCSClient()
+	client.put("bucket", name, []byte(testJobMetrics), nil)
+	opts.Client = client
+	opts.IndexVersion = "v2"
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, Size: "2048"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", "index/v2/.size-histogram") == nil {
+		t.Error("expected the histogram of the v2 index to be written")
+	}
+	client = NewFakeGCSClient()
+	client.put("bucket", name, []byte(testJobMetrics), nil)
+	opts = DefaultOptions()
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name, Size: "2048"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", "index/.size-histogram") != nil {
+		t.Error("expected no histogram unless enabled")
+	}
+}
@@ -0,0 +1,139 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// RunningJob is the content of the job-running index entry of a build that
+// has started but not finished.
+type RunningJob struct {
+	State     string `json:"state"`
+	StartedAt int64  `json:"started_at"`
+	Link      string `json:"link"`
+}
+
+// StartedJSONHandler indexes the builds whose started.json is described by
+// the event into index/job-running/RFC3339_START/JOB_NAME/BUILD_NUMBER with
+// a pending state. The entry is deleted by FinishedJSONHandler once the
+// build finishes, so builds that have already finished are skipped.
+func StartedJSONHandler(opts Options) HandlerFunc {
+	return func(ctx context.Context, e GCSEvent) error {
+		parts := strings.Split(e.Name, "/")
+		if len(parts) < 4 {
+			skipEvent(ctx, "not a build artifact")
+			return nil
+		}
+		job := parts[len(parts)-3]
+		if !opts.includesJob(job) {
+			skipEvent(ctx, "job excluded by the job kind filter")
+			return nil
+		}
+		build, err := ParseBuildID(parts[len(parts)-2])
+		if err != nil {
+			log.Printf("warn: Skipped %s: %v", e.Name, err)
+			skipEvent(ctx, err.Error())
+			return nil
+		}
+
+		client, err := opts.storageClient(ctx)
+		if err != nil {
+			return err
+		}
+		// started.json may be delivered after the build finished, when no
+		// handler would remove the entry
+		for _, name := range []string{"finished.json", "finished.yaml"} {
+			_, err := client.Attrs(ctx, e.Bucket, path.Join(path.Dir(e.Name), name))
+			if err == nil {
+				skipEvent(ctx, "job has already finished")
+				return nil
+			}
+			if err != storage.ErrObjectNotExist {
+				return err
+			}
+		}
+		data, err := readObject(ctx, client, e.Bucket, e.Name)
+		if err == nil {
+			data, err = decodeContent(data)
+		}
+		if err != nil {
+			return err
+		}
+		var started Started
+		if err := json.Unmarshal(data, &started); err != nil {
+			return err
+		}
+		startedAt, ok := started.Time()
+		if !ok {
+			log.Printf("warn: Skipped %s without a timestamp", e.Name)
+			skipEvent(ctx, "job has no start time")
+			return nil
+		}
+
+		u := (&url.URL{
+			Scheme: "gs",
+			Host:   e.Bucket,
+			Path:   path.Dir(e.Name),
+		}).String()
+		if data, err = json.Marshal(RunningJob{State: "pending", StartedAt: startedAt.Unix(), Link: u}); err != nil {
+			return fmt.Errorf("could not serialize running job: %v", err)
+		}
+		indexPath := runningIndexPath(opts, startedAt, job, build)
+		metadata := map[string]string{
+			"link":    u,
+			"state":   "pending",
+			"started": strconv.FormatInt(startedAt.Unix(), 10),
+		}
+		writer := opts.indexWriter(client, e.Bucket)
+		err = retryGCS(ctx, gcsRetryAttempts, func() error {
+			return writer.Write(ctx, indexPath, data, metadata, opts.writeConditions())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write running job %s to %s: %w", indexPath, u, wrapPreconditionFailure(err))
+		}
+		log.Printf("Indexed running job %s to gs://%s/%s", u, e.Bucket, indexPath)
+		return nil
+	}
+}
+
+// runningIndexPath returns the job-running index entry of a build.
+func runningIndexPath(opts Options, startedAt time.Time, job string, build BuildID) string {
+	return path.Join(indexPrefix(opts.IndexVersion, "job-running"), startedAt.Format(time.RFC3339), job, string(build))
+}
+
+// removeRunningJob deletes the job-running index entry of the build in dir,
+// found from the start time in its started.json. Builds without a
+// started.json or entry are ignored.
+func removeRunningJob(ctx context.Context, client StorageClient, bucket, dir, job string, build BuildID, opts Options) error {
+	data, err := readObject(ctx, client, bucket, path.Join(dir, "started.json"))
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	if err == nil {
+		data, err = decodeContent(data)
+	}
+	if err != nil {
+		return err
+	}
+	var started Started
+	if err := json.Unmarshal(data, &started); err != nil {
+		return err
+	}
+	startedAt, ok := started.Time()
+	if !ok {
+		return nil
+	}
+	if err := client.Delete(ctx, bucket, runningIndexPath(opts, startedAt, job, build)); err != nil && err != storage.ErrObjectNotExist {
+		return err
+	}
+	return nil
+}
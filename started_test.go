@@ -0,0 +1,101 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestStartedJSONHandler(t *testing.T) {
+	const runningPath = "index/job-running/2024-01-15T11:00:00Z/job/1000"
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/started.json", []byte(`{"timestamp":1705316400,"metadata":{"repo":"openshift/origin"}}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.StorageClass = "NEARLINE"
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/started.json"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", runningPath)
+	if obj == nil {
+		t.Fatal("expected job-running index entry")
+	}
+	if obj.attrs.Metadata["state"] != "pending" || obj.attrs.Metadata["link"] != "gs://bucket/logs/job/1000" || obj.attrs.Metadata["started"] != "1705316400" || obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+		t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+	}
+	if obj.attrs.StorageClass != opts.StorageClass {
+		t.Errorf("unexpected storage class %q", obj.attrs.StorageClass)
+	}
+	var running RunningJob
+	if err := json.Unmarshal(obj.data, &running); err != nil {
+		t.Fatal(err)
+	}
+	if expect := (RunningJob{State: "pending", StartedAt: 1705316400, Link: "gs://bucket/logs/job/1000"}); running != expect {
+		t.Errorf("unexpected running job %#v", running)
+	}
+
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", runningPath) != nil {
+		t.Error("expected the job-running index entry to be removed once the job finished")
+	}
+	if client.get("bucket", testStatePath) == nil {
+		t.Error("expected job-state index entry")
+	}
+}
+
+func TestStartedJSONHandler_NoTimestamp(t *testing.T) {
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/started.json", []byte(`{"metadata":{"repo":"openshift/origin"}}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	result := IndexJobsWithResult(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/started.json"}, opts)
+	if result.Err != nil || !result.IsSkipped {
+		t.Fatalf("expected the event to be skipped, got %#v", result)
+	}
+	if objects, _ := client.List(context.TODO(), "bucket", nil); len(objects) != 1 {
+		t.Errorf("expected no index entries, got %d objects", len(objects))
+	}
+
+	// finishing a job without a start time succeeds
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartedJSONHandler_Order(t *testing.T) {
+	const runningPath = "index/job-running/2024-01-15T11:00:00Z/job/1000"
+	const started = `{"timestamp":1705316400}`
+	opts := DefaultOptions()
+
+	// compressed started.json is decoded when the build finishes
+	client := NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/started.json", gzipData(t, started), nil)
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/started.json"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", runningPath) == nil {
+		t.Fatal("expected job-running index entry")
+	}
+	indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true}`)
+	if client.get("bucket", runningPath) != nil {
+		t.Error("expected the job-running index entry to be removed once the job finished")
+	}
+
+	// started.json delivered after the build finished is not indexed
+	client = NewFakeGCSClient()
+	client.put("bucket", "logs/job/1000/started.json", []byte(started), nil)
+	opts.Client = client
+	indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true}`)
+	result := IndexJobsWithResult(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/job/1000/started.json"}, opts)
+	if result.Err != nil || !result.IsSkipped {
+		t.Fatalf("expected the event to be skipped, got %#v", result)
+	}
+	if client.get("bucket", runningPath) != nil {
+		t.Error("expected no job-running index entry for a finished job")
+	}
+}
@@ -0,0 +1,47 @@
+package cisearch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StaleMetricReport describes a metric that was not indexed because it was
+// sampled too long before the job finished.
+type StaleMetricReport struct {
+	MetricName string
+	MetricAge  time.Duration
+}
+
+// Stats collects details of the events indexed with a context returned by
+// WithStats. It is safe for concurrent use.
+type Stats struct {
+	lock         sync.Mutex
+	staleMetrics []StaleMetricReport
+}
+
+// StaleMetrics returns the metrics that were skipped as stale.
+func (s *Stats) StaleMetrics() []StaleMetricReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]StaleMetricReport(nil), s.staleMetrics...)
+}
+
+func (s *Stats) addStaleMetric(report StaleMetricReport) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.staleMetrics = append(s.staleMetrics, report)
+}
+
+type statsKey struct{}
+
+// WithStats returns a context that records the details of indexing into s.
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// statsFromContext returns the Stats of ctx, or nil if there are none.
+func statsFromContext(ctx context.Context) *Stats {
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
@@ -0,0 +1,119 @@
+package cisearch
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// StorageClient is the subset of the GCS API used by the indexers. It exists
+// so that tests can substitute an in-memory implementation.
+type StorageClient interface {
+	// NewReader returns a reader for the contents of the named object.
+	NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error)
+	// NewWriter returns a writer that stores the named object with attrs
+	// when closed. If conds is non-nil the object is only written when the
+	// conditions are met.
+	NewWriter(ctx context.Context, bucket, name string, attrs storage.ObjectAttrs, conds *storage.Conditions) io.WriteCloser
+	// Attrs returns the attributes of the named object, or
+	// storage.ErrObjectNotExist.
+	Attrs(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error)
+	// List returns the attributes of every object in bucket that matches q.
+	// When q sets a Delimiter, common prefixes are returned as attributes
+	// with only Prefix set.
+	List(ctx context.Context, bucket string, q *storage.Query) ([]*storage.ObjectAttrs, error)
+	// Compose replaces the named object with the concatenation of the
+	// source objects in the same bucket, storing it with attrs. If conds is
+	// non-nil the object is only written when the conditions are met.
+	Compose(ctx context.Context, bucket, name string, sources []string, attrs storage.ObjectAttrs, conds *storage.Conditions) error
+	// Delete removes the named object.
+	Delete(ctx context.Context, bucket, name string) error
+}
+
+// NewStorageClient adapts a GCS client to the StorageClient interface.
+func NewStorageClient(client *storage.Client) StorageClient {
+	return gcsClient{client: client}
+}
+
+type gcsClient struct {
+	client *storage.Client
+}
+
+func (c gcsClient) NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	return c.client.Bucket(bucket).Object(name).NewReader(ctx)
+}
+
+func (c gcsClient) NewWriter(ctx context.Context, bucket, name string, attrs storage.ObjectAttrs, conds *storage.Conditions) io.WriteCloser {
+	obj := c.client.Bucket(bucket).Object(name)
+	if conds != nil {
+		obj = obj.If(*conds)
+	}
+	w := obj.NewWriter(ctx)
+	w.ObjectAttrs = attrs
+	w.ObjectAttrs.Name = name
+	return w
+}
+
+func (c gcsClient) Attrs(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error) {
+	return c.client.Bucket(bucket).Object(name).Attrs(ctx)
+}
+
+func (c gcsClient) List(ctx context.Context, bucket string, q *storage.Query) ([]*storage.ObjectAttrs, error) {
+	var objects []*storage.ObjectAttrs
+	it := c.client.Bucket(bucket).Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+}
+
+func (c gcsClient) Compose(ctx context.Context, bucket, name string, sources []string, attrs storage.ObjectAttrs, conds *storage.Conditions) error {
+	b := c.client.Bucket(bucket)
+	srcs := make([]*storage.ObjectHandle, 0, len(sources))
+	for _, source := range sources {
+		srcs = append(srcs, b.Object(source))
+	}
+	obj := b.Object(name)
+	if conds != nil {
+		obj = obj.If(*conds)
+	}
+	composer := obj.ComposerFrom(srcs...)
+	composer.ObjectAttrs = attrs
+	composer.ObjectAttrs.Name = name
+	_, err := composer.Run(ctx)
+	return err
+}
+
+func (c gcsClient) Delete(ctx context.Context, bucket, name string) error {
+	return c.client.Bucket(bucket).Object(name).Delete(ctx)
+}
+
+// readIndexEntry returns the contents of the named index entry, decompressed
+// if it was written with Options.CompressIndex. GCS usually decompresses
+// such entries as they are read, so the content itself is checked.
+func readIndexEntry(ctx context.Context, client StorageClient, bucket, name string) ([]byte, error) {
+	data, err := readObject(ctx, client, bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return decodeContent(data)
+}
+
+// readObject returns the contents of the named object.
+func readObject(ctx context.Context, client StorageClient, bucket, name string) ([]byte, error) {
+	r, err := client.NewReader(ctx, bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,55 @@
+package cisearch
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName identifies the spans created by this package.
+const tracerName = "github.com/openshift/ci-search-functions"
+
+// IndexJobsWithTracing indexes e as IndexJobsWithOptions does, recording a
+// span for the whole invocation with opts.TracerProvider. The steps of
+// indexing a finished.json are recorded as child spans. Spans carry the
+// gcs.bucket and gcs.object attributes and, once known, job.name and
+// job.state.
+func IndexJobsWithTracing(ctx context.Context, e GCSEvent, opts Options) error {
+	ctx, span := opts.startSpan(ctx, "IndexJobs", eventSpanAttributes(e)...)
+	err := IndexJobsWithOptions(ctx, e, opts)
+	if IsPreconditionFailure(err) {
+		endSpan(span, nil)
+	} else {
+		endSpan(span, err)
+	}
+	return err
+}
+
+// startSpan starts a span named name as a child of any span in ctx.
+func (o Options) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	provider := o.TracerProvider
+	if provider == nil {
+		provider = noop.NewTracerProvider()
+	}
+	return provider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it as failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// eventSpanAttributes returns the span attributes that identify e.
+func eventSpanAttributes(e GCSEvent) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("gcs.bucket", e.Bucket),
+		attribute.String("gcs.object", e.Name),
+	}
+}
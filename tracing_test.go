@@ -0,0 +1,87 @@
+package cisearch
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestIndexJobsWithTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sdktrace.NewSimpleSpanProcessor(exporter)))
+	client := NewFakeGCSClient()
+	client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":false}`), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.TracerProvider = provider
+	if err := IndexJobsWithTracing(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if client.get("bucket", testStatePath) == nil {
+		t.Fatal("expected index entry")
+	}
+
+	spans := exporter.GetSpans()
+	var names []string
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		names = append(names, span.Name)
+		byName[span.Name] = span
+	}
+	expect := []string{"ValidateEvent", "CreateClient", "ReadFinished", "ParseFinished", "ComputeIndexPath", "WriteIndex", "IndexJobs"}
+	if len(names) != len(expect) {
+		t.Fatalf("unexpected spans %v", names)
+	}
+	for i := range expect {
+		if names[i] != expect[i] {
+			t.Fatalf("unexpected spans %v", names)
+		}
+	}
+	root := byName["IndexJobs"]
+	for _, span := range spans {
+		if span.Name != "IndexJobs" && span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("span %s is not a child of the invocation span", span.Name)
+		}
+	}
+
+	attrs := func(span tracetest.SpanStub) map[attribute.Key]string {
+		m := make(map[attribute.Key]string)
+		for _, kv := range span.Attributes {
+			m[kv.Key] = kv.Value.AsString()
+		}
+		return m
+	}
+	for _, name := range []string{"IndexJobs", "WriteIndex"} {
+		a := attrs(byName[name])
+		if a["gcs.bucket"] != "bucket" || a["gcs.object"] != testFinishedName || a["job.name"] != "job" || a["job.state"] != "failed" {
+			t.Errorf("unexpected attributes of %s: %v", name, a)
+		}
+	}
+	if a := attrs(byName["ReadFinished"]); a["job.name"] != "job" {
+		t.Errorf("unexpected attributes of ReadFinished: %v", a)
+	}
+	if _, ok := attrs(byName["ReadFinished"])["job.state"]; ok {
+		t.Error("expected ReadFinished to not have the job state")
+	}
+}
+
+func TestIndexJobsWithTracing_Error(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sdktrace.NewSimpleSpanProcessor(exporter)))
+	opts := DefaultOptions()
+	opts.Client = NewFakeGCSClient()
+	opts.TracerProvider = provider
+	if err := IndexJobsWithTracing(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts); err == nil {
+		t.Fatal("expected an error reading a missing object")
+	}
+	failed := make(map[string]bool)
+	for _, span := range exporter.GetSpans() {
+		failed[span.Name] = span.Status.Code != 0
+	}
+	if !failed["ReadFinished"] || !failed["IndexJobs"] || failed["CreateClient"] {
+		t.Errorf("unexpected span status %v", failed)
+	}
+}
@@ -1,17 +1,224 @@
 package cisearch
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // Finished holds the finished.json values of the build
 type Finished struct {
 	// Timestamp is UTC epoch seconds when the job finished.
 	// An empty value indicates an incomplete job.
-	Timestamp *int64 `json:"timestamp,omitempty"`
+	Timestamp *int64 `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
 	// Passed is true when the job completes successfully.
-	Passed *bool `json:"passed"`
+	Passed *bool `json:"passed" yaml:"passed"`
 	// Metadata holds data computed by the job at runtime.
 	// For example, the version of a binary downloaded at runtime
+	Metadata Metadata `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// Started holds the started.json values of the build
+type Started struct {
+	// Timestamp is UTC epoch seconds when the job started.
+	Timestamp int64 `json:"timestamp"`
+	// Metadata holds data computed by the job at startup.
 	Metadata Metadata `json:"metadata,omitempty"`
 }
 
+// Time returns the time the job started and true if it is recorded.
+func (s Started) Time() (time.Time, bool) {
+	if s.Timestamp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(s.Timestamp, 0).UTC(), true
+}
+
+// ParseFinishedYAML parses a finished.yaml file, the YAML equivalent of
+// finished.json.
+func ParseFinishedYAML(data []byte) (*Finished, error) {
+	var f Finished
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// HasPassed returns true if the job reported that it passed.
+func (f Finished) HasPassed() bool {
+	return f.Passed != nil && *f.Passed
+}
+
+// HasFailed returns true if the job reported that it failed.
+func (f Finished) HasFailed() bool {
+	return f.Passed != nil && !*f.Passed
+}
+
+// HasError returns true if the job did not report whether it passed.
+func (f Finished) HasError() bool {
+	return f.Passed == nil
+}
+
+// State returns success or failed for jobs that report whether they passed
+// and error for those that do not, as recorded in the job-state index.
+func (f Finished) State() string {
+	switch {
+	case f.HasError():
+		return "error"
+	case f.HasPassed():
+		return "success"
+	default:
+		return "failed"
+	}
+}
+
+// IsComplete returns true if the job recorded when it finished.
+func (f Finished) IsComplete() bool {
+	_, ok := f.Time()
+	return ok
+}
+
+// IsSuccess returns true if the job finished and passed.
+func (f Finished) IsSuccess() bool {
+	return f.IsComplete() && f.HasPassed()
+}
+
+// IsFailed returns true if the job finished without passing, including jobs
+// that did not report whether they passed.
+func (f Finished) IsFailed() bool {
+	return f.IsComplete() && !f.HasPassed()
+}
+
+// Time returns the time the job finished and true if it has finished.
+func (f Finished) Time() (time.Time, bool) {
+	if f.Timestamp == nil || *f.Timestamp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(*f.Timestamp, 0).UTC(), true
+}
+
+// MustTime returns the time the job finished and panics if it has not. It is
+// intended for tests.
+func (f Finished) MustTime() time.Time {
+	t, ok := f.Time()
+	if !ok {
+		panic("job has not finished")
+	}
+	return t
+}
+
+// Version returns the job-version metadata value, the version of the
+// payload or binary under test, and true if it is set.
+func (f Finished) Version() (string, bool) {
+	if v, _ := f.Metadata.String("job-version"); v != nil {
+		return *v, true
+	}
+	return "", false
+}
+
+// Repo returns the repo metadata value and true if it is set.
+func (f Finished) Repo() (string, bool) {
+	if v, _ := f.Metadata.String("repo"); v != nil {
+		return *v, true
+	}
+	return "", false
+}
+
+// InfraCommit returns the commit of the installer and infrastructure code used
+// by the job and true if it is set.
+func (f Finished) InfraCommit() (string, bool) {
+	if v, _ := f.Metadata.String("infra-commit"); v != nil {
+		return *v, true
+	}
+	return "", false
+}
+
+// Versions returns the versions of the components under test recorded in
+// the versions metadata object, and true if that object is present.
+func (f Finished) Versions() (map[string]string, bool) {
+	versions, _ := f.Metadata.Meta("versions")
+	if versions == nil {
+		return nil, false
+	}
+	return versions.Strings(), true
+}
+
+// ComponentVersion returns the recorded version of a single component and
+// true if it is present.
+func (f Finished) ComponentVersion(component string) (string, bool) {
+	versions, ok := f.Versions()
+	if !ok {
+		return "", false
+	}
+	v, ok := versions[component]
+	return v, ok
+}
+
+// FeatureFlags returns the feature gates recorded in the feature-flags
+// metadata object, ignoring values that are not booleans, and true if that
+// object is present.
+func (f Finished) FeatureFlags() (map[string]bool, bool) {
+	flags, _ := f.Metadata.Meta("feature-flags")
+	if flags == nil {
+		return nil, false
+	}
+	enabled := make(map[string]bool, len(*flags))
+	for name, v := range *flags {
+		if b, ok := v.(bool); ok {
+			enabled[name] = b
+		}
+	}
+	return enabled, true
+}
+
+// String summarizes the state, completion time, version, and repo of the
+// job, omitting the values that are not set.
+func (f Finished) String() string {
+	var b strings.Builder
+	b.WriteString("Finished{state=")
+	b.WriteString(f.State())
+	if t, ok := f.Time(); ok {
+		b.WriteString(", at=")
+		b.WriteString(t.Format(time.RFC3339))
+	}
+	if v, ok := f.Version(); ok {
+		b.WriteString(", version=")
+		b.WriteString(v)
+	}
+	if v, ok := f.Repo(); ok {
+		b.WriteString(", repo=")
+		b.WriteString(v)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// MarshalText returns the same summary as String.
+func (f Finished) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// finishedFields has the fields of Finished without its methods, so that it
+// is encoded as an object rather than through MarshalText.
+type finishedFields Finished
+
+// MarshalJSON encodes f as a finished.json object. Without it encoding/json
+// would prefer MarshalText and encode the summary string.
+func (f Finished) MarshalJSON() ([]byte, error) {
+	return json.Marshal(finishedFields(f))
+}
+
+// MarshalYAML encodes f as a finished.yaml mapping, for the same reason as
+// MarshalJSON.
+func (f Finished) MarshalYAML() (interface{}, error) {
+	return finishedFields(f), nil
+}
+
 // Metadata holds the finished.json values in the metadata key.
 //
 // Metadata values can either be string or string map of strings
@@ -19,6 +226,114 @@ type Finished struct {
 // Special values: infra-commit, repos, repo, repo-commit, links, others
 type Metadata map[string]interface{}
 
+// UnmarshalYAML populates m from a YAML mapping. Numbers are converted to
+// float64 and nested mappings to Metadata so that the values match those
+// decoded from JSON.
+func (m *Metadata) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var values map[string]interface{}
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	if values == nil {
+		*m = nil
+		return nil
+	}
+	out := make(Metadata, len(values))
+	for k, v := range values {
+		value, err := fromYAMLValue(v)
+		if err != nil {
+			return fmt.Errorf("metadata key %q: %v", k, err)
+		}
+		out[k] = value
+	}
+	*m = out
+	return nil
+}
+
+// fromYAMLValue converts a value decoded from YAML to the type it would have
+// if decoded from JSON, except that mappings become Metadata.
+func fromYAMLValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case uint64:
+		return float64(t), nil
+	case map[string]interface{}:
+		child := make(Metadata, len(t))
+		for k, v := range t {
+			value, err := fromYAMLValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+			child[k] = value
+		}
+		return child, nil
+	case []interface{}:
+		values := make([]interface{}, len(t))
+		for i, v := range t {
+			value, err := fromYAMLValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", i, err)
+			}
+			values[i] = value
+		}
+		return values, nil
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	case nil, bool, float64, string:
+		return t, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+// stringMetadataKeys are the metadata keys whose values are always strings,
+// even when they look like numbers, such as a job-version of 4.15.
+var stringMetadataKeys = map[string]bool{
+	"job-version":             true,
+	"openshift-tests-version": true,
+	"infra-commit":            true,
+	"repo":                    true,
+	"repos":                   true,
+}
+
+// isStringMetadataKey returns true if the values of key are strings: the
+// known keys and those naming a version, commit or repo.
+func isStringMetadataKey(key string) bool {
+	return stringMetadataKeys[key] || strings.HasSuffix(key, "-version") || strings.HasSuffix(key, "-commit") || strings.HasSuffix(key, "-repo")
+}
+
+// MetadataFromGCSAttrs converts GCS object metadata, which only holds
+// strings, to Metadata. Values of "true" and "false" become booleans and
+// finite numbers become float64 to match values decoded from JSON. Values
+// of versions, commits and repos (see isStringMetadataKey) and all other
+// values remain strings.
+func MetadataFromGCSAttrs(attrs map[string]string) Metadata {
+	m := make(Metadata, len(attrs))
+	for k, v := range attrs {
+		if isStringMetadataKey(k) {
+			m[k] = v
+			continue
+		}
+		switch v {
+		case "true":
+			m[k] = true
+			continue
+		case "false":
+			m[k] = false
+			continue
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			m[k] = f
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
+
 // String returns the name key if its value is a string, and true if the key is present.
 func (m Metadata) String(name string) (*string, bool) {
 	if v, ok := m[name]; !ok {
@@ -30,6 +345,169 @@ func (m Metadata) String(name string) (*string, bool) {
 	}
 }
 
+// JSONPath returns the value at a dot separated path of keys through nested
+// metadata, such as "repos.openshift/origin.base_ref", and true if it is
+// present. Dots and backslashes within a key are escaped with a backslash.
+func (m Metadata) JSONPath(path string) (interface{}, bool) {
+	keys := splitMetadataPath(path)
+	if len(keys) == 0 {
+		return nil, false
+	}
+	var current interface{} = map[string]interface{}(m)
+	for _, key := range keys {
+		var child map[string]interface{}
+		switch t := current.(type) {
+		case Metadata:
+			child = t
+		case map[string]interface{}:
+			child = t
+		default:
+			return nil, false
+		}
+		v, ok := child[key]
+		if !ok {
+			return nil, false
+		}
+		current = v
+	}
+	return current, true
+}
+
+// StringAt returns the value at path if it is a string, and true if the path
+// is present. See JSONPath for the path syntax.
+func (m Metadata) StringAt(path string) (*string, bool) {
+	v, ok := m.JSONPath(path)
+	if !ok {
+		return nil, false
+	}
+	if s, ok := v.(string); ok {
+		return &s, true
+	}
+	return nil, true
+}
+
+// splitMetadataPath splits path on dots that are not escaped.
+func splitMetadataPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	var keys []string
+	var key strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+		case c == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(c)
+		}
+	}
+	return append(keys, key.String())
+}
+
+// Int returns the name key if its value is an integer, and true if the key is present.
+// Integers may be JSON numbers without a fractional part or base 10 strings.
+func (m Metadata) Int(name string) (*int64, bool) {
+	v, ok := m[name]
+	if !ok {
+		return nil, false
+	}
+	switch t := v.(type) {
+	case float64:
+		if math.Trunc(t) != t || t < math.MinInt64 || t >= math.MaxInt64 {
+			return nil, true
+		}
+		i := int64(t)
+		return &i, true
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64)
+		if err != nil {
+			return nil, true
+		}
+		return &i, true
+	default:
+		return nil, true
+	}
+}
+
+// Float64 returns the name key if its value is a number, and true if the key is present.
+// Numbers may be JSON numbers or strings holding a finite number.
+func (m Metadata) Float64(name string) (*float64, bool) {
+	v, ok := m[name]
+	if !ok {
+		return nil, false
+	}
+	switch t := v.(type) {
+	case float64:
+		return &t, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, true
+		}
+		return &f, true
+	default:
+		return nil, true
+	}
+}
+
+// Bool returns the name key if its value is a boolean, and true if the key is present.
+// Booleans may be JSON booleans or the strings "true" and "false".
+func (m Metadata) Bool(name string) (*bool, bool) {
+	v, ok := m[name]
+	if !ok {
+		return nil, false
+	}
+	switch t := v.(type) {
+	case bool:
+		return &t, true
+	case string:
+		switch t {
+		case "true", "false":
+			b := t == "true"
+			return &b, true
+		}
+		return nil, true
+	default:
+		return nil, true
+	}
+}
+
+// StringSlice returns the name key if its value is an array of strings or a
+// comma separated string, and true if the key is present. Elements of a
+// comma separated string are trimmed and empty elements are dropped.
+func (m Metadata) StringSlice(name string) ([]string, bool) {
+	v, ok := m[name]
+	if !ok {
+		return nil, false
+	}
+	switch t := v.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(t))
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, true
+			}
+			values = append(values, s)
+		}
+		return values, true
+	case string:
+		var values []string
+		for _, s := range strings.Split(t, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		return values, true
+	default:
+		return nil, true
+	}
+}
+
 // Meta returns the name key if its value is a child object, and true if they key is present.
 func (m Metadata) Meta(name string) (*Metadata, bool) {
 	if v, ok := m[name]; !ok {
@@ -0,0 +1,562 @@
+package cisearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFinished_String(t *testing.T) {
+	passed, failed := true, false
+	timestamp := int64(1705320000)
+	zero := int64(0)
+	tests := []struct {
+		name     string
+		finished Finished
+		expect   string
+	}{
+		{
+			name:     "empty",
+			finished: Finished{},
+			expect:   "Finished{state=error}",
+		},
+		{
+			name:     "zero timestamp",
+			finished: Finished{Timestamp: &zero, Passed: &passed},
+			expect:   "Finished{state=success}",
+		},
+		{
+			name:     "success",
+			finished: Finished{Timestamp: &timestamp, Passed: &passed},
+			expect:   "Finished{state=success, at=2024-01-15T12:00:00Z}",
+		},
+		{
+			name:     "failed",
+			finished: Finished{Timestamp: &timestamp, Passed: &failed},
+			expect:   "Finished{state=failed, at=2024-01-15T12:00:00Z}",
+		},
+		{
+			name:     "error",
+			finished: Finished{Timestamp: &timestamp},
+			expect:   "Finished{state=error, at=2024-01-15T12:00:00Z}",
+		},
+		{
+			name: "version and repo",
+			finished: Finished{Timestamp: &timestamp, Passed: &passed, Metadata: Metadata{
+				"job-version": "4.15.0",
+				"repo":        "openshift/origin",
+			}},
+			expect: "Finished{state=success, at=2024-01-15T12:00:00Z, version=4.15.0, repo=openshift/origin}",
+		},
+		{
+			name:     "non-string version",
+			finished: Finished{Passed: &failed, Metadata: Metadata{"job-version": 4.15}},
+			expect:   "Finished{state=failed}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.finished.String(); got != tt.expect {
+				t.Errorf("String() = %q, want %q", got, tt.expect)
+			}
+			text, err := tt.finished.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(text) != tt.expect {
+				t.Errorf("MarshalText() = %q, want %q", text, tt.expect)
+			}
+		})
+	}
+}
+
+func TestFinished_Marshal(t *testing.T) {
+	timestamp, passed := int64(1705320000), true
+	f := Finished{Timestamp: &timestamp, Passed: &passed, Metadata: Metadata{"repo": "openshift/origin"}}
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"timestamp":1705320000,"passed":true,"metadata":{"repo":"openshift/origin"}}`; string(data) != expect {
+		t.Errorf("unexpected JSON %s", data)
+	}
+	// fields of type Finished are encoded as objects too
+	if data, err = json.Marshal(struct{ Finished *Finished }{&f}); err != nil || string(data) != `{"Finished":{"timestamp":1705320000,"passed":true,"metadata":{"repo":"openshift/origin"}}}` {
+		t.Errorf("unexpected JSON %s: %v", data, err)
+	}
+	if data, err = yaml.Marshal(f); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ParseFinishedYAML(data)
+	if err != nil {
+		t.Fatalf("unable to decode %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(*decoded, f) {
+		t.Errorf("unexpected YAML %s", data)
+	}
+}
+
+func TestMetadataFromGCSAttrs(t *testing.T) {
+	m := MetadataFromGCSAttrs(map[string]string{
+		"repo":         "openshift/origin",
+		"retry-count":  "3",
+		"ratio":        "0.25",
+		"passed":       "true",
+		"flaky":        "false",
+		"empty":        "",
+		"infinite":     "Inf",
+		"bool-like":    "1",
+		"mixed":        "4.15.0",
+		"job-version":  "4.15",
+		"infra-commit": "1234567",
+		"repo-commit":  "1e5",
+		"ovn-version":  "true",
+	})
+	expect := Metadata{
+		"repo":         "openshift/origin",
+		"retry-count":  float64(3),
+		"ratio":        0.25,
+		"passed":       true,
+		"flaky":        false,
+		"empty":        "",
+		"infinite":     "Inf",
+		"bool-like":    float64(1),
+		"mixed":        "4.15.0",
+		"job-version":  "4.15",
+		"infra-commit": "1234567",
+		"repo-commit":  "1e5",
+		"ovn-version":  "true",
+	}
+	if !reflect.DeepEqual(m, expect) {
+		t.Errorf("unexpected metadata:\n%#v\n%#v", m, expect)
+	}
+	if m := MetadataFromGCSAttrs(nil); m == nil || len(m) != 0 {
+		t.Errorf("expected empty metadata, got %#v", m)
+	}
+}
+
+func TestFinished_Versions(t *testing.T) {
+	tests := []struct {
+		name      string
+		finished  string
+		expect    map[string]string
+		ok        bool
+		installer string
+	}{
+		{
+			name: "release job",
+			finished: `{"timestamp":1705320000,"passed":true,"metadata":{
+				"job-version":"4.17.4",
+				"versions":{"machine-os":"417.94.202411070820-0","installer":"4.17.4","kubernetes":"1.30.5"}
+			}}`,
+			expect:    map[string]string{"machine-os": "417.94.202411070820-0", "installer": "4.17.4", "kubernetes": "1.30.5"},
+			ok:        true,
+			installer: "4.17.4",
+		},
+		{
+			name:     "non-string versions are ignored",
+			finished: `{"metadata":{"versions":{"machine-os":"417.94.0","count":3,"nested":{"a":"b"}}}}`,
+			expect:   map[string]string{"machine-os": "417.94.0"},
+			ok:       true,
+		},
+		{
+			name:     "no versions",
+			finished: `{"metadata":{"repo":"openshift/origin"}}`,
+		},
+		{
+			name:     "versions is not an object",
+			finished: `{"metadata":{"versions":"4.17.4"}}`,
+		},
+		{
+			name:     "no metadata",
+			finished: `{"timestamp":1705320000}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var finished Finished
+			if err := json.Unmarshal([]byte(tt.finished), &finished); err != nil {
+				t.Fatal(err)
+			}
+			versions, ok := finished.Versions()
+			if ok != tt.ok || !reflect.DeepEqual(versions, tt.expect) {
+				t.Errorf("Versions() = %v, %t, want %v, %t", versions, ok, tt.expect, tt.ok)
+			}
+			installer, ok := finished.ComponentVersion("installer")
+			if installer != tt.installer || ok != (tt.installer != "") {
+				t.Errorf("ComponentVersion() = %q, %t", installer, ok)
+			}
+		})
+	}
+}
+
+func TestParseFinishedYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		yaml     string
+		versions map[string]string
+	}{
+		{
+			name: "release job",
+			json: `{"timestamp":1705320000,"passed":true,"metadata":{
+				"job-version":"4.17.4",
+				"infra-commit":"0123abcd",
+				"pod":"release-4.17",
+				"work-namespace":"ci-op-1",
+				"shards":3,
+				"flaky":false,
+				"tags":["a","b"],
+				"versions":{"installer":"4.17.4","kubernetes":"1.30.5"}
+			}}`,
+			yaml: `
+timestamp: 1705320000
+passed: true
+metadata:
+  job-version: "4.17.4"
+  infra-commit: "0123abcd"
+  pod: release-4.17
+  work-namespace: ci-op-1
+  shards: 3
+  flaky: false
+  tags: [a, b]
+  versions:
+    installer: "4.17.4"
+    kubernetes: "1.30.5"
+`,
+			versions: map[string]string{"installer": "4.17.4", "kubernetes": "1.30.5"},
+		},
+		{
+			name: "failed without metadata",
+			json: `{"timestamp":1705320000,"passed":false}`,
+			yaml: "timestamp: 1705320000\npassed: false\n",
+		},
+		{
+			name: "incomplete",
+			json: `{}`,
+			yaml: "{}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromJSON Finished
+			if err := json.Unmarshal([]byte(tt.json), &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			fromYAML, err := ParseFinishedYAML([]byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fromJSON.String() != fromYAML.String() {
+				t.Errorf("unexpected YAML result:\n%s\n%s", fromYAML, fromJSON)
+			}
+			expect, _ := json.Marshal(fromJSON)
+			actual, _ := json.Marshal(fromYAML)
+			if string(expect) != string(actual) {
+				t.Errorf("YAML and JSON differ:\n%s\n%s", actual, expect)
+			}
+			versions, _ := fromYAML.Versions()
+			if len(versions) != 0 || len(tt.versions) != 0 {
+				if !reflect.DeepEqual(versions, tt.versions) {
+					t.Errorf("unexpected versions %v", versions)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFinishedYAML_Invalid(t *testing.T) {
+	for _, data := range []string{"metadata: [a, b]\n", "timestamp: soon\n", "- a\n"} {
+		if _, err := ParseFinishedYAML([]byte(data)); err == nil {
+			t.Errorf("expected %q to be rejected", data)
+		}
+	}
+}
+
+func TestMetadata_Int(t *testing.T) {
+	var m Metadata
+	if err := json.Unmarshal([]byte(`{
+		"retry-count": 3,
+		"negative": -12,
+		"string": "42",
+		"fraction": 1.5,
+		"huge": 1e300,
+		"word": "three",
+		"bool": true,
+		"nested": {"a": 1}
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		expect *int64
+		ok     bool
+	}{
+		{name: "retry-count", expect: int64Ptr(3), ok: true},
+		{name: "negative", expect: int64Ptr(-12), ok: true},
+		{name: "string", expect: int64Ptr(42), ok: true},
+		{name: "fraction", ok: true},
+		{name: "huge", ok: true},
+		{name: "word", ok: true},
+		{name: "bool", ok: true},
+		{name: "nested", ok: true},
+		{name: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := m.Int(tt.name)
+			if ok != tt.ok || !reflect.DeepEqual(v, tt.expect) {
+				t.Errorf("Int(%q) = %v, %t", tt.name, v, ok)
+			}
+		})
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func TestMetadata_Float64(t *testing.T) {
+	var m Metadata
+	if err := json.Unmarshal([]byte(`{
+		"duration": 12.5,
+		"count": 3,
+		"string": "0.25",
+		"nan": "NaN",
+		"word": "three",
+		"bool": true,
+		"nested": {"a": 1}
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	float64Ptr := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name   string
+		expect *float64
+		ok     bool
+	}{
+		{name: "duration", expect: float64Ptr(12.5), ok: true},
+		{name: "count", expect: float64Ptr(3), ok: true},
+		{name: "string", expect: float64Ptr(0.25), ok: true},
+		{name: "nan", ok: true},
+		{name: "word", ok: true},
+		{name: "bool", ok: true},
+		{name: "nested", ok: true},
+		{name: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := m.Float64(tt.name)
+			if ok != tt.ok || !reflect.DeepEqual(v, tt.expect) {
+				t.Errorf("Float64(%q) = %v, %t", tt.name, v, ok)
+			}
+		})
+	}
+}
+
+func TestMetadata_Bool(t *testing.T) {
+	var m Metadata
+	if err := json.Unmarshal([]byte(`{
+		"enabled": true,
+		"disabled": false,
+		"string": "true",
+		"word": "yes",
+		"number": 1
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		expect *bool
+		ok     bool
+	}{
+		{name: "enabled", expect: &yes, ok: true},
+		{name: "disabled", expect: &no, ok: true},
+		{name: "string", expect: &yes, ok: true},
+		{name: "word", ok: true},
+		{name: "number", ok: true},
+		{name: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := m.Bool(tt.name)
+			if ok != tt.ok || !reflect.DeepEqual(v, tt.expect) {
+				t.Errorf("Bool(%q) = %v, %t", tt.name, v, ok)
+			}
+		})
+	}
+}
+
+func TestMetadata_StringSlice(t *testing.T) {
+	var m Metadata
+	if err := json.Unmarshal([]byte(`{
+		"array": ["openshift/origin", "openshift/api"],
+		"empty-array": [],
+		"mixed-array": ["a", 1],
+		"comma": "openshift/origin, openshift/api,,",
+		"single": "openshift/origin",
+		"number": 1
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		expect []string
+		ok     bool
+	}{
+		{name: "array", expect: []string{"openshift/origin", "openshift/api"}, ok: true},
+		{name: "empty-array", expect: []string{}, ok: true},
+		{name: "mixed-array", ok: true},
+		{name: "comma", expect: []string{"openshift/origin", "openshift/api"}, ok: true},
+		{name: "single", expect: []string{"openshift/origin"}, ok: true},
+		{name: "number", ok: true},
+		{name: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := m.StringSlice(tt.name)
+			if ok != tt.ok || !reflect.DeepEqual(v, tt.expect) {
+				t.Errorf("StringSlice(%q) = %#v, %t", tt.name, v, ok)
+			}
+		})
+	}
+}
+
+func TestFinished_State(t *testing.T) {
+	passed, failed := true, false
+	tests := []struct {
+		name                         string
+		passed                       *bool
+		state                        string
+		hasPassed, hasFailed, hasErr bool
+	}{
+		{name: "passed", passed: &passed, state: "success", hasPassed: true},
+		{name: "failed", passed: &failed, state: "failed", hasFailed: true},
+		{name: "not reported", state: "error", hasErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Finished{Passed: tt.passed}
+			if f.State() != tt.state || f.HasPassed() != tt.hasPassed || f.HasFailed() != tt.hasFailed || f.HasError() != tt.hasErr {
+				t.Errorf("unexpected state %s passed=%t failed=%t error=%t", f.State(), f.HasPassed(), f.HasFailed(), f.HasError())
+			}
+		})
+	}
+}
+
+func TestFinished_IsComplete(t *testing.T) {
+	passed, failed := true, false
+	tests := []struct {
+		name                        string
+		timestamp                   *int64
+		passed                      *bool
+		state                       string
+		complete, success, isFailed bool
+	}{
+		{name: "no timestamp, no result", state: "error"},
+		{name: "no timestamp, passed", passed: &passed, state: "success"},
+		{name: "no timestamp, failed", passed: &failed, state: "failed"},
+		{name: "zero timestamp, passed", timestamp: int64Ptr(0), passed: &passed, state: "success"},
+		{name: "timestamp, no result", timestamp: int64Ptr(1705320000), state: "error", complete: true, isFailed: true},
+		{name: "timestamp, passed", timestamp: int64Ptr(1705320000), passed: &passed, state: "success", complete: true, success: true},
+		{name: "timestamp, failed", timestamp: int64Ptr(1705320000), passed: &failed, state: "failed", complete: true, isFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Finished{Timestamp: tt.timestamp, Passed: tt.passed}
+			if f.State() != tt.state || f.IsComplete() != tt.complete || f.IsSuccess() != tt.success || f.IsFailed() != tt.isFailed {
+				t.Errorf("unexpected state %s complete=%t success=%t failed=%t", f.State(), f.IsComplete(), f.IsSuccess(), f.IsFailed())
+			}
+		})
+	}
+}
+
+func TestFinished_Time(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp *int64
+		expect    time.Time
+		ok        bool
+	}{
+		{name: "nil"},
+		{name: "zero", timestamp: int64Ptr(0)},
+		{name: "valid", timestamp: int64Ptr(1705320000), expect: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Finished{Timestamp: tt.timestamp}
+			got, ok := f.Time()
+			if ok != tt.ok || got != tt.expect {
+				t.Errorf("Time() = %v, %t, want %v, %t", got, ok, tt.expect, tt.ok)
+			}
+			if f.IsComplete() != tt.ok {
+				t.Errorf("IsComplete() = %t, want %t", f.IsComplete(), tt.ok)
+			}
+			func() {
+				defer func() {
+					if r := recover(); (r != nil) == tt.ok {
+						t.Errorf("MustTime() panicked: %v", r)
+					}
+				}()
+				if got := f.MustTime(); got != tt.expect {
+					t.Errorf("MustTime() = %v, want %v", got, tt.expect)
+				}
+			}()
+		})
+	}
+}
+
+func TestMetadata_JSONPath(t *testing.T) {
+	var m Metadata
+	if err := json.Unmarshal([]byte(`{
+		"repo": "openshift/origin",
+		"repos": {"openshift/origin": {"base_ref": "master", "pulls": 2}},
+		"versions.json": {"installer": "4.15.3"},
+		"back\\slash": "value",
+		"list": ["a"]
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	m["child"] = Metadata{"grandchild": Metadata{"key": "nested"}}
+
+	tests := []struct {
+		path   string
+		expect interface{}
+		ok     bool
+	}{
+		{path: "repo", expect: "openshift/origin", ok: true},
+		{path: "repos.openshift/origin.base_ref", expect: "master", ok: true},
+		{path: "repos.openshift/origin.pulls", expect: float64(2), ok: true},
+		{path: "child.grandchild.key", expect: "nested", ok: true},
+		{path: `versions\.json.installer`, expect: "4.15.3", ok: true},
+		{path: `back\\slash`, expect: "value", ok: true},
+		{path: "repos.openshift/installer.base_ref"},
+		{path: "repo.base_ref"},
+		{path: "list.0"},
+		{path: "versions.json.installer"},
+		{path: "missing"},
+		{path: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			v, ok := m.JSONPath(tt.path)
+			if ok != tt.ok || !reflect.DeepEqual(v, tt.expect) {
+				t.Errorf("JSONPath(%q) = %#v, %t", tt.path, v, ok)
+			}
+		})
+	}
+
+	if s, ok := m.StringAt("repos.openshift/origin.base_ref"); !ok || s == nil || *s != "master" {
+		t.Errorf("unexpected StringAt result %v %t", s, ok)
+	}
+	if s, ok := m.StringAt("repos.openshift/origin.pulls"); !ok || s != nil {
+		t.Errorf("expected a non-string value to return nil, true: %v %t", s, ok)
+	}
+	if s, ok := m.StringAt("repos.missing"); ok || s != nil {
+		t.Errorf("expected a missing path to return nil, false: %v %t", s, ok)
+	}
+}
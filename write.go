@@ -0,0 +1,279 @@
+package cisearch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrPreconditionFailed is returned when an index entry could not be
+// created because it already exists.
+var ErrPreconditionFailed = errors.New("index entry already exists")
+
+// IsPreconditionFailure returns true if err was caused by an index entry
+// already existing.
+func IsPreconditionFailure(err error) bool {
+	return errors.Is(err, ErrPreconditionFailed)
+}
+
+// wrapPreconditionFailure wraps GCS precondition failures with
+// ErrPreconditionFailed and returns other errors unchanged.
+func wrapPreconditionFailure(err error) error {
+	if isGoogleAPICode(err, http.StatusPreconditionFailed) {
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	}
+	return err
+}
+
+// ErrContentConflict is returned by WriteIndexWithCAS when an index entry
+// keeps changing to content other than what is being written.
+var ErrContentConflict = errors.New("index entry has conflicting content")
+
+// IndexWriter writes index entries into a single bucket.
+type IndexWriter struct {
+	Client StorageClient
+	Bucket string
+	// Compress stores the JSON entries gzip compressed with a gzip
+	// Content-Encoding.
+	Compress bool
+	// StorageClass and KMSKeyName, if set, override the bucket defaults for
+	// the entries that are written.
+	StorageClass string
+	KMSKeyName   string
+}
+
+// Write stores data and metadata at path, subject to conds if non-nil. The
+// indexer-version attribute is set to IndexerVersion unless metadata already
+// has one.
+func (w IndexWriter) Write(ctx context.Context, path string, data []byte, metadata map[string]string, conds *storage.Conditions) error {
+	if w.Compress {
+		compressed, err := compressJSON(data)
+		if err != nil {
+			return fmt.Errorf("unable to compress %s: %v", path, err)
+		}
+		data = compressed
+	}
+	o := w.Client.NewWriter(ctx, w.Bucket, path, w.objectAttrs(metadata), conds)
+	if _, err := o.Write(data); err != nil {
+		closeFailedWriter(o, path)
+		return err
+	}
+	return o.Close()
+}
+
+// objectAttrs returns the attributes of an entry written with metadata. They
+// also describe entries composed from objects written by w, since gzip
+// members can be concatenated.
+func (w IndexWriter) objectAttrs(metadata map[string]string) storage.ObjectAttrs {
+	attrs := storage.ObjectAttrs{Metadata: withIndexerVersion(metadata), StorageClass: w.StorageClass, KMSKeyName: w.KMSKeyName}
+	if w.Compress {
+		attrs.ContentType = "application/json"
+		attrs.ContentEncoding = "gzip"
+	}
+	return attrs
+}
+
+// withIndexerVersion returns a copy of metadata with the indexer-version
+// attribute that ReindexOlderThan relies on.
+func withIndexerVersion(metadata map[string]string) map[string]string {
+	versioned := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		versioned[k] = v
+	}
+	if _, ok := versioned["indexer-version"]; !ok {
+		versioned["indexer-version"] = IndexerVersion
+	}
+	return versioned
+}
+
+// closeFailedWriter closes the writer of name after a write failed. The
+// error is logged rather than returned so that the write error is reported.
+func closeFailedWriter(w io.Closer, name string) {
+	if err := w.Close(); err != nil {
+		log.Printf("warn: Unable to close %s after a failed write: %v", name, err)
+	}
+}
+
+// WriteIndexWithCAS creates the index entry at path. If an entry already
+// exists with the same content the write is treated as successful, which
+// makes retried events idempotent. If the existing content differs it is
+// replaced only if it has not changed since it was read, retrying up to
+// maxCASRetries times before returning ErrContentConflict.
+func WriteIndexWithCAS(ctx context.Context, writer IndexWriter, path string, data []byte, metadata map[string]string, maxCASRetries int) error {
+	conds := storage.Conditions{DoesNotExist: true}
+	for retries := 0; ; {
+		err := writer.Write(ctx, path, data, metadata, &conds)
+		if !isGoogleAPICode(err, http.StatusPreconditionFailed) {
+			return err
+		}
+		attrs, err := writer.Client.Attrs(ctx, writer.Bucket, path)
+		if err == storage.ErrObjectNotExist {
+			conds = storage.Conditions{DoesNotExist: true}
+		} else if err != nil {
+			return fmt.Errorf("unable to check existing index entry %s: %v", path, err)
+		} else {
+			// compare the decoded content, since compressed entries differ
+			// in their encoding even when the content is the same
+			existing, err := readIndexEntry(ctx, writer.Client, writer.Bucket, path)
+			if err != nil && err != storage.ErrObjectNotExist {
+				return fmt.Errorf("unable to read existing index entry %s: %v", path, err)
+			}
+			if err == nil && bytes.Equal(existing, data) {
+				return nil
+			}
+			conds = storage.Conditions{GenerationMatch: attrs.Generation}
+		}
+		if retries >= maxCASRetries {
+			return fmt.Errorf("%w: %s", ErrContentConflict, path)
+		}
+		retries++
+	}
+}
+
+// maxComposeParts is the largest number of objects GCS will compose at once.
+const maxComposeParts = 32
+
+// WriteJobResultAtomic writes the concatenation of parts to indexPath with
+// the given metadata, as IndexWriter.WriteAtomic does.
+func WriteJobResultAtomic(ctx context.Context, client StorageClient, bucket, indexPath string, parts [][]byte, metadata map[string]string) error {
+	return IndexWriter{Client: client, Bucket: bucket}.WriteAtomic(ctx, indexPath, parts, metadata)
+}
+
+// WriteAtomic writes the concatenation of parts to indexPath with the given
+// metadata. Each part is uploaded to a temporary object under index/.tmp and
+// the parts are then composed into indexPath, so readers never observe a
+// partially written entry. The temporary objects are removed whether or not
+// the compose succeeds.
+func (w IndexWriter) WriteAtomic(ctx context.Context, indexPath string, parts [][]byte, metadata map[string]string) error {
+	if len(parts) == 0 || len(parts) > maxComposeParts {
+		return fmt.Errorf("%s must be written from between 1 and %d parts, not %d", indexPath, maxComposeParts, len(parts))
+	}
+	client, bucket := w.Client, w.Bucket
+	prefix := tempObjectPrefix()
+	var sources []string
+	defer func() {
+		for _, source := range sources {
+			if err := client.Delete(ctx, bucket, source); err != nil {
+				log.Printf("warn: Unable to delete temporary object %s: %v", source, err)
+			}
+		}
+	}()
+	for i, part := range parts {
+		source := path.Join(prefix, strconv.Itoa(i))
+		if err := w.Write(ctx, source, part, nil, &storage.Conditions{DoesNotExist: true}); err != nil {
+			return fmt.Errorf("failed to write part %d of %s: %v", i, indexPath, err)
+		}
+		sources = append(sources, source)
+	}
+	if err := client.Compose(ctx, bucket, indexPath, sources, w.objectAttrs(metadata), nil); err != nil {
+		return fmt.Errorf("failed to compose %s: %v", indexPath, err)
+	}
+	return nil
+}
+
+// tempObjectPrefix returns a unique prefix under index/.tmp for staging
+// objects before they are composed into their final location.
+func tempObjectPrefix() string {
+	return path.Join("index", ".tmp", fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63()))
+}
+
+// IndexEntry is the content of an index entry to be written.
+type IndexEntry struct {
+	Path     string
+	Data     []byte
+	Metadata map[string]string
+}
+
+// MultiWriteError reports the entries written by WriteMultipleIndexPaths
+// when some could not be.
+type MultiWriteError struct {
+	Succeeded []string
+	Failed    map[string]error
+}
+
+func (e *MultiWriteError) Error() string {
+	paths := make([]string, 0, len(e.Failed))
+	for path := range e.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	messages := make([]string, 0, len(paths))
+	for _, path := range paths {
+		messages = append(messages, fmt.Sprintf("%s: %v", path, e.Failed[path]))
+	}
+	return fmt.Sprintf("wrote %d of %d index entries: %s", len(e.Succeeded), len(e.Succeeded)+len(paths), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the error of each failed entry.
+func (e *MultiWriteError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// WriteMultipleIndexPaths creates each of entries, as
+// IndexWriter.WriteMultiple does.
+func WriteMultipleIndexPaths(ctx context.Context, client StorageClient, bucket string, entries []IndexEntry) error {
+	return IndexWriter{Client: client, Bucket: bucket}.WriteMultiple(ctx, entries)
+}
+
+// WriteMultiple creates each of entries, which must not already exist. The
+// content of every entry is uploaded to a temporary object
+// before any entry is created, so the remaining work is a compose per entry
+// and a timeout is unlikely to leave only some of them written. If an
+// upload fails no entry is created. If some entries cannot be created a
+// *MultiWriteError lists those that were. The temporary objects are always
+// removed.
+func (w IndexWriter) WriteMultiple(ctx context.Context, entries []IndexEntry) error {
+	client, bucket := w.Client, w.Bucket
+	prefix := tempObjectPrefix()
+	var sources []string
+	defer func() {
+		for _, source := range sources {
+			if err := client.Delete(ctx, bucket, source); err != nil {
+				log.Printf("warn: Unable to delete temporary object %s: %v", source, err)
+			}
+		}
+	}()
+	for i, entry := range entries {
+		source := path.Join(prefix, strconv.Itoa(i))
+		if err := w.Write(ctx, source, entry.Data, nil, &storage.Conditions{DoesNotExist: true}); err != nil {
+			return fmt.Errorf("failed to stage %s: %v", entry.Path, err)
+		}
+		sources = append(sources, source)
+	}
+	multiErr := &MultiWriteError{Failed: make(map[string]error)}
+	for i, entry := range entries {
+		if err := client.Compose(ctx, bucket, entry.Path, sources[i:i+1], w.objectAttrs(entry.Metadata), &storage.Conditions{DoesNotExist: true}); err != nil {
+			multiErr.Failed[entry.Path] = wrapPreconditionFailure(err)
+			continue
+		}
+		multiErr.Succeeded = append(multiErr.Succeeded, entry.Path)
+	}
+	if len(multiErr.Failed) > 0 {
+		return multiErr
+	}
+	return nil
+}
+
+// isGoogleAPICode returns true if err is a GCS API error with the given HTTP
+// status code.
+func isGoogleAPICode(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
@@ -0,0 +1,438 @@
+package cisearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// conflictingClient replaces the object with new content every time its
+// attributes are read, simulating a concurrent writer.
+type conflictingClient struct {
+	*FakeGCSClient
+}
+
+func (c conflictingClient) Attrs(ctx context.Context, bucket, name string) (*storage.ObjectAttrs, error) {
+	attrs, err := c.FakeGCSClient.Attrs(ctx, bucket, name)
+	if err == nil {
+		c.put(bucket, name, []byte(`{"state":"error"}`), nil)
+	}
+	return attrs, err
+}
+
+func TestWriteIndexWithCAS(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []byte
+		conflict   bool
+		retries    int
+		wantErr    error
+		expectData string
+	}{
+		{
+			name:       "new entry",
+			expectData: `{"state":"success"}`,
+		},
+		{
+			name:       "idempotent",
+			existing:   []byte(`{"state":"success"}`),
+			expectData: `{"state":"success"}`,
+		},
+		{
+			name:       "replace different content",
+			existing:   []byte(`{"state":"failed"}`),
+			retries:    1,
+			expectData: `{"state":"success"}`,
+		},
+		{
+			name:       "different content without retries",
+			existing:   []byte(`{"state":"failed"}`),
+			wantErr:    ErrContentConflict,
+			expectData: `{"state":"failed"}`,
+		},
+		{
+			name:       "concurrent conflict",
+			existing:   []byte(`{"state":"failed"}`),
+			conflict:   true,
+			retries:    3,
+			wantErr:    ErrContentConflict,
+			expectData: `{"state":"error"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeGCSClient()
+			if tt.existing != nil {
+				fake.put("bucket", testStatePath, tt.existing, nil)
+			}
+			var client StorageClient = fake
+			if tt.conflict {
+				client = conflictingClient{fake}
+			}
+			err := WriteIndexWithCAS(context.TODO(), IndexWriter{Client: client, Bucket: "bucket"}, testStatePath, []byte(`{"state":"success"}`), map[string]string{"state": "success"}, tt.retries)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WriteIndexWithCAS() error = %v, want %v", err, tt.wantErr)
+			}
+			if obj := fake.get("bucket", testStatePath); obj == nil || string(obj.data) != tt.expectData {
+				t.Errorf("unexpected index entry: %#v", obj)
+			}
+		})
+	}
+}
+
+func TestWriteIndexWithCAS_Compressed(t *testing.T) {
+	client := NewFakeGCSClient()
+	writer := IndexWriter{Client: client, Bucket: "bucket", Compress: true}
+	data := []byte(`{"state":"success"}`)
+	if err := WriteIndexWithCAS(context.TODO(), writer, testStatePath, data, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	generation := client.get("bucket", testStatePath).attrs.Generation
+	if err := WriteIndexWithCAS(context.TODO(), writer, testStatePath, data, nil, 0); err != nil {
+		t.Fatalf("expected an identical compressed entry to be accepted: %v", err)
+	}
+	if client.get("bucket", testStatePath).attrs.Generation != generation {
+		t.Error("expected the identical entry not to be rewritten")
+	}
+}
+
+func TestIsPreconditionFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "nil", err: nil},
+		{name: "412", err: wrapPreconditionFailure(&googleapi.Error{Code: http.StatusPreconditionFailed}), expect: true},
+		{name: "wrapped 412", err: fmt.Errorf("failed to link: %w", wrapPreconditionFailure(&googleapi.Error{Code: http.StatusPreconditionFailed})), expect: true},
+		{name: "403", err: wrapPreconditionFailure(&googleapi.Error{Code: http.StatusForbidden})},
+		{name: "other error", err: wrapPreconditionFailure(errors.New("connection reset"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPreconditionFailure(tt.err); got != tt.expect {
+				t.Errorf("IsPreconditionFailure(%v) = %t", tt.err, got)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_PreconditionFailure(t *testing.T) {
+	tests := []struct {
+		name         string
+		closeErr     error
+		precondition bool
+	}{
+		{name: "already indexed", precondition: true},
+		{name: "server error", closeErr: &googleapi.Error{Code: http.StatusServiceUnavailable}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeGCSClient()
+			client.put("bucket", testFinishedName, []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+			client.put("bucket", testStatePath, []byte(`{}`), nil)
+			client.closeErr = tt.closeErr
+			opts := DefaultOptions()
+			opts.Client = client
+			err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: testFinishedName}, opts)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if IsPreconditionFailure(err) != tt.precondition {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// failingComposeClient fails every compose.
+type failingComposeClient struct {
+	*FakeGCSClient
+}
+
+func (c failingComposeClient) Compose(ctx context.Context, bucket, name string, sources []string, attrs storage.ObjectAttrs, conds *storage.Conditions) error {
+	return errors.New("compose failed")
+}
+
+func TestWriteJobResultAtomic(t *testing.T) {
+	client := NewFakeGCSClient()
+	parts := [][]byte{[]byte(`{"state":"success",`), []byte(`"completed_at":1705320000,`), []byte(`"link":"gs://bucket/logs/job/1000"}`)}
+	if err := WriteJobResultAtomic(context.TODO(), client, "bucket", testStatePath, parts, map[string]string{"state": "success"}); err != nil {
+		t.Fatal(err)
+	}
+	obj := client.get("bucket", testStatePath)
+	if obj == nil {
+		t.Fatal("expected index entry")
+	}
+	if expect := `{"state":"success","completed_at":1705320000,"link":"gs://bucket/logs/job/1000"}`; string(obj.data) != expect {
+		t.Errorf("unexpected content %s", obj.data)
+	}
+	if obj.attrs.Metadata["state"] != "success" || obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+		t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+	}
+	if tmp, _ := client.List(context.TODO(), "bucket", &storage.Query{Prefix: "index/.tmp/"}); len(tmp) != 0 {
+		t.Errorf("expected temporary objects to be removed, found %d", len(tmp))
+	}
+
+	// the composed entry has the attributes of the writer
+	writer := IndexWriter{Client: client, Bucket: "bucket", Compress: true, StorageClass: "NEARLINE", KMSKeyName: "key"}
+	if err := writer.WriteAtomic(context.TODO(), testStatePath, parts, nil); err != nil {
+		t.Fatal(err)
+	}
+	obj = client.get("bucket", testStatePath)
+	if obj.attrs.StorageClass != "NEARLINE" || obj.attrs.KMSKeyName != "key" || obj.attrs.ContentEncoding != "gzip" || obj.attrs.Metadata["indexer-version"] != IndexerVersion {
+		t.Errorf("unexpected attributes %#v", obj.attrs)
+	}
+	if data, err := decodeContent(obj.data); err != nil || string(data) != `{"state":"success","completed_at":1705320000,"link":"gs://bucket/logs/job/1000"}` {
+		t.Errorf("unexpected content %s: %v", data, err)
+	}
+}
+
+func TestWriteJobResultAtomic_Failure(t *testing.T) {
+	client := NewFakeGCSClient()
+	err := WriteJobResultAtomic(context.TODO(), failingComposeClient{client}, "bucket", testStatePath, [][]byte{[]byte("a"), []byte("b")}, nil)
+	if err == nil {
+		t.Fatal("expected compose to fail")
+	}
+	if client.get("bucket", testStatePath) != nil {
+		t.Error("expected no index entry")
+	}
+	if tmp, _ := client.List(context.TODO(), "bucket", &storage.Query{Prefix: "index/.tmp/"}); len(tmp) != 0 {
+		t.Errorf("expected temporary objects to be removed, found %d", len(tmp))
+	}
+	if err := WriteJobResultAtomic(context.TODO(), client, "bucket", "index/x", nil, nil); err == nil {
+		t.Error("expected an error without parts")
+	}
+}
+
+func TestWriteMultipleIndexPaths(t *testing.T) {
+	entries := []IndexEntry{
+		{Path: testStatePath, Data: []byte(`{"state":"success"}`), Metadata: map[string]string{"state": "success"}},
+		{Path: "index/unknown/job-state/2024-01-15T12:00:00Z/job/1000", Data: []byte(`{"state":"success"}`), Metadata: map[string]string{"state": "success"}},
+		{Path: "index/variant/ovn/2024-01-15T12:00:00Z/job/1000", Data: []byte(`{}`)},
+	}
+	noTemporaryObjects := func(client *FakeGCSClient) {
+		t.Helper()
+		if tmp, _ := client.List(context.TODO(), "bucket", &storage.Query{Prefix: "index/.tmp/"}); len(tmp) != 0 {
+			t.Errorf("expected temporary objects to be removed, found %d", len(tmp))
+		}
+	}
+
+	client := NewFakeGCSClient()
+	if err := WriteMultipleIndexPaths(context.TODO(), client, "bucket", entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		obj := client.get("bucket", entry.Path)
+		if obj == nil || string(obj.data) != string(entry.Data) || !reflect.DeepEqual(obj.attrs.Metadata, withIndexerVersion(entry.Metadata)) {
+			t.Errorf("unexpected entry %s: %#v", entry.Path, obj)
+		}
+	}
+	noTemporaryObjects(client)
+
+	// partial failure
+	client = NewFakeGCSClient()
+	client.put("bucket", entries[1].Path, []byte(`old`), nil)
+	err := WriteMultipleIndexPaths(context.TODO(), client, "bucket", entries)
+	var multiErr *MultiWriteError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("expected a MultiWriteError, got %v", err)
+	}
+	if !reflect.DeepEqual(multiErr.Succeeded, []string{entries[0].Path, entries[2].Path}) {
+		t.Errorf("unexpected succeeded paths %v", multiErr.Succeeded)
+	}
+	if len(multiErr.Failed) != 1 || !IsPreconditionFailure(multiErr.Failed[entries[1].Path]) || !IsPreconditionFailure(err) {
+		t.Errorf("unexpected failures %v", multiErr.Failed)
+	}
+	if obj := client.get("bucket", entries[1].Path); string(obj.data) != "old" {
+		t.Errorf("expected existing entry to be unchanged, got %s", obj.data)
+	}
+	noTemporaryObjects(client)
+
+	// failure to stage writes nothing
+	client = NewFakeGCSClient()
+	client.writeErr = errors.New("unavailable")
+	if err := WriteMultipleIndexPaths(context.TODO(), client, "bucket", entries); err == nil || errors.As(err, &multiErr) {
+		t.Fatalf("expected a staging error, got %v", err)
+	}
+	if objects, _ := client.List(context.TODO(), "bucket", nil); len(objects) != 0 {
+		t.Errorf("expected nothing to be written, found %d objects", len(objects))
+	}
+}
+
+func TestIndexWriter_Compress(t *testing.T) {
+	const content = `{"state":"success","completed_at":1705320000}`
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			client := NewFakeGCSClient()
+			writer := IndexWriter{Client: client, Bucket: "bucket", Compress: compress}
+			if err := writer.Write(context.TODO(), "index/entry", []byte(content), map[string]string{"state": "success"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			obj := client.get("bucket", "index/entry")
+			if compressed := len(obj.data) > 2 && obj.data[0] == 0x1f && obj.data[1] == 0x8b; compressed != compress {
+				t.Errorf("expected compressed=%t, got %q", compress, obj.data)
+			}
+			if encoding := obj.attrs.ContentEncoding; (encoding == "gzip") != compress || compress && obj.attrs.ContentType != "application/json" {
+				t.Errorf("unexpected content type %q and encoding %q", obj.attrs.ContentType, encoding)
+			}
+			if obj.attrs.Metadata["state"] != "success" {
+				t.Errorf("unexpected metadata %v", obj.attrs.Metadata)
+			}
+			data, err := readIndexEntry(context.TODO(), client, "bucket", "index/entry")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != content {
+				t.Errorf("unexpected content %q", data)
+			}
+		})
+	}
+}
+
+func TestIndexJobsWithOptions_StorageClass(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Client = NewFakeGCSClient()
+	opts.StorageClass = "NEARLINE"
+	opts.KMSKeyName = "projects/p/locations/global/keyRings/ci/cryptoKeys/index"
+	if obj := indexTestJobState(t, opts, `{"timestamp":1705320000,"passed":true}`); obj.attrs.StorageClass != opts.StorageClass || obj.attrs.KMSKeyName != opts.KMSKeyName {
+		t.Fatalf("expected the entry to be written with the configured storage class and key, got %+v", obj)
+	}
+}
+
+func TestIndexJobsWithOptions_CompressIndex(t *testing.T) {
+	const job = "release-openshift-origin-installer-e2e-aws-upgrade"
+	client := NewFakeGCSClient()
+	for _, build := range []string{"1000", "1001"} {
+		client.put("bucket", "logs/"+job+"/"+build+"/finished.json", []byte(`{"timestamp":1705320000,"passed":true}`), nil)
+		client.put("bucket", "logs/"+job+"/"+build+"/artifacts/metrics/job_metrics.json", []byte(testJobMetrics), nil)
+	}
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.CompressIndex = true
+	for _, build := range []string{"1000", "1001"} {
+		for _, name := range []string{"finished.json", "artifacts/metrics/job_metrics.json"} {
+			if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: "logs/" + job + "/" + build + "/" + name}, opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, name := range []string{"index/job-state/2024-01-15T12:00:00Z/" + job + "/1000", "index/job-metrics/2024-01-15T12:00:00Z/" + job + "/1000"} {
+		if obj := client.get("bucket", name); obj == nil || obj.attrs.ContentEncoding != "gzip" {
+			t.Fatalf("expected compressed entry %s", name)
+		}
+	}
+
+	// readers of the index decompress the entries
+	if err := AnnotateJobResult(context.TODO(), client, "bucket", "index/job-state/2024-01-15T12:00:00Z/"+job+"/1000", map[string]string{"triage": "flake"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := ExportIndexToJSONL(context.TODO(), client, "bucket", "bucket", "2024-01-15", "export"); err != nil || count != 2 {
+		t.Fatalf("exported %d entries: %v", count, err)
+	}
+}
+
+const testLabeledJobMetrics = `{"job:duration:total:seconds":{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1705320000,"3600"]}]}},
+	"cluster:nodes":{"status":"success","data":{"resultType":"vector","result":[{"metric":{"role":"master"},"value":[1705320000,"3"]},{"metric":{"role":"worker"},"value":[1705320000,"6"]}]}}}`
+
+// rejectingClient fails every write to an object whose name contains reject.
+type rejectingClient struct {
+	*FakeGCSClient
+	reject string
+}
+
+func (c rejectingClient) NewWriter(ctx context.Context, bucket, name string, attrs storage.ObjectAttrs, conds *storage.Conditions) io.WriteCloser {
+	if strings.Contains(name, c.reject) {
+		return rejectedWriter{}
+	}
+	return c.FakeGCSClient.NewWriter(ctx, bucket, name, attrs, conds)
+}
+
+type rejectedWriter struct{}
+
+func (rejectedWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (rejectedWriter) Close() error                { return errors.New("rejected") }
+
+func TestIndexJobsWithOptions_WritePerMetric(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	const suffix = "/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000"
+	client := NewFakeGCSClient()
+	client.put("bucket", name, []byte(testLabeledJobMetrics), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.WritePerMetric = true
+	opts.WriteWorkers = 2
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := client.List(context.TODO(), "bucket", &storage.Query{Prefix: "index/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected the combined entry and 3 metric entries, got %d", len(entries))
+	}
+	obj := client.get("bucket", "index/metrics/"+url.PathEscape(`cluster:nodes{role="worker"}`)+suffix)
+	if obj == nil {
+		t.Fatal("expected an entry for the worker nodes")
+	}
+	var m OutputMetric
+	if err := json.Unmarshal(obj.data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Value != "6" || m.Labels["role"] != "worker" || obj.attrs.Metadata["link"] == "" {
+		t.Errorf("unexpected metric entry %+v with metadata %v", m, obj.attrs.Metadata)
+	}
+
+	// metric entries that already exist are left unchanged
+	client.Delete(context.TODO(), "bucket", "index/job-metrics"+suffix)
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexJobsWithOptions_WritePerMetricFailure(t *testing.T) {
+	const name = "logs/release-openshift-origin-installer-e2e-aws-upgrade/1000/artifacts/metrics/job_metrics.json"
+	client := rejectingClient{FakeGCSClient: NewFakeGCSClient(), reject: "cluster:nodes"}
+	client.put("bucket", name, []byte(testLabeledJobMetrics), nil)
+	opts := DefaultOptions()
+	opts.Client = client
+	opts.WritePerMetric = true
+	err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts)
+	var aggErr *AggregateError
+	if !errors.As(err, &aggErr) || len(aggErr.Errors) != 2 {
+		t.Fatalf("expected both cluster:nodes writes to fail, got %v", err)
+	}
+	for indexPath := range aggErr.Errors {
+		if !strings.HasPrefix(indexPath, "index/metrics/cluster:nodes") {
+			t.Errorf("unexpected failed write %s", indexPath)
+		}
+	}
+	if client.get("bucket", "index/metrics/job:duration:total:seconds/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000") == nil {
+		t.Error("expected the other metric entries to be written")
+	}
+	if client.get("bucket", "index/job-metrics/2024-01-15T12:00:00Z/release-openshift-origin-installer-e2e-aws-upgrade/1000") != nil {
+		t.Error("expected the build not to be marked as indexed")
+	}
+
+	// the redelivered event writes the entries that failed
+	client.reject = "\x00"
+	opts.Client = client
+	if err := IndexJobsWithOptions(context.TODO(), GCSEvent{Bucket: "bucket", Name: name}, opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := client.List(context.TODO(), "bucket", &storage.Query{Prefix: "index/"})
+	if err != nil || len(entries) != 4 {
+		t.Errorf("expected every entry to be written on redelivery, got %d: %v", len(entries), err)
+	}
+}